@@ -0,0 +1,464 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/internal/options"
+	"github.com/rjnienaber/gphotos_downloader/internal/services"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type runFunc func(a *app) error
+
+type command struct {
+	name    string
+	summary string
+	// setup registers the flags of the command and returns the function that runs it
+	setup func(flags *flag.FlagSet, opts *options.Options) runFunc
+	// recorded commands are stored in the runs table, so they show up in the history
+	recorded bool
+	// readOnly commands don't lock the library, so they can run alongside a sync
+	readOnly bool
+}
+
+var commands = []command{
+	{name: "auth", summary: "authorise access to a google photos library", setup: authCommand},
+	{name: "sync", recorded: true, summary: "index new media items and albums, download everything not yet downloaded", setup: syncCommand},
+	{name: "retry-failed", recorded: true, summary: "refresh and download media items that have not been downloaded yet", setup: retryFailedCommand},
+	{name: "status", readOnly: true, summary: "show a summary of the library", setup: statusCommand},
+	{name: "verify", summary: "check downloaded files are still present and complete", setup: verifyCommand},
+	{name: "reindex", recorded: true, summary: "index the whole library again to pick up missed media items, then sync", setup: reindexCommand},
+	{name: "reconcile", recorded: true, summary: "list the whole library to download missed media items and apply the deletion policy", setup: reconcileCommand},
+	{name: "relayout", summary: "move downloaded files to match a new layout template", setup: relayoutCommand},
+	{name: "daemon", summary: "sync on a schedule until stopped, keeping the api and download workers between passes", setup: daemonCommand},
+	{name: "history", readOnly: true, summary: "list recent syncs with their counts and errors", setup: historyCommand},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func authCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	force := flags.Bool("force", false, "discard the stored token and authorise again")
+
+	return func(a *app) error {
+		tokenService, err := a.tokenService()
+		if err != nil {
+			return err
+		}
+
+		if *force {
+			_, err = tokenService.Authorize(a.ctx)
+		} else {
+			_, err = tokenService.TokenSource(a.ctx)
+		}
+		if err != nil {
+			return withExitCode(exitAuth, err)
+		}
+
+		a.logger.Info.Print("authorisation completed")
+		return nil
+	}
+}
+
+func syncCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+	opts.RegisterFullSyncFlags(flags)
+	opts.RegisterDryRunFlags(flags)
+
+	return func(a *app) error {
+		if a.opts.DryRun {
+			return planSync(a)
+		}
+		return runSync(a)
+	}
+}
+
+// planSync prints what a sync would index and download, without changing the library
+func planSync(a *app) error {
+	planner, err := a.syncPlanner()
+	if err != nil {
+		return err
+	}
+
+	plan, err := planner.Plan(a.ctx)
+	if err != nil {
+		return withExitCode(exitSync, err)
+	}
+
+	for _, download := range plan.Downloads {
+		kind := "pending"
+		if download.New {
+			kind = "new"
+		}
+		notes := ""
+		if download.Renamed {
+			notes += " (renamed, the layout's filename is taken)"
+		}
+		if download.Overwrites {
+			notes += " (overwrites a file not in the library)"
+		}
+		_, _ = fmt.Fprintf(a.out, "%s\t%s%s\n", kind, download.Path(), notes)
+	}
+
+	listing := "searched for new media items"
+	if plan.FullListing {
+		listing = "listed the whole library"
+	}
+	_, _ = fmt.Fprintf(a.out, "%s: %d media items, %d already indexed\n", listing, plan.Listed, plan.AlreadyIndexed)
+	_, _ = fmt.Fprintf(a.out, "%d files would be downloaded, %d new and %d pending from earlier runs, estimated %s\n",
+		len(plan.Downloads), plan.New, plan.Pending, utils.FormatBytes(plan.EstimatedSize))
+	if plan.Renamed > 0 || plan.Overwrites > 0 {
+		_, _ = fmt.Fprintf(a.out, "%d files renamed to avoid clashes, %d files not in the library would be overwritten\n", plan.Renamed, plan.Overwrites)
+	}
+	if a.opts.IncludeShared {
+		_, _ = fmt.Fprint(a.out, "media items of shared albums that aren't indexed yet aren't included, a sync finds them when it lists the albums\n")
+	}
+	return nil
+}
+
+func runSync(a *app) error {
+	// the layout is resolved by these, so a changed one is refused before anything is downloaded
+	syncService, err := a.syncService()
+	if err != nil {
+		return err
+	}
+
+	albumService, err := a.albumService()
+	if err != nil {
+		return err
+	}
+
+	reconcileService, err := a.reconcileService()
+	if err != nil {
+		return err
+	}
+
+	undownloadedService, err := a.undownloadedService()
+	if err != nil {
+		return err
+	}
+
+	if a.opts.FullSyncDays >= 0 {
+		err = a.db.Settings.UpdateFullSyncDays(a.opts.FullSyncDays)
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+	}
+
+	err = undownloadedService.Update(a.ctx)
+	if err != nil {
+		return withExitCode(exitSync, err)
+	}
+
+	template, err := a.layoutTemplate()
+	if err != nil {
+		return err
+	}
+
+	// album names are needed to lay out media items, so albums are indexed first, and even when
+	// they aren't linked if the layout uses them
+	syncAlbums := a.opts.AlbumLinks != services.NoLinks || template.UsesAlbum()
+	if syncAlbums {
+		err = albumService.Sync(a.ctx)
+		if err != nil {
+			return withExitCode(exitSync, err)
+		}
+	}
+
+	err = syncService.Sync(a.ctx)
+	if err != nil {
+		return withExitCode(exitSync, err)
+	}
+
+	// searching by creation date misses media items uploaded long after they were taken
+	fullSyncDue, err := reconcileService.Due()
+	if err != nil {
+		return withExitCode(exitDatabase, err)
+	}
+	if fullSyncDue {
+		a.logger.Info.Print("full sync is due, listing the whole library")
+		report, err := reconcileService.Reconcile(a.ctx, false)
+		if err != nil {
+			return withExitCode(exitSync, err)
+		}
+		a.logger.Info.Printf("full sync listed %d media items: %d added, %d deleted, %d restored",
+			report.Listed, report.Added, report.Deleted, report.Restored)
+	}
+
+	if syncAlbums {
+		err = albumService.IndexShared()
+		if err != nil {
+			return withExitCode(exitSync, err)
+		}
+	}
+
+	// albums can only link to files once they have been downloaded
+	a.finishDownloads()
+	err = albumService.Link()
+	if err != nil {
+		return withExitCode(exitSync, err)
+	}
+
+	a.logger.Info.Print("sync completed")
+	return a.progress.Counts().WriteSummary(a.out)
+}
+
+func retryFailedCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+
+	return func(a *app) error {
+		undownloadedService, err := a.undownloadedService()
+		if err != nil {
+			return err
+		}
+
+		err = undownloadedService.Update(a.ctx)
+		if err != nil {
+			return withExitCode(exitSync, err)
+		}
+
+		a.finishDownloads()
+		a.logger.Info.Print("retry completed")
+		return a.progress.Counts().WriteSummary(a.out)
+	}
+}
+
+func daemonCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+	opts.RegisterFullSyncFlags(flags)
+	opts.RegisterDaemonFlags(flags)
+
+	return func(a *app) error {
+		a.logger.Info.Printf("syncing on schedule '%s'", a.opts.Schedule)
+		scheduler := services.NewScheduler(a.opts.Schedule, stopsDaemon, a.logger)
+		return scheduler.Run(a.ctx, func() error {
+			a.progress.Reset()
+			finishRun := a.startRun("daemon")
+			err := runSync(a)
+			finishRun(err)
+			return err
+		})
+	}
+}
+
+// stopsDaemon reports whether a pass failed in a way later passes can't recover from without help
+func stopsDaemon(err error) bool {
+	switch exitCodeFor(err) {
+	case exitUsage, exitConfig, exitAuth:
+		return true
+	}
+	return false
+}
+
+func statusCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterStatusFlags(flags)
+
+	return func(a *app) error {
+		counts, err := a.db.MediaItems.Counts(a.opts.IncludeShared)
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+
+		lastIndex, err := a.db.Settings.LastIndex()
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+
+		layoutTemplate, err := a.db.Settings.LayoutTemplate()
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+		if layoutTemplate == "" {
+			layoutTemplate = "not chosen yet"
+		}
+
+		lastIndexText := "never"
+		if lastIndex != (time.Time{}) {
+			lastIndexText = lastIndex.Format(time.RFC3339)
+		}
+
+		writer := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintf(writer, "library:\t%s\n", a.opts.LibraryRoot)
+		_, _ = fmt.Fprintf(writer, "layout:\t%s\n", layoutTemplate)
+		_, _ = fmt.Fprintf(writer, "last index:\t%s\n", lastIndexText)
+		_, _ = fmt.Fprintf(writer, "media items:\t%d\n", counts.Total)
+		_, _ = fmt.Fprintf(writer, "downloaded:\t%d\n", counts.Downloaded)
+		_, _ = fmt.Fprintf(writer, "pending:\t%d\n", counts.Pending)
+		_, _ = fmt.Fprintf(writer, "failed:\t%d\n", counts.Failed)
+		_, _ = fmt.Fprintf(writer, "deleted:\t%d\n", counts.Deleted)
+		return writer.Flush()
+	}
+}
+
+func verifyCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	quick := flags.Bool("quick", false, "only check files are present and have the right size, without re-hashing them")
+	refetch := flags.Bool("refetch", false, "mark files with problems as not downloaded, so the next sync downloads them again")
+
+	return func(a *app) error {
+		verifyService := a.verifyService()
+		report, err := verifyService.Verify(services.VerifyOptions{Checksums: !*quick, MarkNotDownloaded: *refetch})
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+
+		for _, problem := range report.Problems {
+			_, _ = fmt.Fprintf(a.out, "%s: %s\n", problem.Problem, problem.Path)
+		}
+		if report.Unhashed > 0 {
+			_, _ = fmt.Fprintf(a.out, "%d files were downloaded without a checksum, only their size was checked\n", report.Unhashed)
+		}
+		_, _ = fmt.Fprintf(a.out, "checked %d files, %d problems found\n", report.Checked, len(report.Problems))
+		if report.Marked > 0 {
+			_, _ = fmt.Fprintf(a.out, "%d files will be downloaded again by the next sync\n", report.Marked)
+		}
+
+		if len(report.Problems) > 0 {
+			return withExitCode(exitVerify, fmt.Errorf("verification found %d problems", len(report.Problems)))
+		}
+		return nil
+	}
+}
+
+func reindexCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+	opts.RegisterFullSyncFlags(flags)
+
+	return func(a *app) error {
+		a.logger.Info.Print("clearing last index, the whole library will be listed again")
+		err := a.db.Settings.UpdateLastIndex(time.Time{})
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+		return runSync(a)
+	}
+}
+
+func reconcileCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+	opts.RegisterReconcileFlags(flags)
+
+	return func(a *app) error {
+		if a.opts.DeletionPolicy != "" {
+			err := a.db.Settings.UpdateDeletionPolicy(string(a.opts.DeletionPolicy))
+			if err != nil {
+				return withExitCode(exitDatabase, err)
+			}
+		}
+		if a.opts.TrashDays >= 0 {
+			err := a.db.Settings.UpdateTrashRetentionDays(a.opts.TrashDays)
+			if err != nil {
+				return withExitCode(exitDatabase, err)
+			}
+		}
+
+		reconcileService, err := a.reconcileService()
+		if err != nil {
+			return err
+		}
+
+		report, err := reconcileService.Reconcile(a.ctx, a.opts.Force)
+		if err != nil {
+			return withExitCode(exitSync, err)
+		}
+
+		// media items missed by previous syncs are downloaded before reporting
+		a.finishDownloads()
+		_, _ = fmt.Fprintf(a.out, "listed %d media items: %d added, %d deleted, %d restored, %d purged from the trash\n",
+			report.Listed, report.Added, report.Deleted, report.Restored, report.Purged)
+		return nil
+	}
+}
+
+func relayoutCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterRelayoutFlags(flags)
+
+	return func(a *app) error {
+		template, err := layout.Parse(a.opts.Layout)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+
+		relayoutService := a.relayoutService()
+		plan, err := relayoutService.Plan(template)
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+
+		if a.opts.DryRun {
+			for _, move := range plan.Moves {
+				_, _ = fmt.Fprintf(a.out, "%s -> %s\n", move.From(), move.To())
+			}
+			_, _ = fmt.Fprintf(a.out, "%d media items would be moved, %d unchanged\n", len(plan.Moves), plan.Unchanged)
+			return nil
+		}
+
+		err = relayoutService.Apply(plan)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(a.out, "%d media items moved, %d unchanged\n", len(plan.Moves), plan.Unchanged)
+		if len(plan.Moves) > 0 {
+			a.logger.Info.Print("album links are updated by the next sync")
+		}
+		return nil
+	}
+}
+
+func historyCommand(flags *flag.FlagSet, opts *options.Options) runFunc {
+	opts.RegisterLibraryFlags(flags)
+	limit := flags.Int("limit", 20, "number of runs to list, newest first")
+
+	return func(a *app) error {
+		if *limit < 1 {
+			return withExitCode(exitUsage, fmt.Errorf("-limit must be at least 1"))
+		}
+
+		runs, err := a.db.Runs.Recent(*limit)
+		if err != nil {
+			return withExitCode(exitDatabase, err)
+		}
+
+		writer := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprint(writer, "started\tmode\tduration\tindexed\tdownloaded\tfailed\tbytes\tresult\n")
+		for _, run := range runs {
+			duration, result := "-", "unfinished"
+			if !run.FinishedAt.IsZero() {
+				duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String()
+				result = "ok"
+				if run.Error != "" {
+					result = run.Error
+				}
+			}
+
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", run.StartedAt.Local().Format(time.RFC3339),
+				run.Mode, duration, run.Indexed, run.Downloaded, run.Failed, utils.FormatBytes(run.Bytes), result)
+		}
+		return writer.Flush()
+	}
+}
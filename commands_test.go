@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/options"
+	"github.com/rjnienaber/gphotos_downloader/internal/services"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/fake"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestApp wires up an app for a new library whose api is the fake server
+func createTestApp(t *testing.T, server *fake.Server, opts options.Options, out io.Writer) *app {
+	opts.LibraryRoot = t.TempDir()
+	logger := utils.NewLogger(utils.Silent)
+	a, err := wireUp(context.Background(), opts, logger, out, io.Discard)
+	assert.NoError(t, err)
+	t.Cleanup(a.Close)
+
+	photosApi := googlephotos.NewPhotosApi(googlephotos.Options{BaseUrl: server.BaseUrl(), Client: server.Client(), Limiter: a.rateLimiter(), Logger: logger})
+	a.api = &photosApi
+	a.bandwidth = services.NewBandwidthLimiter(services.BandwidthSchedule{}, logger)
+	return a
+}
+
+func TestPlanSyncLeavesTheLibraryUnchanged(t *testing.T) {
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+	server.AddMediaItems(fake.NewMediaItem("item0", "IMG_0000.jpg", time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC), "content"))
+
+	out := bytes.Buffer{}
+	a := createTestApp(t, server, options.Options{DryRun: true, IncludeShared: true}, &out)
+	rootDir := a.opts.LibraryRoot
+
+	databasePath := filepath.Join(rootDir, database.GooglePhotosDatabaseFile)
+	before, err := os.ReadFile(databasePath)
+	assert.NoError(t, err)
+
+	assert.NoError(t, planSync(a))
+
+	after, err := os.ReadFile(databasePath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	entries, err := os.ReadDir(rootDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Contains(t, out.String(), "new\t"+filepath.Join("2021", "12", "03", "IMG_0000.jpg"))
+	assert.Contains(t, out.String(), "media items of shared albums that aren't indexed yet aren't included")
+}
+
+func TestRunSyncIndexesAlbumsForTheLayoutWithoutLinkingThem(t *testing.T) {
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+	server.AddMediaItems(fake.NewMediaItem("item0", "IMG_0000.jpg", time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC), "content"))
+	server.AddAlbum(fake.Album{Album: models.Album{Id: "holiday", Title: "Holiday"}, MediaItemIds: []string{"item0"}})
+
+	a := createTestApp(t, server, options.Options{Workers: 1, AlbumLinks: services.NoLinks, Layout: "{album}/{filename}"}, io.Discard)
+	assert.NoError(t, runSync(a))
+
+	content, err := os.ReadFile(filepath.Join(a.opts.LibraryRoot, "Holiday", "IMG_0000.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	assert.NoDirExists(t, filepath.Join(a.opts.LibraryRoot, services.AlbumsDir))
+}
+
+func TestSweepStagingOnlyWhenTheLibraryIsLocked(t *testing.T) {
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+	a := createTestApp(t, server, options.Options{Workers: 1}, io.Discard)
+
+	partial := filepath.Join(a.opts.LibraryRoot, services.StagingDir, "item0.part")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(partial), 0755))
+	assert.NoError(t, os.WriteFile(partial, []byte("content"), 0644))
+	modified := time.Now().Add(-2 * staleTempFileAge)
+	assert.NoError(t, os.Chtimes(partial, modified, modified))
+
+	// another run may be downloading to it
+	a.sweepStaging()
+	assert.FileExists(t, partial)
+
+	a.locked = true
+	a.sweepStaging()
+	assert.NoFileExists(t, partial)
+}
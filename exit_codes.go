@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	photoOauth "github.com/rjnienaber/gphotos_downloader/internal/oauth2"
+)
+
+// exit codes returned by the binary, one per class of failure
+const (
+	exitOk       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitConfig   = 3
+	exitAuth     = 4
+	exitDatabase = 5
+	exitSync     = 6
+	exitVerify   = 7
+	exitLocked   = 8
+	// exitInterrupted follows the shell convention for a process stopped by SIGINT
+	exitInterrupted = 130
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode classifies err, keeping any classification made closer to where it happened
+func withExitCode(code int, err error) error {
+	var exitErr exitError
+	if err == nil || errors.As(err, &exitErr) {
+		return err
+	}
+	// a token revoked while syncing is still an authorisation problem
+	if errors.Is(err, photoOauth.ErrTokenRevoked) {
+		code = exitAuth
+	}
+	if errors.Is(err, context.Canceled) {
+		code = exitInterrupted
+	}
+	return exitError{code: code, err: err}
+}
+
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOk
+	}
+
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitFailure
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type albums struct {
+	sqlFuncs SqlFuncs
+	logger   utils.Logger
+}
+
+type Album struct {
+	Uuid      string
+	RemoteId  string
+	Title     string
+	LocalPath string
+	ItemCount int
+	SyncedAt  time.Time
+	Shared    bool
+}
+
+func (a *albums) Save(items ...*Album) error {
+	var values []string
+	var params []interface{}
+	for _, item := range items {
+		if item.Uuid == "" {
+			newUUid, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+			item.Uuid = newUUid.String()
+		}
+
+		params = append(params, item.Uuid, item.RemoteId, item.Title, item.LocalPath, item.ItemCount)
+		params = append(params, item.SyncedAt.Format(time.RFC3339Nano), item.Shared)
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?)")
+	}
+
+	insertSql := "INSERT INTO albums VALUES" + strings.Join(values, ", ")
+	return a.sqlFuncs.Exec(insertSql, params...)
+}
+
+func (a *albums) Update(album Album) error {
+	updateSql := "UPDATE albums SET title = ?, local_path = ?, item_count = ?, synced_at = ?, shared = ? WHERE uuid = ?"
+	return a.sqlFuncs.Exec(updateSql, album.Title, album.LocalPath, album.ItemCount, album.SyncedAt.Format(time.RFC3339Nano), album.Shared, album.Uuid)
+}
+
+// Delete removes an album and which media items belong to it, the media items themselves are kept
+func (a *albums) Delete(albumUuid string) error {
+	err := a.sqlFuncs.Exec("DELETE FROM album_items WHERE album_uuid = ?", albumUuid)
+	if err != nil {
+		return err
+	}
+	return a.sqlFuncs.Exec("DELETE FROM albums WHERE uuid = ?", albumUuid)
+}
+
+func (a *albums) GetAll() ([]Album, error) {
+	query := "SELECT uuid, remote_id, title, local_path, item_count, synced_at, shared FROM albums"
+
+	var albums []Album
+	mapper := func(row Scanner) (err error) {
+		var album Album
+		var syncedAt sql.NullString
+		err = row.Scan(&album.Uuid, &album.RemoteId, &album.Title, &album.LocalPath, &album.ItemCount, &syncedAt, &album.Shared)
+		if err == nil {
+			err = parseTime(syncedAt, &album.SyncedAt)
+		}
+		if err == nil {
+			albums = append(albums, album)
+		}
+		return
+	}
+
+	err := a.sqlFuncs.Query(mapper, query)
+	if err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// ReplaceItems sets the media items belonging to an album, in album order
+func (a *albums) ReplaceItems(albumUuid string, mediaItemRemoteIds []string) error {
+	err := a.sqlFuncs.Exec("DELETE FROM album_items WHERE album_uuid = ?", albumUuid)
+	if err != nil || len(mediaItemRemoteIds) == 0 {
+		return err
+	}
+
+	// inserted in chunks to stay well below sqlite's limit on bound parameters
+	const chunkSize = 500
+	for start := 0; start < len(mediaItemRemoteIds); start += chunkSize {
+		end := min(start+chunkSize, len(mediaItemRemoteIds))
+
+		var values []string
+		var params []interface{}
+		for position := start; position < end; position++ {
+			params = append(params, albumUuid, mediaItemRemoteIds[position], position)
+			values = append(values, "(?, ?, ?)")
+		}
+
+		// the same item can be returned more than once while paging, so duplicates are ignored
+		insertSql := "INSERT OR IGNORE INTO album_items VALUES" + strings.Join(values, ", ")
+		err = a.sqlFuncs.Exec(insertSql, params...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDownloadedItems returns the downloaded media items of an album, in album order
+func (a *albums) GetDownloadedItems(albumUuid string) ([]MediaItem, error) {
+	query := "SELECT " + mediaItemColumns + ` FROM album_items
+			  INNER JOIN media_items ON media_items.remote_id = album_items.media_item_remote_id
+			  WHERE album_items.album_uuid = ? AND media_items.downloaded = 1 AND media_items.deleted_at IS NULL
+			  ORDER BY album_items.position`
+
+	return selectMediaItems(&a.sqlFuncs, query, albumUuid)
+}
+
+// TitleForMediaItem returns the title of an album the media item belongs to, empty if it isn't in
+// any. Items in several albums always get the same one
+func (a *albums) TitleForMediaItem(remoteId string) (title string, err error) {
+	query := `SELECT COALESCE((SELECT albums.title FROM album_items
+			  INNER JOIN albums ON albums.uuid = album_items.album_uuid
+			  WHERE album_items.media_item_remote_id = ?
+			  ORDER BY albums.title, albums.remote_id LIMIT 1), '')`
+	err = a.sqlFuncs.QueryRow(query, []interface{}{remoteId}, func(row Scanner) error {
+		return row.Scan(&title)
+	})
+	return
+}
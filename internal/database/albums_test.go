@@ -0,0 +1,128 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestAlbum(t *testing.T, title string) Album {
+	item := CreateTestMediaItem(t)
+	return Album{
+		RemoteId:  "album-" + item.RemoteId,
+		Title:     title,
+		LocalPath: "albums/" + title,
+		ItemCount: 2,
+		SyncedAt:  timeMustParse(t, "2021-12-03T19:54:05Z"),
+	}
+}
+
+func TestSaveAndRetrieveAlbums(t *testing.T) {
+	db := CreateTestDatabase(t)
+	album := createTestAlbum(t, "Holiday")
+
+	err := db.Albums.Save(&album)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, album.Uuid)
+
+	albums, err := db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Album{album}, albums)
+}
+
+func TestUpdateAlbum(t *testing.T) {
+	db := CreateTestDatabase(t)
+	album := createTestAlbum(t, "Holiday")
+	assert.NoError(t, db.Albums.Save(&album))
+
+	album.Title = "Summer Holiday"
+	album.LocalPath = "albums/Summer Holiday"
+	album.ItemCount = 10
+	assert.NoError(t, db.Albums.Update(album))
+
+	albums, err := db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Album{album}, albums)
+}
+
+func TestDeleteAlbum(t *testing.T) {
+	db := CreateTestDatabase(t)
+	holiday := createTestAlbum(t, "Holiday")
+	family := createTestAlbum(t, "Family")
+	assert.NoError(t, db.Albums.Save(&holiday, &family))
+	item := CreateTestMediaItem(t)
+	assert.NoError(t, db.MediaItems.Save(&item))
+	assert.NoError(t, db.Albums.ReplaceItems(holiday.Uuid, []string{item.RemoteId}))
+
+	assert.NoError(t, db.Albums.Delete(holiday.Uuid))
+
+	albums, err := db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Album{family}, albums)
+	title, err := db.Albums.TitleForMediaItem(item.RemoteId)
+	assert.NoError(t, err)
+	assert.Empty(t, title)
+	exists, err := db.MediaItems.Exists(item.RemoteId)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestAlbumDownloadedItemsAreReturnedInAlbumOrder(t *testing.T) {
+	db := CreateTestDatabase(t)
+	album := createTestAlbum(t, "Holiday")
+	assert.NoError(t, db.Albums.Save(&album))
+
+	first := CreateTestMediaItem(t)
+	second := CreateTestMediaItem(t)
+	notDownloaded := CreateTestMediaItem(t)
+	notDownloaded.Downloaded = false
+	assert.NoError(t, db.MediaItems.Save(&first, &second, &notDownloaded))
+
+	err := db.Albums.ReplaceItems(album.Uuid, []string{second.RemoteId, notDownloaded.RemoteId, "not-indexed-yet", first.RemoteId})
+	assert.NoError(t, err)
+
+	items, err := db.Albums.GetDownloadedItems(album.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, []MediaItem{second, first}, items)
+}
+
+func TestReplaceAlbumItems(t *testing.T) {
+	db := CreateTestDatabase(t)
+	album := createTestAlbum(t, "Holiday")
+	assert.NoError(t, db.Albums.Save(&album))
+
+	first := CreateTestMediaItem(t)
+	second := CreateTestMediaItem(t)
+	assert.NoError(t, db.MediaItems.Save(&first, &second))
+
+	assert.NoError(t, db.Albums.ReplaceItems(album.Uuid, []string{first.RemoteId, first.RemoteId}))
+	assert.NoError(t, db.Albums.ReplaceItems(album.Uuid, []string{second.RemoteId}))
+
+	items, err := db.Albums.GetDownloadedItems(album.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, []MediaItem{second}, items)
+
+	assert.NoError(t, db.Albums.ReplaceItems(album.Uuid, nil))
+	items, err = db.Albums.GetDownloadedItems(album.Uuid)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestTitleForMediaItem(t *testing.T) {
+	db := CreateTestDatabase(t)
+	holiday := createTestAlbum(t, "Holiday")
+	family := createTestAlbum(t, "Family")
+	assert.NoError(t, db.Albums.Save(&holiday, &family))
+
+	item := CreateTestMediaItem(t)
+	assert.NoError(t, db.Albums.ReplaceItems(holiday.Uuid, []string{item.RemoteId}))
+	assert.NoError(t, db.Albums.ReplaceItems(family.Uuid, []string{item.RemoteId}))
+
+	title, err := db.Albums.TitleForMediaItem(item.RemoteId)
+	assert.NoError(t, err)
+	assert.Equal(t, "Family", title)
+
+	title, err = db.Albums.TitleForMediaItem("not-in-an-album")
+	assert.NoError(t, err)
+	assert.Equal(t, "", title)
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type apiUsage struct {
+	sqlFuncs SqlFuncs
+	logger   utils.Logger
+}
+
+// ApiUsage is the number of requests made against the api quotas on one day
+type ApiUsage struct {
+	Day              string
+	MetadataRequests int64
+	MediaRequests    int64
+}
+
+// Get returns the usage of day, zero when no requests were made on it
+func (a *apiUsage) Get(day string) (usage ApiUsage, err error) {
+	query := "SELECT day, metadata_requests, media_requests FROM api_usage WHERE day = ?"
+	err = a.sqlFuncs.QueryRow(query, []interface{}{day}, func(row Scanner) error {
+		return row.Scan(&usage.Day, &usage.MetadataRequests, &usage.MediaRequests)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ApiUsage{Day: day}, nil
+	}
+	return
+}
+
+// Increment adds requests to the usage of day
+func (a *apiUsage) Increment(day string, metadataRequests, mediaRequests int64) error {
+	upsertSql := `INSERT INTO api_usage VALUES(?, ?, ?)
+		ON CONFLICT(day) DO UPDATE SET metadata_requests = metadata_requests + excluded.metadata_requests,
+		media_requests = media_requests + excluded.media_requests`
+	return a.sqlFuncs.Exec(upsertSql, day, metadataRequests, mediaRequests)
+}
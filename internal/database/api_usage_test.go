@@ -0,0 +1,31 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiUsageIsZeroForNewDay(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	usage, err := db.ApiUsage.Get("2021-12-03")
+	assert.NoError(t, err)
+	assert.Equal(t, ApiUsage{Day: "2021-12-03"}, usage)
+}
+
+func TestApiUsageIncrementsPerDay(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	assert.NoError(t, db.ApiUsage.Increment("2021-12-03", 1, 0))
+	assert.NoError(t, db.ApiUsage.Increment("2021-12-03", 2, 5))
+	assert.NoError(t, db.ApiUsage.Increment("2021-12-04", 0, 1))
+
+	usage, err := db.ApiUsage.Get("2021-12-03")
+	assert.NoError(t, err)
+	assert.Equal(t, ApiUsage{Day: "2021-12-03", MetadataRequests: 3, MediaRequests: 5}, usage)
+
+	usage, err = db.ApiUsage.Get("2021-12-04")
+	assert.NoError(t, err)
+	assert.Equal(t, ApiUsage{Day: "2021-12-04", MediaRequests: 1}, usage)
+}
@@ -20,6 +20,9 @@ type PhotoDatabase struct {
 	databasePath string
 	Settings     settings
 	MediaItems   mediaItems
+	Albums       albums
+	ApiUsage     apiUsage
+	Runs         runs
 	Logger       utils.Logger
 }
 
@@ -41,15 +44,44 @@ func NewDatabase(opts ...Option) (db PhotoDatabase, err error) {
 		}
 	}
 
+	db.bind(db.connection)
+	err = initialize(&db)
+	return
+}
+
+func (db *PhotoDatabase) bind(connection executor) {
 	sqlFuncs := SqlFuncs{
-		connection: db.connection,
+		connection: connection,
 		logger:     db.Logger,
 	}
 
 	db.Settings = settings{sqlFuncs: sqlFuncs, logger: db.Logger}
 	db.MediaItems = mediaItems{sqlFuncs: sqlFuncs, logger: db.Logger}
-	err = initialize(&db)
-	return
+	db.Albums = albums{sqlFuncs: sqlFuncs, logger: db.Logger}
+	db.ApiUsage = apiUsage{sqlFuncs: sqlFuncs, logger: db.Logger}
+	db.Runs = runs{sqlFuncs: sqlFuncs, logger: db.Logger}
+}
+
+// Transaction runs fn with a copy of the database whose queries all run in one transaction. The
+// transaction is committed if fn succeeds and rolled back if it returns an error
+func (db *PhotoDatabase) Transaction(fn func(tx PhotoDatabase) error) (err error) {
+	sqlTx, err := db.connection.Begin()
+	if err != nil {
+		return
+	}
+
+	tx := *db
+	tx.bind(sqlTx)
+	err = fn(tx)
+	if err != nil {
+		rollbackErr := sqlTx.Rollback()
+		if rollbackErr != nil {
+			db.Logger.Error.Printf("failed to roll back transaction: %s", rollbackErr)
+		}
+		return
+	}
+
+	return sqlTx.Commit()
 }
 
 func initialize(db *PhotoDatabase) (err error) {
@@ -101,6 +133,8 @@ func WithInMemoryConnection() Option {
 	return func(db *PhotoDatabase) (err error) {
 		conn, err := sql.Open("sqlite3", "file::memory:")
 		if err == nil {
+			// every connection to :memory: opens a database of its own
+			conn.SetMaxOpenConns(1)
 			db.connection = conn
 		}
 		return
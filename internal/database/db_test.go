@@ -1,6 +1,7 @@
 package database
 
 import (
+	"errors"
 	"io/fs"
 	"os"
 	"testing"
@@ -34,3 +35,32 @@ func TestWithFileConnection(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, AppDatabaseVersion(), version)
 }
+
+func TestTransactionCommits(t *testing.T) {
+	db := CreateTestDatabase(t)
+	item := CreateTestMediaItem(t)
+
+	err := db.Transaction(func(tx PhotoDatabase) error {
+		return tx.MediaItems.Save(&item)
+	})
+	assert.NoError(t, err)
+
+	exists, err := db.MediaItems.Exists(item.RemoteId)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestTransactionRollsBackOnError(t *testing.T) {
+	db := CreateTestDatabase(t)
+	item := CreateTestMediaItem(t)
+
+	err := db.Transaction(func(tx PhotoDatabase) error {
+		assert.NoError(t, tx.MediaItems.Save(&item))
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+
+	exists, err := db.MediaItems.Exists(item.RemoteId)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -29,6 +29,40 @@ type MediaItem struct {
 	ModifiedAt    time.Time
 	SyncedAt      time.Time
 	LastError     string
+	Source        string
+	Contributor   string
+	CameraMake    string
+	CameraModel   string
+	// Sha256 is the hex encoded checksum of the downloaded file
+	Sha256 string
+	// DeletedAt is when the media item was found to be deleted from google photos
+	DeletedAt time.Time
+	// PartialBytes and PartialValidator describe an interrupted download that can be resumed
+	PartialBytes     int64
+	PartialValidator string
+}
+
+// where a media item was found
+const (
+	SourceLibrary = "library"
+	SourceShared  = "shared"
+)
+
+// MediaItemCounts counts media items that still exist in google photos, deleted ones are only
+// counted in Deleted
+type MediaItemCounts struct {
+	Total      int
+	Downloaded int
+	Pending    int
+	Failed     int
+	Deleted    int
+}
+
+// FileSizes are the average sizes in bytes of downloaded photos and videos, zero when none of that
+// kind have been downloaded
+type FileSizes struct {
+	Photo int64
+	Video int64
 }
 
 type MediaItemIds struct {
@@ -36,6 +70,9 @@ type MediaItemIds struct {
 	RemoteId string
 }
 
+const mediaItemColumns = `uuid, remote_id, base_url, mime_type, filename, description, downloaded,
+					 local_path, local_filename, file_size, created_at, modified_at, synced_at, last_error, source, contributor, camera_make, camera_model, sha256, deleted_at, partial_bytes, partial_validator`
+
 func (m MediaItem) IsPhoto() bool {
 	return !strings.Contains(m.MimeType, "video")
 }
@@ -52,11 +89,18 @@ func (m *mediaItems) Save(items ...*MediaItem) error {
 			item.Uuid = newUUid.String()
 		}
 
+		source := item.Source
+		if source == "" {
+			source = SourceLibrary
+		}
+
 		params = append(params, item.Uuid, item.RemoteId, item.BaseUrl, item.MimeType, item.Filename)
 		params = append(params, item.Description, item.Downloaded, item.LocalPath, item.LocalFilename, item.FileSize)
 		params = append(params, item.CreatedAt.Format(time.RFC3339Nano), item.ModifiedAt.Format(time.RFC3339Nano))
-		params = append(params, item.SyncedAt.Format(time.RFC3339Nano), item.LastError)
-		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		params = append(params, item.SyncedAt.Format(time.RFC3339Nano), item.LastError, source, item.Contributor)
+		params = append(params, item.CameraMake, item.CameraModel, item.Sha256, formatOptionalTime(item.DeletedAt))
+		params = append(params, item.PartialBytes, item.PartialValidator)
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	}
 
 	insertSql := "INSERT INTO media_items VALUES" + strings.Join(values, ", ")
@@ -66,9 +110,7 @@ func (m *mediaItems) Save(items ...*MediaItem) error {
 }
 
 func (m *mediaItems) Get(id string) (mediaItem MediaItem, err error) {
-	query := `SELECT uuid, remote_id, base_url, mime_type, filename, description, downloaded, 
-					 local_path, local_filename, file_size, created_at, modified_at, synced_at, last_error 
-			  FROM media_items WHERE uuid = ?`
+	query := "SELECT " + mediaItemColumns + " FROM media_items WHERE uuid = ?"
 	args := []interface{}{id}
 
 	err = m.sqlFuncs.QueryRow(query, args, mediaItemRowMapper(&mediaItem))
@@ -80,9 +122,29 @@ func (m *mediaItems) Get(id string) (mediaItem MediaItem, err error) {
 	return
 }
 
-func (m *mediaItems) MarkAsSynced(id string, fileSize int64) error {
-	updateSql := "UPDATE media_items SET downloaded = ?, file_size = ?, synced_at = ?, last_error = '' WHERE uuid = ?"
-	return m.sqlFuncs.Exec(updateSql, true, fileSize, time.Now().Format(time.RFC3339Nano), id)
+func (m *mediaItems) MarkAsSynced(id string, fileSize int64, sha256 string) error {
+	updateSql := `UPDATE media_items SET downloaded = ?, file_size = ?, sha256 = ?, synced_at = ?, last_error = '',
+						 partial_bytes = 0, partial_validator = '' WHERE uuid = ?`
+	return m.sqlFuncs.Exec(updateSql, true, fileSize, sha256, time.Now().Format(time.RFC3339Nano), id)
+}
+
+// MarkAsNotDownloaded queues a media item to be downloaded again, recording why
+func (m *mediaItems) MarkAsNotDownloaded(id string, reason string) error {
+	updateSql := `UPDATE media_items SET downloaded = ?, file_size = 0, sha256 = '', last_error = ?,
+						 partial_bytes = 0, partial_validator = '' WHERE uuid = ?`
+	return m.sqlFuncs.Exec(updateSql, false, reason, id)
+}
+
+// UpdatePartial records how much of an interrupted download is in the staging directory, and the
+// validator the server has to match for it to be resumed
+func (m *mediaItems) UpdatePartial(id string, partialBytes int64, validator string) error {
+	updateSql := "UPDATE media_items SET partial_bytes = ?, partial_validator = ? WHERE uuid = ?"
+	return m.sqlFuncs.Exec(updateSql, partialBytes, validator, id)
+}
+
+func (m *mediaItems) UpdateLocation(id string, localPath string, localFilename string) error {
+	updateSql := "UPDATE media_items SET local_path = ?, local_filename = ? WHERE uuid = ?"
+	return m.sqlFuncs.Exec(updateSql, localPath, localFilename, id)
 }
 
 func (m *mediaItems) MarkAsErrored(id string, err error) error {
@@ -90,8 +152,27 @@ func (m *mediaItems) MarkAsErrored(id string, err error) error {
 	return m.sqlFuncs.Exec(updateSql, err.Error(), id)
 }
 
-func (m *mediaItems) GetNonDownloadedIds() (mediaItemIds []MediaItemIds, err error) {
-	selectSql := "SELECT uuid, remote_id FROM media_items WHERE downloaded = 0"
+// MarkAsDeleted records that a media item was deleted from google photos
+func (m *mediaItems) MarkAsDeleted(id string, deletedAt time.Time) error {
+	updateSql := "UPDATE media_items SET deleted_at = ? WHERE uuid = ?"
+	return m.sqlFuncs.Exec(updateSql, deletedAt.Format(time.RFC3339Nano), id)
+}
+
+// Restore clears the deletion of a media item that is back in google photos
+func (m *mediaItems) Restore(id string) error {
+	updateSql := "UPDATE media_items SET deleted_at = NULL WHERE uuid = ?"
+	return m.sqlFuncs.Exec(updateSql, id)
+}
+
+// GetNonDownloadedIds returns the ids of media items still to be downloaded, items from
+// shared albums are only included when includeShared is set
+func (m *mediaItems) GetNonDownloadedIds(includeShared bool) (mediaItemIds []MediaItemIds, err error) {
+	selectSql := "SELECT uuid, remote_id FROM media_items WHERE downloaded = 0 AND deleted_at IS NULL"
+	var args []interface{}
+	if !includeShared {
+		selectSql += " AND source <> ?"
+		args = append(args, SourceShared)
+	}
 
 	mapper := func(row Scanner) (mapperError error) {
 		var ids MediaItemIds
@@ -102,18 +183,89 @@ func (m *mediaItems) GetNonDownloadedIds() (mediaItemIds []MediaItemIds, err err
 		return
 	}
 
-	err = m.sqlFuncs.Query(mapper, selectSql)
+	err = m.sqlFuncs.Query(mapper, selectSql, args...)
 	if err != nil {
 		mediaItemIds = nil
 	}
 	return
 }
 
+func (m *mediaItems) Exists(remoteId string) (exists bool, err error) {
+	query := "SELECT EXISTS(SELECT 1 FROM media_items WHERE remote_id = ?)"
+	err = m.sqlFuncs.QueryRow(query, []interface{}{remoteId}, func(row Scanner) error {
+		return row.Scan(&exists)
+	})
+	return
+}
+
+// LocationUsed reports whether a media item, deleted or not, is at the location
+func (m *mediaItems) LocationUsed(localPath string, localFilename string) (used bool, err error) {
+	query := "SELECT EXISTS(SELECT 1 FROM media_items WHERE local_path = ? AND local_filename = ?)"
+	err = m.sqlFuncs.QueryRow(query, []interface{}{localPath, localFilename}, func(row Scanner) error {
+		return row.Scan(&used)
+	})
+	return
+}
+
+// RemoteIds returns the remote ids of every media item, deleted or not
+func (m *mediaItems) RemoteIds() (remoteIds map[string]bool, err error) {
+	remoteIds = map[string]bool{}
+	mapper := func(row Scanner) (mapperError error) {
+		var remoteId string
+		mapperError = row.Scan(&remoteId)
+		if mapperError == nil {
+			remoteIds[remoteId] = true
+		}
+		return
+	}
+
+	err = m.sqlFuncs.Query(mapper, "SELECT remote_id FROM media_items")
+	if err != nil {
+		remoteIds = nil
+	}
+	return
+}
+
 func (m *mediaItems) GetAll() ([]MediaItem, error) {
-	query := `SELECT uuid, remote_id, base_url, mime_type, filename, description, downloaded,
-					 local_path, local_filename, file_size, created_at, modified_at, synced_at, last_error
+	return selectMediaItems(&m.sqlFuncs, "SELECT "+mediaItemColumns+" FROM media_items")
+}
+
+func (m *mediaItems) GetDownloaded() ([]MediaItem, error) {
+	return selectMediaItems(&m.sqlFuncs, "SELECT "+mediaItemColumns+" FROM media_items WHERE downloaded = 1")
+}
+
+// GetDownloadedWithSize returns the downloaded media items whose files have fileSize bytes
+func (m *mediaItems) GetDownloadedWithSize(fileSize int) ([]MediaItem, error) {
+	return selectMediaItems(&m.sqlFuncs, "SELECT "+mediaItemColumns+" FROM media_items WHERE downloaded = 1 AND file_size = ?", fileSize)
+}
+
+// Counts counts the media items of the library. Pending and Failed only include media items from
+// shared albums when includeShared is set, like GetNonDownloadedIds
+func (m *mediaItems) Counts(includeShared bool) (counts MediaItemCounts, err error) {
+	query := `SELECT COALESCE(SUM(CASE WHEN deleted_at IS NULL THEN 1 ELSE 0 END), 0),
+					 COALESCE(SUM(CASE WHEN deleted_at IS NULL THEN downloaded ELSE 0 END), 0),
+					 COALESCE(SUM(CASE WHEN deleted_at IS NULL AND downloaded = 0 AND (? OR source <> ?) THEN 1 ELSE 0 END), 0),
+					 COALESCE(SUM(CASE WHEN deleted_at IS NULL AND downloaded = 0 AND (? OR source <> ?) AND last_error <> '' THEN 1 ELSE 0 END), 0),
+					 COALESCE(SUM(CASE WHEN deleted_at IS NOT NULL THEN 1 ELSE 0 END), 0)
 			  FROM media_items`
+	args := []interface{}{includeShared, SourceShared, includeShared, SourceShared}
+	err = m.sqlFuncs.QueryRow(query, args, func(row Scanner) error {
+		return row.Scan(&counts.Total, &counts.Downloaded, &counts.Pending, &counts.Failed, &counts.Deleted)
+	})
+	return
+}
 
+func (m *mediaItems) AverageFileSizes() (sizes FileSizes, err error) {
+	query := `SELECT COALESCE(AVG(CASE WHEN mime_type NOT LIKE '%video%' THEN file_size END), 0),
+					 COALESCE(AVG(CASE WHEN mime_type LIKE '%video%' THEN file_size END), 0)
+			  FROM media_items WHERE downloaded = 1 AND file_size > 0`
+	var photo, video float64
+	err = m.sqlFuncs.QueryValue(query, &photo, &video)
+	sizes = FileSizes{Photo: int64(photo), Video: int64(video)}
+	return
+}
+
+func selectMediaItems(sqlFuncs *SqlFuncs, query string, args ...interface{}) ([]MediaItem, error) {
 	var mediaItems []MediaItem
 	mapper := func(row Scanner) (err error) {
 		var mediaItem MediaItem
@@ -124,7 +276,7 @@ func (m *mediaItems) GetAll() ([]MediaItem, error) {
 		return
 	}
 
-	err := m.sqlFuncs.Query(mapper, query)
+	err := sqlFuncs.Query(mapper, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -141,6 +293,14 @@ func (m *mediaItems) UpdateBaseUrl(remoteId string, baseUrl string) error {
 	return m.sqlFuncs.Exec(updateSql, baseUrl, remoteId)
 }
 
+// formatOptionalTime stores a zero time as NULL
+func formatOptionalTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return value.Format(time.RFC3339Nano)
+}
+
 func parseTime(dateTime sql.NullString, mediaTime *time.Time) (err error) {
 	if !dateTime.Valid {
 		return
@@ -159,6 +319,7 @@ func mediaItemRowMapper(mediaItem *MediaItem) MapperFunc {
 		var createdAt sql.NullString
 		var modifiedAt sql.NullString
 		var syncedAt sql.NullString
+		var deletedAt sql.NullString
 		var tempItem MediaItem
 
 		err = row.Scan(
@@ -176,6 +337,14 @@ func mediaItemRowMapper(mediaItem *MediaItem) MapperFunc {
 			&modifiedAt,
 			&syncedAt,
 			&tempItem.LastError,
+			&tempItem.Source,
+			&tempItem.Contributor,
+			&tempItem.CameraMake,
+			&tempItem.CameraModel,
+			&tempItem.Sha256,
+			&deletedAt,
+			&tempItem.PartialBytes,
+			&tempItem.PartialValidator,
 		)
 		if err != nil {
 			return
@@ -189,6 +358,9 @@ func mediaItemRowMapper(mediaItem *MediaItem) MapperFunc {
 			if err == nil {
 				err = parseTime(syncedAt, &tempItem.SyncedAt)
 			}
+			if err == nil {
+				err = parseTime(deletedAt, &tempItem.DeletedAt)
+			}
 		}
 
 		if err != nil {
@@ -26,6 +26,8 @@ func TestMarkMediaItemAsSynced(t *testing.T) {
 	mediaItem.FileSize = 0
 	mediaItem.SyncedAt = time.Time{}
 	mediaItem.LastError = "dns error"
+	mediaItem.PartialBytes = 512
+	mediaItem.PartialValidator = `"etag"`
 	db := CreateTestDatabase(t)
 
 	err := db.MediaItems.Save(&mediaItem)
@@ -33,7 +35,7 @@ func TestMarkMediaItemAsSynced(t *testing.T) {
 	assert.NotNil(t, mediaItem.Uuid)
 
 	now := time.Now()
-	err = db.MediaItems.MarkAsSynced(mediaItem.Uuid, 1024)
+	err = db.MediaItems.MarkAsSynced(mediaItem.Uuid, 1024, "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589")
 	assert.NoError(t, err)
 
 	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
@@ -41,8 +43,44 @@ func TestMarkMediaItemAsSynced(t *testing.T) {
 
 	assert.Equal(t, true, dbMediaItem.Downloaded)
 	assert.Equal(t, 1024, dbMediaItem.FileSize)
+	assert.Equal(t, "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589", dbMediaItem.Sha256)
 	assert.InDelta(t, now.UnixMilli(), dbMediaItem.SyncedAt.UnixMilli(), 10000)
 	assert.Empty(t, dbMediaItem.LastError)
+	assert.Zero(t, dbMediaItem.PartialBytes)
+	assert.Empty(t, dbMediaItem.PartialValidator)
+}
+
+func TestUpdateMediaItemPartial(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	mediaItem.Downloaded = false
+	db := CreateTestDatabase(t)
+	assert.NoError(t, db.MediaItems.Save(&mediaItem))
+
+	err := db.MediaItems.UpdatePartial(mediaItem.Uuid, 2048, `"etag"`)
+	assert.NoError(t, err)
+
+	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2048), dbMediaItem.PartialBytes)
+	assert.Equal(t, `"etag"`, dbMediaItem.PartialValidator)
+	assert.False(t, dbMediaItem.Downloaded)
+}
+
+func TestMarkMediaItemAsNotDownloaded(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	mediaItem.Sha256 = "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589"
+	db := CreateTestDatabase(t)
+	assert.NoError(t, db.MediaItems.Save(&mediaItem))
+
+	err := db.MediaItems.MarkAsNotDownloaded(mediaItem.Uuid, "changed")
+	assert.NoError(t, err)
+
+	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbMediaItem.Downloaded)
+	assert.Equal(t, 0, dbMediaItem.FileSize)
+	assert.Empty(t, dbMediaItem.Sha256)
+	assert.Equal(t, "changed", dbMediaItem.LastError)
 }
 
 func TestRetrieveUndownloadedIds(t *testing.T) {
@@ -57,7 +95,7 @@ func TestRetrieveUndownloadedIds(t *testing.T) {
 	err := db.MediaItems.Save(&mediaItem1, &mediaItem2, &mediaItem3, &mediaItem4)
 	assert.NoError(t, err)
 
-	mediaItemIds, err := db.MediaItems.GetNonDownloadedIds()
+	mediaItemIds, err := db.MediaItems.GetNonDownloadedIds(true)
 	assert.NoError(t, err)
 	assert.Len(t, mediaItemIds, 2)
 	assert.Equal(t, MediaItemIds{Uuid: mediaItem2.Uuid, RemoteId: mediaItem2.RemoteId}, mediaItemIds[0])
@@ -103,3 +141,220 @@ func TestMediaItem_MarkAsErrored(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "network disconnected", dbMediaItem.LastError)
 }
+
+func TestRetrieveDownloadedMediaItems(t *testing.T) {
+	downloaded := CreateTestMediaItem(t)
+	notDownloaded := CreateTestMediaItem(t)
+	notDownloaded.Downloaded = false
+
+	db := CreateTestDatabase(t)
+	err := db.MediaItems.Save(&downloaded, &notDownloaded)
+	assert.NoError(t, err)
+
+	items, err := db.MediaItems.GetDownloaded()
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, downloaded.Uuid, items[0].Uuid)
+}
+
+func TestRetrieveDownloadedMediaItemsWithSize(t *testing.T) {
+	downloaded := CreateTestMediaItem(t)
+	otherSize := CreateTestMediaItem(t)
+	otherSize.FileSize = 1000
+	notDownloaded := CreateTestMediaItem(t)
+	notDownloaded.Downloaded = false
+
+	db := CreateTestDatabase(t)
+	err := db.MediaItems.Save(&downloaded, &otherSize, &notDownloaded)
+	assert.NoError(t, err)
+
+	items, err := db.MediaItems.GetDownloadedWithSize(downloaded.FileSize)
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, downloaded.Uuid, items[0].Uuid)
+}
+
+func TestMediaItemCounts(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	counts, err := db.MediaItems.Counts(false)
+	assert.NoError(t, err)
+	assert.Equal(t, MediaItemCounts{}, counts)
+
+	downloaded := CreateTestMediaItem(t)
+	pending := CreateTestMediaItem(t)
+	pending.Downloaded = false
+	failed := CreateTestMediaItem(t)
+	failed.Downloaded = false
+	failed.LastError = "dns error"
+
+	deleted := CreateTestMediaItem(t)
+	deleted.Downloaded = false
+	deleted.DeletedAt = timeMustParse(t, "2021-12-03T19:54:05Z")
+	shared := CreateTestMediaItem(t)
+	shared.Downloaded = false
+	shared.LastError = "dns error"
+	shared.Source = SourceShared
+
+	err = db.MediaItems.Save(&downloaded, &pending, &failed, &deleted, &shared)
+	assert.NoError(t, err)
+
+	// pending matches the media items GetNonDownloadedIds would download
+	counts, err = db.MediaItems.Counts(false)
+	assert.NoError(t, err)
+	assert.Equal(t, MediaItemCounts{Total: 4, Downloaded: 1, Pending: 2, Failed: 1, Deleted: 1}, counts)
+	ids, err := db.MediaItems.GetNonDownloadedIds(false)
+	assert.NoError(t, err)
+	assert.Len(t, ids, counts.Pending)
+
+	counts, err = db.MediaItems.Counts(true)
+	assert.NoError(t, err)
+	assert.Equal(t, MediaItemCounts{Total: 4, Downloaded: 1, Pending: 3, Failed: 2, Deleted: 1}, counts)
+	ids, err = db.MediaItems.GetNonDownloadedIds(true)
+	assert.NoError(t, err)
+	assert.Len(t, ids, counts.Pending)
+}
+
+func TestMediaItemAverageFileSizes(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	sizes, err := db.MediaItems.AverageFileSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, FileSizes{}, sizes)
+
+	small := CreateTestMediaItem(t)
+	small.FileSize = 1000
+	large := CreateTestMediaItem(t)
+	large.FileSize = 3000
+	video := CreateTestMediaItem(t)
+	video.MimeType = "video/mp4"
+	video.FileSize = 50000
+	// not downloaded, so the size isn't known yet
+	pending := CreateTestMediaItem(t)
+	pending.Downloaded = false
+	pending.FileSize = 0
+
+	assert.NoError(t, db.MediaItems.Save(&small, &large, &video, &pending))
+
+	sizes, err = db.MediaItems.AverageFileSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, FileSizes{Photo: 2000, Video: 50000}, sizes)
+}
+
+func TestMarkMediaItemAsDeletedAndRestore(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	mediaItem.Downloaded = false
+	db := CreateTestDatabase(t)
+	assert.NoError(t, db.MediaItems.Save(&mediaItem))
+
+	deletedAt := timeMustParse(t, "2021-12-03T19:54:05Z")
+	assert.NoError(t, db.MediaItems.MarkAsDeleted(mediaItem.Uuid, deletedAt))
+
+	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, deletedAt, dbMediaItem.DeletedAt)
+
+	// deleted items aren't downloaded again
+	mediaItemIds, err := db.MediaItems.GetNonDownloadedIds(true)
+	assert.NoError(t, err)
+	assert.Empty(t, mediaItemIds)
+
+	assert.NoError(t, db.MediaItems.Restore(mediaItem.Uuid))
+	dbMediaItem, err = db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.True(t, dbMediaItem.DeletedAt.IsZero())
+}
+
+func TestRetrieveUndownloadedIdsExcludesSharedItems(t *testing.T) {
+	libraryItem := CreateTestMediaItem(t)
+	libraryItem.Downloaded = false
+	sharedItem := CreateTestMediaItem(t)
+	sharedItem.Downloaded = false
+	sharedItem.Source = SourceShared
+	sharedItem.Contributor = "Jane"
+
+	db := CreateTestDatabase(t)
+	err := db.MediaItems.Save(&libraryItem, &sharedItem)
+	assert.NoError(t, err)
+
+	mediaItemIds, err := db.MediaItems.GetNonDownloadedIds(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []MediaItemIds{{Uuid: libraryItem.Uuid, RemoteId: libraryItem.RemoteId}}, mediaItemIds)
+
+	mediaItemIds, err = db.MediaItems.GetNonDownloadedIds(true)
+	assert.NoError(t, err)
+	assert.Len(t, mediaItemIds, 2)
+
+	dbSharedItem, err := db.MediaItems.Get(sharedItem.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceShared, dbSharedItem.Source)
+	assert.Equal(t, "Jane", dbSharedItem.Contributor)
+}
+
+func TestSaveDefaultsSourceToLibrary(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	mediaItem.Source = ""
+	db := CreateTestDatabase(t)
+
+	err := db.MediaItems.Save(&mediaItem)
+	assert.NoError(t, err)
+
+	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceLibrary, dbMediaItem.Source)
+}
+
+func TestMediaItemExists(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	db := CreateTestDatabase(t)
+
+	exists, err := db.MediaItems.Exists(mediaItem.RemoteId)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = db.MediaItems.Save(&mediaItem)
+	assert.NoError(t, err)
+
+	exists, err = db.MediaItems.Exists(mediaItem.RemoteId)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestUpdateLocation(t *testing.T) {
+	mediaItem := CreateTestMediaItem(t)
+	db := CreateTestDatabase(t)
+	assert.NoError(t, db.MediaItems.Save(&mediaItem))
+
+	err := db.MediaItems.UpdateLocation(mediaItem.Uuid, "2012/12/12/moved", "renamed.png")
+	assert.NoError(t, err)
+
+	dbMediaItem, err := db.MediaItems.Get(mediaItem.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, "2012/12/12/moved", dbMediaItem.LocalPath)
+	assert.Equal(t, "renamed.png", dbMediaItem.LocalFilename)
+}
+
+func TestMediaItemRemoteIds(t *testing.T) {
+	first := CreateTestMediaItem(t)
+	second := CreateTestMediaItem(t)
+	db := CreateTestDatabase(t)
+	assert.NoError(t, db.MediaItems.Save(&first, &second))
+
+	remoteIds, err := db.MediaItems.RemoteIds()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{first.RemoteId: true, second.RemoteId: true}, remoteIds)
+}
+
+func TestMediaItemLocationUsed(t *testing.T) {
+	db := CreateTestDatabase(t)
+	item := CreateTestMediaItem(t)
+	assert.NoError(t, db.MediaItems.Save(&item))
+
+	used, err := db.MediaItems.LocationUsed(item.LocalPath, item.LocalFilename)
+	assert.NoError(t, err)
+	assert.True(t, used)
+
+	used, err = db.MediaItems.LocationUsed(item.LocalPath, "other.jpg")
+	assert.NoError(t, err)
+	assert.False(t, used)
+}
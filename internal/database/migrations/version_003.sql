@@ -0,0 +1,17 @@
+CREATE TABLE albums
+(
+    uuid       TEXT NOT NULL CONSTRAINT albums_pk PRIMARY KEY,
+    remote_id  TEXT NOT NULL CONSTRAINT albums_remote_id_uq UNIQUE,
+    title      TEXT NOT NULL,
+    local_path TEXT NOT NULL CONSTRAINT albums_local_path_uq UNIQUE,
+    item_count INTEGER DEFAULT 0 NOT NULL,
+    synced_at  TEXT
+);
+
+CREATE TABLE album_items
+(
+    album_uuid           TEXT    NOT NULL REFERENCES albums (uuid) ON DELETE CASCADE,
+    media_item_remote_id TEXT    NOT NULL,
+    position             INTEGER NOT NULL,
+    CONSTRAINT album_items_pk PRIMARY KEY (album_uuid, media_item_remote_id)
+);
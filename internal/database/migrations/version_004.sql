@@ -0,0 +1,3 @@
+ALTER TABLE media_items ADD COLUMN source TEXT DEFAULT 'library' NOT NULL;
+ALTER TABLE media_items ADD COLUMN contributor TEXT DEFAULT '' NOT NULL;
+ALTER TABLE albums ADD COLUMN shared INTEGER DEFAULT 0 NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE settings ADD COLUMN layout_template TEXT;
+UPDATE settings SET layout_template = '{year}/{month}/{day}/{filename}' WHERE EXISTS (SELECT 1 FROM media_items);
+ALTER TABLE media_items ADD COLUMN camera_make TEXT DEFAULT '' NOT NULL;
+ALTER TABLE media_items ADD COLUMN camera_model TEXT DEFAULT '' NOT NULL;
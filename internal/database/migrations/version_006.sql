@@ -0,0 +1 @@
+ALTER TABLE media_items ADD COLUMN sha256 TEXT DEFAULT '' NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE media_items ADD COLUMN deleted_at TEXT;
+ALTER TABLE settings ADD COLUMN deletion_policy TEXT DEFAULT 'keep' NOT NULL;
+ALTER TABLE settings ADD COLUMN trash_retention_days INTEGER DEFAULT 30 NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE settings ADD COLUMN full_sync_days INTEGER DEFAULT 7 NOT NULL;
+ALTER TABLE settings ADD COLUMN last_full_sync TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE media_items ADD COLUMN partial_bytes INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE media_items ADD COLUMN partial_validator TEXT DEFAULT '' NOT NULL;
@@ -0,0 +1,6 @@
+CREATE TABLE api_usage
+(
+    day               TEXT NOT NULL CONSTRAINT api_usage_pk PRIMARY KEY,
+    metadata_requests INTEGER DEFAULT 0 NOT NULL,
+    media_requests    INTEGER DEFAULT 0 NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE runs
+(
+    id          INTEGER NOT NULL CONSTRAINT runs_pk PRIMARY KEY AUTOINCREMENT,
+    mode        TEXT    NOT NULL,
+    started_at  TEXT    NOT NULL,
+    finished_at TEXT,
+    indexed     INTEGER DEFAULT 0 NOT NULL,
+    downloaded  INTEGER DEFAULT 0 NOT NULL,
+    failed      INTEGER DEFAULT 0 NOT NULL,
+    bytes       INTEGER DEFAULT 0 NOT NULL,
+    error       TEXT    DEFAULT '' NOT NULL
+);
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type runs struct {
+	sqlFuncs SqlFuncs
+	logger   utils.Logger
+}
+
+// Run is one invocation of a command that changes the library. FinishedAt is zero while the run
+// is going, or when it was killed before it could record how it ended
+type Run struct {
+	Id         int64
+	Mode       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Indexed    int
+	Downloaded int
+	Failed     int
+	Bytes      int64
+	Error      string
+}
+
+// Start records a run as started and sets its id
+func (r *runs) Start(run *Run) (err error) {
+	insertSql := "INSERT INTO runs (mode, started_at) VALUES(?, ?)"
+	run.Id, err = r.sqlFuncs.Insert(insertSql, run.Mode, run.StartedAt.Format(time.RFC3339Nano))
+	return
+}
+
+// Finish records how a started run ended
+func (r *runs) Finish(run Run) error {
+	updateSql := "UPDATE runs SET finished_at = ?, indexed = ?, downloaded = ?, failed = ?, bytes = ?, error = ? WHERE id = ?"
+	return r.sqlFuncs.Exec(updateSql, run.FinishedAt.Format(time.RFC3339Nano), run.Indexed, run.Downloaded, run.Failed, run.Bytes, run.Error, run.Id)
+}
+
+// Recent returns the last limit runs, newest first
+func (r *runs) Recent(limit int) ([]Run, error) {
+	query := "SELECT id, mode, started_at, finished_at, indexed, downloaded, failed, bytes, error FROM runs ORDER BY id DESC LIMIT ?"
+
+	var runs []Run
+	mapper := func(row Scanner) (err error) {
+		var run Run
+		var startedAt string
+		var finishedAt sql.NullString
+		err = row.Scan(&run.Id, &run.Mode, &startedAt, &finishedAt, &run.Indexed, &run.Downloaded, &run.Failed, &run.Bytes, &run.Error)
+		if err != nil {
+			return
+		}
+
+		run.StartedAt, err = time.Parse(time.RFC3339Nano, startedAt)
+		if err != nil {
+			return
+		}
+		if finishedAt.Valid {
+			run.FinishedAt, err = time.Parse(time.RFC3339Nano, finishedAt.String)
+			if err != nil {
+				return
+			}
+		}
+
+		runs = append(runs, run)
+		return
+	}
+
+	err := r.sqlFuncs.Query(mapper, query, limit)
+	return runs, err
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunsStartAndFinish(t *testing.T) {
+	db := CreateTestDatabase(t)
+	run := Run{Mode: "sync", StartedAt: timeMustParse(t, "2021-12-03T19:54:05Z")}
+
+	err := db.Runs.Start(&run)
+	assert.NoError(t, err)
+	assert.NotZero(t, run.Id)
+
+	runs, err := db.Runs.Recent(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Run{run}, runs)
+
+	run.FinishedAt = timeMustParse(t, "2021-12-03T20:04:05Z")
+	run.Indexed = 10
+	run.Downloaded = 8
+	run.Failed = 2
+	run.Bytes = 12345
+	run.Error = "syncing failed"
+	assert.NoError(t, db.Runs.Finish(run))
+
+	runs, err = db.Runs.Recent(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Run{run}, runs)
+}
+
+func TestRunsRecentReturnsNewestFirst(t *testing.T) {
+	db := CreateTestDatabase(t)
+	for _, mode := range []string{"sync", "retry-failed", "reconcile"} {
+		run := Run{Mode: mode, StartedAt: timeMustParse(t, "2021-12-03T19:54:05Z")}
+		assert.NoError(t, db.Runs.Start(&run))
+	}
+
+	runs, err := db.Runs.Recent(2)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, "reconcile", runs[0].Mode)
+	assert.Equal(t, "retry-failed", runs[1].Mode)
+}
@@ -57,3 +57,75 @@ func (s *settings) UpdateToken(token string) (err error) {
 	err = s.sqlFuncs.Exec("UPDATE settings SET token = ?", token)
 	return
 }
+
+// LayoutTemplate returns the layout template of the library, empty if one hasn't been chosen yet
+func (s *settings) LayoutTemplate() (template string, err error) {
+	var data sql.NullString
+	err = s.sqlFuncs.QueryValue("SELECT layout_template FROM settings LIMIT 1", &data)
+	if err != nil {
+		return
+	}
+
+	if data.Valid {
+		template = data.String
+	}
+
+	return
+}
+
+func (s *settings) UpdateLayoutTemplate(template string) error {
+	return s.sqlFuncs.Exec("UPDATE settings SET layout_template = ?", template)
+}
+
+// DeletionPolicy returns what happens to the files of media items deleted from google photos
+func (s *settings) DeletionPolicy() (policy string, err error) {
+	err = s.sqlFuncs.QueryValue("SELECT deletion_policy FROM settings LIMIT 1", &policy)
+	return
+}
+
+func (s *settings) UpdateDeletionPolicy(policy string) error {
+	return s.sqlFuncs.Exec("UPDATE settings SET deletion_policy = ?", policy)
+}
+
+// TrashRetentionDays returns how long files of deleted media items are kept in the trash
+func (s *settings) TrashRetentionDays() (days int, err error) {
+	err = s.sqlFuncs.QueryValue("SELECT trash_retention_days FROM settings LIMIT 1", &days)
+	return
+}
+
+func (s *settings) UpdateTrashRetentionDays(days int) error {
+	return s.sqlFuncs.Exec("UPDATE settings SET trash_retention_days = ?", days)
+}
+
+// FullSyncDays returns how often, in days, the whole library is listed to catch media items the
+// incremental sync misses. Zero disables it
+func (s *settings) FullSyncDays() (days int, err error) {
+	err = s.sqlFuncs.QueryValue("SELECT full_sync_days FROM settings LIMIT 1", &days)
+	return
+}
+
+func (s *settings) UpdateFullSyncDays(days int) error {
+	return s.sqlFuncs.Exec("UPDATE settings SET full_sync_days = ?", days)
+}
+
+func (s *settings) LastFullSync() (lastFullSync time.Time, err error) {
+	var data sql.NullString
+	err = s.sqlFuncs.QueryValue("SELECT last_full_sync FROM settings LIMIT 1", &data)
+	if err != nil {
+		return
+	}
+
+	if data.Valid {
+		lastFullSync, err = time.Parse(time.RFC3339Nano, data.String)
+	}
+
+	return
+}
+
+func (s *settings) UpdateLastFullSync(now time.Time) error {
+	if now != (time.Time{}) {
+		return s.sqlFuncs.Exec("UPDATE settings SET last_full_sync = ?", now.Format(time.RFC3339Nano))
+	} else {
+		return s.sqlFuncs.Exec("UPDATE settings SET last_full_sync = ?", nil)
+	}
+}
@@ -62,3 +62,70 @@ func TestToken(t *testing.T) {
 	assert.Equal(t, "abc123", token)
 
 }
+
+func TestLayoutTemplate(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	template, err := db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, "", template)
+
+	err = db.Settings.UpdateLayoutTemplate("{year}/{filename}")
+	assert.NoError(t, err)
+
+	template, err = db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, "{year}/{filename}", template)
+}
+
+func TestDeletionPolicy(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	policy, err := db.Settings.DeletionPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, "keep", policy)
+
+	days, err := db.Settings.TrashRetentionDays()
+	assert.NoError(t, err)
+	assert.Equal(t, 30, days)
+
+	assert.NoError(t, db.Settings.UpdateDeletionPolicy("trash"))
+	assert.NoError(t, db.Settings.UpdateTrashRetentionDays(7))
+
+	policy, err = db.Settings.DeletionPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, "trash", policy)
+
+	days, err = db.Settings.TrashRetentionDays()
+	assert.NoError(t, err)
+	assert.Equal(t, 7, days)
+}
+
+func TestFullSync(t *testing.T) {
+	db := CreateTestDatabase(t)
+
+	days, err := db.Settings.FullSyncDays()
+	assert.NoError(t, err)
+	assert.Equal(t, 7, days)
+
+	lastFullSync, err := db.Settings.LastFullSync()
+	assert.NoError(t, err)
+	assert.True(t, lastFullSync.IsZero())
+
+	now := time.Now()
+	assert.NoError(t, db.Settings.UpdateFullSyncDays(0))
+	assert.NoError(t, db.Settings.UpdateLastFullSync(now))
+
+	days, err = db.Settings.FullSyncDays()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, days)
+
+	lastFullSync, err = db.Settings.LastFullSync()
+	assert.NoError(t, err)
+	assert.Equal(t, now.UnixMilli(), lastFullSync.UnixMilli())
+	// a zero time means there hasn't been a full sync
+	assert.NoError(t, db.Settings.UpdateLastFullSync(time.Time{}))
+	lastFullSync, err = db.Settings.LastFullSync()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Time{}, lastFullSync)
+}
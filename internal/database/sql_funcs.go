@@ -14,8 +14,15 @@ type Scanner interface {
 
 type MapperFunc = func(row Scanner) error
 
+// executor is satisfied by both a connection and a transaction
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type SqlFuncs struct {
-	connection *sql.DB
+	connection executor
 	logger     utils.Logger
 }
 
@@ -42,6 +49,16 @@ func (db *SqlFuncs) Exec(query string, args ...interface{}) (err error) {
 	return
 }
 
+// Insert runs an insert query and returns the id of the inserted row
+func (db *SqlFuncs) Insert(query string, args ...interface{}) (id int64, err error) {
+	db.logger.Trace.Printf("insert query: '%s' %+v", query, args)
+	result, err := db.connection.Exec(query, args...)
+	if err != nil {
+		return
+	}
+	return result.LastInsertId()
+}
+
 func (db *SqlFuncs) Query(mapper MapperFunc, query string, args ...interface{}) (err error) {
 	db.logger.Trace.Printf("exec query: '%s' %+v", query, args)
 	rows, err := db.connection.Query(query, args...)
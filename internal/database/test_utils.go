@@ -37,6 +37,7 @@ func CreateTestMediaItem(t *testing.T) MediaItem {
 		CreatedAt:     timeMustParse(t, "2012-12-12T19:54:05Z"),
 		ModifiedAt:    timeMustParse(t, "2012-12-12T06:54:05Z"),
 		SyncedAt:      timeMustParse(t, "2012-12-03T19:54:05Z"),
+		Source:        SourceLibrary,
 	}
 }
 
@@ -0,0 +1,253 @@
+package layout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LegacyTemplate is the layout used before templates could be configured
+const LegacyTemplate = "{year}/{month}/{day}/{filename}"
+
+// DefaultTemplate is used for new libraries
+const DefaultTemplate = "{year}/{month:02}/{day:02}/{filename}"
+
+// placeholder values that are not known render as this
+const unknownValue = "unknown"
+
+var numericPlaceholders = map[string]func(fields Fields) int{
+	"year":  func(fields Fields) int { return fields.CreatedAt.Year() },
+	"month": func(fields Fields) int { return int(fields.CreatedAt.Month()) },
+	"day":   func(fields Fields) int { return fields.CreatedAt.Day() },
+}
+
+var textPlaceholders = map[string]func(fields Fields) string{
+	"filename":      func(fields Fields) string { return fields.Filename },
+	"camera_make":   func(fields Fields) string { return fields.CameraMake },
+	"camera_model":  func(fields Fields) string { return fields.CameraModel },
+	"mime_category": func(fields Fields) string { return mimeCategory(fields.MimeType) },
+	"album":         func(fields Fields) string { return fields.Album },
+}
+
+// Fields are the values of a media item that can be used in a template
+type Fields struct {
+	CreatedAt   time.Time
+	Filename    string
+	MimeType    string
+	CameraMake  string
+	CameraModel string
+	Album       string
+}
+
+// reservedNames are used by the downloader in the library root: album links, the trash, partial
+// downloads, interrupted relayouts, the database and the lock. Media items are never placed there
+var reservedNames = []string{"albums", ".trash", ".staging", ".relayout", "google_photos.sqlite3", ".gphotos_downloader.lock"}
+
+// IsReserved reports whether name is used by the downloader in the library root. Case is ignored,
+// as many filesystems do
+func IsReserved(name string) bool {
+	for _, reserved := range reservedNames {
+		if strings.EqualFold(name, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+type part struct {
+	literal     string
+	placeholder string
+	width       int
+}
+
+// Template describes where a media item is stored relative to the library root, e.g.
+// "{year}/{month:02}/{day:02}/{filename}". The last path segment is the filename.
+type Template struct {
+	text     string
+	segments [][]part
+}
+
+var (
+	Default = MustParse(DefaultTemplate)
+	Legacy  = MustParse(LegacyTemplate)
+)
+
+func MustParse(text string) Template {
+	template, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return template
+}
+
+func Parse(text string) (template Template, err error) {
+	template.text = text
+	if strings.HasPrefix(text, "/") {
+		return Template{}, fmt.Errorf("layout template '%s' must be relative to the library root", text)
+	}
+
+	for _, segment := range strings.Split(text, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return Template{}, fmt.Errorf("layout template '%s' has an invalid path segment '%s'", text, segment)
+		}
+
+		var parts []part
+		parts, err = parseSegment(segment)
+		if err != nil {
+			return Template{}, fmt.Errorf("layout template '%s': %w", text, err)
+		}
+		if len(template.segments) == 0 && len(parts) == 1 && IsReserved(parts[0].literal) {
+			return Template{}, fmt.Errorf("layout template '%s' can't place files in '%s', the downloader uses it", text, segment)
+		}
+		template.segments = append(template.segments, parts)
+	}
+
+	if !template.segmentUses(len(template.segments)-1, "filename") {
+		return Template{}, fmt.Errorf("layout template '%s' must end with a segment containing {filename}", text)
+	}
+	return
+}
+
+func parseSegment(segment string) (parts []part, err error) {
+	for segment != "" {
+		start := strings.IndexByte(segment, '{')
+		if start == -1 {
+			return append(parts, part{literal: segment}), nil
+		}
+		if start > 0 {
+			parts = append(parts, part{literal: segment[:start]})
+		}
+
+		end := strings.IndexByte(segment[start:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unclosed placeholder in '%s'", segment)
+		}
+
+		var placeholder part
+		placeholder, err = parsePlaceholder(segment[start+1 : start+end])
+		if err != nil {
+			return
+		}
+		parts = append(parts, placeholder)
+		segment = segment[start+end+1:]
+	}
+	return
+}
+
+func parsePlaceholder(text string) (part, error) {
+	name, format, hasFormat := strings.Cut(text, ":")
+	_, numeric := numericPlaceholders[name]
+	_, isText := textPlaceholders[name]
+	if !numeric && !isText {
+		return part{}, fmt.Errorf("unknown placeholder '{%s}'", name)
+	}
+
+	if !hasFormat {
+		return part{placeholder: name}, nil
+	}
+
+	if !numeric {
+		return part{}, fmt.Errorf("placeholder '{%s}' does not support a format", name)
+	}
+
+	width, err := strconv.Atoi(format)
+	if err != nil || !strings.HasPrefix(format, "0") || width < 1 {
+		return part{}, fmt.Errorf("invalid format '%s' for '{%s}', expected zero padding such as ':02'", format, name)
+	}
+	return part{placeholder: name, width: width}, nil
+}
+
+func (t Template) String() string {
+	return t.text
+}
+
+// UsesAlbum reports whether rendering needs the album of a media item
+func (t Template) UsesAlbum() bool {
+	for i := range t.segments {
+		if t.segmentUses(i, "album") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Template) segmentUses(index int, placeholder string) bool {
+	for _, p := range t.segments[index] {
+		if p.placeholder == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns the directory, relative to the library root, and the filename for a media item
+func (t Template) Render(fields Fields) (localPath string, filename string) {
+	rendered := make([]string, len(t.segments))
+	for i, segment := range t.segments {
+		builder := strings.Builder{}
+		for _, p := range segment {
+			builder.WriteString(renderPart(p, fields))
+		}
+		rendered[i] = builder.String()
+	}
+
+	// a value can still render into a name the downloader uses
+	if IsReserved(rendered[0]) {
+		rendered[0] += "_"
+	}
+
+	last := len(rendered) - 1
+	localPath, filename = strings.Join(rendered[:last], string(os.PathSeparator)), rendered[last]
+	// values are made safe when rendered, this guards against anything they combine into
+	if !filepath.IsLocal(filepath.Join(localPath, filename)) {
+		for i := range rendered {
+			rendered[i] = SafeName(rendered[i])
+		}
+		localPath, filename = strings.Join(rendered[:last], string(os.PathSeparator)), rendered[last]
+	}
+	return
+}
+
+func renderPart(p part, fields Fields) string {
+	if p.placeholder == "" {
+		return p.literal
+	}
+
+	if value, ok := numericPlaceholders[p.placeholder]; ok {
+		return fmt.Sprintf("%0*d", p.width, value(fields))
+	}
+
+	value := textPlaceholders[p.placeholder](fields)
+	if value == "" {
+		return unknownValue
+	}
+	return SafeName(value)
+}
+
+func mimeCategory(mimeType string) string {
+	category, _, _ := strings.Cut(mimeType, "/")
+	return category
+}
+
+// SafeName replaces characters that aren't allowed in file names on common filesystems. Leading
+// and trailing dots and spaces are replaced too, so a name is never "." or ".." and stays in its
+// directory
+func SafeName(name string) string {
+	safe := []rune(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, name))
+
+	for i := 0; i < len(safe) && (safe[i] == '.' || safe[i] == ' '); i++ {
+		safe[i] = '_'
+	}
+	for i := len(safe) - 1; i >= 0 && (safe[i] == '.' || safe[i] == ' '); i-- {
+		safe[i] = '_'
+	}
+	return string(safe)
+}
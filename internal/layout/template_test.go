@@ -0,0 +1,127 @@
+package layout
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFields() Fields {
+	return Fields{
+		CreatedAt:   time.Date(2021, 3, 7, 9, 44, 49, 0, time.UTC),
+		Filename:    "IMG_0001.jpg",
+		MimeType:    "image/jpeg",
+		CameraMake:  "Sony",
+		CameraModel: "G8441",
+		Album:       "Holiday",
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	type testCase struct {
+		template string
+		path     string
+		filename string
+	}
+	testCases := []testCase{
+		{template: LegacyTemplate, path: filepath.Join("2021", "3", "7"), filename: "IMG_0001.jpg"},
+		{template: DefaultTemplate, path: filepath.Join("2021", "03", "07"), filename: "IMG_0001.jpg"},
+		{template: "{mime_category}/{camera_make} {camera_model}/{filename}", path: filepath.Join("image", "Sony G8441"), filename: "IMG_0001.jpg"},
+		{template: "{album}/{year}{month:02}{day:02}_{filename}", path: "Holiday", filename: "20210307_IMG_0001.jpg"},
+		{template: "{filename}", path: "", filename: "IMG_0001.jpg"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.template, func(t *testing.T) {
+			template, err := Parse(tc.template)
+			assert.NoError(t, err)
+
+			path, filename := template.Render(testFields())
+			assert.Equal(t, tc.path, path)
+			assert.Equal(t, tc.filename, filename)
+			assert.Equal(t, tc.template, template.String())
+		})
+	}
+}
+
+func TestRenderUnknownAndUnsafeValues(t *testing.T) {
+	fields := testFields()
+	fields.CameraModel = ""
+	fields.Album = "Trip: 1/2"
+
+	path, _ := MustParse("{camera_model}/{album}/{filename}").Render(fields)
+	assert.Equal(t, filepath.Join("unknown", "Trip_ 1_2"), path)
+}
+
+func TestRenderKeepsValuesInsideTheLibrary(t *testing.T) {
+	type testCase struct {
+		album    string
+		filename string
+		path     string
+		expected string
+	}
+	testCases := []testCase{
+		{album: "..", filename: "..", path: "__", expected: "__"},
+		{album: ".", filename: "IMG_1.jpg", path: "_", expected: "IMG_1.jpg"},
+		{album: " .hidden. ", filename: ".profile", path: "__hidden__", expected: "_profile"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.album, func(t *testing.T) {
+			fields := testFields()
+			fields.Album = tc.album
+			fields.Filename = tc.filename
+
+			path, filename := MustParse("{album}/{filename}").Render(fields)
+			assert.Equal(t, tc.path, path)
+			assert.Equal(t, tc.expected, filename)
+			assert.True(t, filepath.IsLocal(filepath.Join(path, filename)))
+		})
+	}
+}
+
+func TestRenderRenamesReservedNames(t *testing.T) {
+	fields := testFields()
+	fields.Album = "Albums"
+	path, _ := MustParse("{album}/{filename}").Render(fields)
+	assert.Equal(t, "Albums_", path)
+
+	fields.Filename = "google_photos.sqlite3"
+	_, filename := MustParse("{filename}").Render(fields)
+	assert.Equal(t, "google_photos.sqlite3_", filename)
+
+	// only the library root is reserved
+	path, _ = MustParse("{year}/{album}/{filename}").Render(fields)
+	assert.Equal(t, filepath.Join("2021", "Albums"), path)
+}
+
+func TestParseInvalidTemplates(t *testing.T) {
+	type testCase struct {
+		template string
+		expected string
+	}
+	testCases := []testCase{
+		{template: "{year}/{month}", expected: "must end with a segment containing {filename}"},
+		{template: "/{year}/{filename}", expected: "must be relative to the library root"},
+		{template: "{year}//{filename}", expected: "invalid path segment ''"},
+		{template: "../{filename}", expected: "invalid path segment '..'"},
+		{template: "albums/{year}/{filename}", expected: "can't place files in 'albums', the downloader uses it"},
+		{template: ".Trash/{filename}", expected: "can't place files in '.Trash', the downloader uses it"},
+		{template: "{hour}/{filename}", expected: "unknown placeholder '{hour}'"},
+		{template: "{year/{filename}", expected: "unclosed placeholder in '{year'"},
+		{template: "{album:02}/{filename}", expected: "placeholder '{album}' does not support a format"},
+		{template: "{month:2}/{filename}", expected: "invalid format '2' for '{month}'"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.template, func(t *testing.T) {
+			_, err := Parse(tc.template)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+func TestUsesAlbum(t *testing.T) {
+	assert.False(t, Default.UsesAlbum())
+	assert.True(t, MustParse("{album}/{filename}").UsesAlbum())
+}
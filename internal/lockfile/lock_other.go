@@ -0,0 +1,15 @@
+//go:build !unix
+
+package lockfile
+
+import "os"
+
+// tryLock can't lock without flock, the owner recorded in the file decides instead
+func tryLock(_ *os.File) error {
+	return errUnsupported
+}
+
+// processAlive can't tell whether pid is still running, so a lock is never taken over
+func processAlive(_ int) bool {
+	return true
+}
@@ -0,0 +1,176 @@
+// Package lockfile stops two runs from using the same library at once. The lock is an flock on a
+// file in the library root, which also records who holds it so a refused run can say why
+package lockfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+const FileName = ".gphotos_downloader.lock"
+
+// errHeld is returned by tryLock when another process holds the lock
+var errHeld = errors.New("lock is held by another process")
+
+// errUnsupported is returned by tryLock on filesystems without flock, e.g. some network shares. The
+// owner recorded in the file is all there is to go by on those
+var errUnsupported = errors.New("file locking is not supported")
+
+// Owner is the run holding the lock
+type Owner struct {
+	Pid      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// LockedError is returned when another run holds the lock
+type LockedError struct {
+	Path  string
+	Owner Owner
+}
+
+func (e LockedError) Error() string {
+	if e.Owner.Pid == 0 {
+		return fmt.Sprintf("another run is using the library, it holds the lock '%s'", e.Path)
+	}
+	return fmt.Sprintf("another run is using the library: pid %d on %s, started %s. Remove '%s' if that run is no longer going",
+		e.Owner.Pid, e.Owner.Hostname, e.Owner.Started.Format(time.RFC3339), e.Path)
+}
+
+type Lock struct {
+	file *os.File
+	path string
+}
+
+// Acquire takes the lock of the library in rootDir, failing with a LockedError when another run
+// holds it. A lock left behind by a run on this host that is no longer going is taken over
+func Acquire(rootDir string, logger utils.Logger) (*Lock, error) {
+	path := filepath.Join(rootDir, FileName)
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	// the file can be removed by the previous holder between opening and locking it, in which case
+	// the lock is on a file nobody else will look at and has to be taken again
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		lock, err := acquire(file, path, hostname, logger)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+	}
+	return nil, fmt.Errorf("lock '%s' keeps being replaced", path)
+}
+
+// acquire locks the opened file, returning no lock and no error when the file was replaced
+func acquire(file *os.File, path string, hostname string, logger utils.Logger) (*Lock, error) {
+	previous, readErr := readOwner(file)
+	err := tryLock(file)
+	switch {
+	case errors.Is(err, errHeld):
+		_ = file.Close()
+		return nil, LockedError{Path: path, Owner: previous}
+	case errors.Is(err, errUnsupported):
+		if readErr == nil && !isStale(previous, hostname) {
+			_ = file.Close()
+			return nil, LockedError{Path: path, Owner: previous}
+		}
+	case err != nil:
+		_ = file.Close()
+		return nil, err
+	}
+
+	if !sameFile(file, path) {
+		_ = file.Close()
+		return nil, nil
+	}
+
+	// the lock is held now, the owner is read again in case it was written while locking
+	previous, readErr = readOwner(file)
+	if readErr == nil {
+		logger.Info.Printf("taking over the lock of a run that stopped without releasing it, pid %d on %s", previous.Pid, previous.Hostname)
+	}
+
+	lock := &Lock{file: file, path: path}
+	err = lock.write(Owner{Pid: os.Getpid(), Hostname: hostname, Started: time.Now()})
+	if err != nil {
+		_ = lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// isStale reports whether owner is a run on this host that is no longer going. Runs on other hosts
+// can't be checked, so their locks are never stale
+func isStale(owner Owner, hostname string) bool {
+	return owner.Hostname == hostname && !processAlive(owner.Pid)
+}
+
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// readOwner reads the owner recorded in the lock file, failing when there is none
+func readOwner(file *os.File) (owner Owner, err error) {
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return
+	}
+	if len(content) == 0 {
+		return owner, io.EOF
+	}
+	err = json.Unmarshal(content, &owner)
+	return
+}
+
+func (l *Lock) write(owner Owner) error {
+	content, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+
+	err = l.file.Truncate(0)
+	if err == nil {
+		_, err = l.file.WriteAt(append(content, '\n'), 0)
+	}
+	if err == nil {
+		err = l.file.Sync()
+	}
+	return err
+}
+
+// Release removes the lock file and lets go of the lock. The file is removed first, so a run
+// starting meanwhile creates a new file rather than locking the one about to go away
+func (l *Lock) Release() error {
+	err := os.Remove(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	closeErr := l.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,80 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var logger = utils.NewLogger(utils.Silent)
+
+func TestAcquire_RecordsOwnerAndReleaseRemovesFile(t *testing.T) {
+	rootDir := t.TempDir()
+
+	lock, err := Acquire(rootDir, logger)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(rootDir, FileName))
+	assert.NoError(t, err)
+	var owner Owner
+	assert.NoError(t, json.Unmarshal(content, &owner))
+	hostname, _ := os.Hostname()
+	assert.Equal(t, os.Getpid(), owner.Pid)
+	assert.Equal(t, hostname, owner.Hostname)
+	assert.WithinDuration(t, time.Now(), owner.Started, time.Minute)
+
+	assert.NoError(t, lock.Release())
+	assert.NoFileExists(t, filepath.Join(rootDir, FileName))
+}
+
+func TestAcquire_RefusesWhileAnotherRunHoldsTheLock(t *testing.T) {
+	rootDir := t.TempDir()
+	lock, err := Acquire(rootDir, logger)
+	assert.NoError(t, err)
+
+	_, err = Acquire(rootDir, logger)
+	var lockedErr LockedError
+	assert.True(t, errors.As(err, &lockedErr))
+	assert.Equal(t, os.Getpid(), lockedErr.Owner.Pid)
+	assert.Contains(t, err.Error(), "another run is using the library: pid")
+
+	assert.NoError(t, lock.Release())
+	lock, err = Acquire(rootDir, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
+
+func TestAcquire_TakesOverLockLeftBehind(t *testing.T) {
+	rootDir := t.TempDir()
+	hostname, _ := os.Hostname()
+	content, _ := json.Marshal(Owner{Pid: 1 << 30, Hostname: hostname, Started: time.Now().Add(-time.Hour)})
+	assert.NoError(t, os.WriteFile(filepath.Join(rootDir, FileName), content, 0644))
+
+	lock, err := Acquire(rootDir, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
+
+func TestIsStale(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	assert.False(t, isStale(Owner{Pid: os.Getpid(), Hostname: hostname}, hostname))
+	assert.True(t, isStale(Owner{Pid: 1 << 30, Hostname: hostname}, hostname))
+	// processes on other hosts can't be checked
+	assert.False(t, isStale(Owner{Pid: 1 << 30, Hostname: "other"}, hostname))
+}
+
+func TestLockedError(t *testing.T) {
+	started := time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+	err := LockedError{Path: "/photos/.gphotos_downloader.lock", Owner: Owner{Pid: 42, Hostname: "nas", Started: started}}
+
+	expected := "another run is using the library: pid 42 on nas, started 2021-12-03T12:00:00Z. Remove '/photos/.gphotos_downloader.lock' if that run is no longer going"
+	assert.EqualError(t, err, expected)
+	assert.EqualError(t, LockedError{Path: "lock"}, "another run is using the library, it holds the lock 'lock'")
+}
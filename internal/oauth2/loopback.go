@@ -0,0 +1,108 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrStateMismatch = errors.New("state of the authorisation response doesn't match the request, it may have been forged")
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// loopbackListener is a temporary http server on the loopback interface that google redirects to
+// once access has been granted, carrying the authorisation code
+type loopbackListener struct {
+	listener net.Listener
+	server   *http.Server
+	state    string
+	results  chan callbackResult
+}
+
+func newLoopbackListener(state string) (*loopbackListener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	l := &loopbackListener{listener: listener, state: state, results: make(chan callbackResult, 1)}
+	l.server = &http.Server{Handler: http.HandlerFunc(l.handle), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = l.server.Serve(listener)
+	}()
+	return l, nil
+}
+
+func (l *loopbackListener) redirectUrl() string {
+	return fmt.Sprintf("http://%s/", l.listener.Addr().String())
+}
+
+func (l *loopbackListener) handle(w http.ResponseWriter, r *http.Request) {
+	// browsers also ask for things like a favicon
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// a request that doesn't answer this authorisation, stray or forged, doesn't end the wait
+	if r.URL.Query().Get("state") != l.state {
+		http.Error(w, "Authorisation failed: "+ErrStateMismatch.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, err := parseCallback(r.URL.Query(), l.state)
+	if err != nil {
+		http.Error(w, "Authorisation failed: "+err.Error(), http.StatusBadRequest)
+	} else {
+		_, _ = fmt.Fprint(w, "Authorisation completed, you can close this window.")
+	}
+
+	// only the first response counts
+	select {
+	case l.results <- callbackResult{code: code, err: err}:
+	default:
+	}
+}
+
+// wait blocks until google redirects to the listener, the timeout passes or ctx is cancelled
+func (l *loopbackListener) wait(ctx context.Context, timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-l.results:
+		return result.code, result.err
+	case <-timer.C:
+		return "", fmt.Errorf("no authorisation response received within %s", timeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (l *loopbackListener) Close() error {
+	return l.server.Close()
+}
+
+// parseCallback extracts the authorisation code from the query of a redirect, checking it answers
+// the request with the given state
+func parseCallback(query url.Values, state string) (string, error) {
+	if reason := query.Get("error"); reason != "" {
+		return "", fmt.Errorf("access was not granted: %s", reason)
+	}
+	if query.Get("state") != state {
+		return "", ErrStateMismatch
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return "", errors.New("authorisation response has no code")
+	}
+	return code, nil
+}
@@ -0,0 +1,89 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestLoopbackListener_ReceivesCode(t *testing.T) {
+	listener, err := newLoopbackListener("expected-state")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	response, err := http.Get(listener.redirectUrl() + "?state=expected-state&code=auth-code")
+	assert.NoError(t, err)
+	assert.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	code, err := listener.wait(context.Background(), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "auth-code", code)
+}
+
+func TestLoopbackListener_IgnoresForgedStateAndKeepsWaiting(t *testing.T) {
+	listener, err := newLoopbackListener("expected-state")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	for _, query := range []string{"?state=forged&code=auth-code", "?error=access_denied"} {
+		response, err := http.Get(listener.redirectUrl() + query)
+		assert.NoError(t, err)
+		assert.NoError(t, response.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	}
+
+	response, err := http.Get(listener.redirectUrl() + "?state=expected-state&code=auth-code")
+	assert.NoError(t, err)
+	assert.NoError(t, response.Body.Close())
+
+	code, err := listener.wait(context.Background(), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "auth-code", code)
+}
+
+func TestLoopbackListener_WaitIsCancelled(t *testing.T) {
+	listener, err := newLoopbackListener("expected-state")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = listener.wait(ctx, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseCallback(t *testing.T) {
+	code, err := parseCallback(url.Values{"state": {"state"}, "code": {"code"}}, "state")
+	assert.NoError(t, err)
+	assert.Equal(t, "code", code)
+
+	_, err = parseCallback(url.Values{"error": {"access_denied"}, "state": {"state"}}, "state")
+	assert.EqualError(t, err, "access was not granted: access_denied")
+
+	_, err = parseCallback(url.Values{"state": {"state"}}, "state")
+	assert.EqualError(t, err, "authorisation response has no code")
+}
+
+func TestTokenService_ReadsPastedRedirectWhenHeadless(t *testing.T) {
+	service := TokenService{
+		Config: oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth"}, RedirectURL: headlessRedirectUrl},
+		logger: utils.NewLogger(utils.Silent),
+		input:  strings.NewReader(headlessRedirectUrl + "?state=expected-state&code=auth-code\n"),
+	}
+
+	code, err := service.readCode(service.Config, "expected-state", oauth2.GenerateVerifier())
+	assert.NoError(t, err)
+	assert.Equal(t, "auth-code", code)
+
+	service.input = strings.NewReader(headlessRedirectUrl + "?state=forged&code=auth-code\n")
+	_, err = service.readCode(service.Config, "expected-state", oauth2.GenerateVerifier())
+	assert.ErrorIs(t, err, ErrStateMismatch)
+}
@@ -5,25 +5,41 @@ import (
 	"context"
 	"crypto/rand"
 	json2 "encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
 	"math/big"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
 	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
 	"golang.org/x/oauth2"
 )
 
+// headlessRedirectUrl is used when there is no browser on this machine. Nothing listens on it, the
+// user copies the address the browser fails to load instead
+const headlessRedirectUrl = "http://127.0.0.1:1/"
+
+// authorisationTimeout is how long the loopback listener waits for the user to grant access
+const authorisationTimeout = 5 * time.Minute
+
 type TokenService struct {
-	Config oauth2.Config
-	db     database.PhotoDatabase
-	logger utils.Logger
+	Config   oauth2.Config
+	db       database.PhotoDatabase
+	logger   utils.Logger
+	headless bool
+	input    io.Reader
 }
 
-func NewTokenService(configFilePath string, db database.PhotoDatabase, logger utils.Logger) (service TokenService, err error) {
+// NewTokenService creates a service that authorises with a loopback redirect, or by pasting the
+// redirect address when headless
+func NewTokenService(configFilePath string, headless bool, db database.PhotoDatabase, logger utils.Logger) (service TokenService, err error) {
 	config, err := loadConfig(configFilePath, logger)
 	if err == nil {
-		service = TokenService{Config: config, db: db, logger: logger}
+		service = TokenService{Config: config, db: db, logger: logger, headless: headless, input: os.Stdin}
 	}
 	return
 }
@@ -47,10 +63,10 @@ func loadConfig(filePath string, logger utils.Logger) (config oauth2.Config, err
 
 	logger.Debug.Print("creating oauth2 config")
 	installed := clientSecret.Installed
+	// the redirect url depends on how access is granted, so it is only set when authorising
 	return oauth2.Config{
 		ClientID:     installed.ClientID,
 		ClientSecret: installed.ClientSecret,
-		RedirectURL:  installed.RedirectUris[0],
 		Endpoint: oauth2.Endpoint{
 			AuthURL:   installed.AuthUri,
 			TokenURL:  installed.TokenUri,
@@ -63,7 +79,7 @@ func loadConfig(filePath string, logger utils.Logger) (config oauth2.Config, err
 	}, nil
 }
 
-func (ts *TokenService) LoadToken() (token *oauth2.Token, err error) {
+func (ts *TokenService) LoadToken(ctx context.Context) (token *oauth2.Token, err error) {
 	ts.logger.Debug.Print("loading token from database")
 	dbToken, err := ts.db.Settings.Token()
 	if err != nil {
@@ -72,7 +88,7 @@ func (ts *TokenService) LoadToken() (token *oauth2.Token, err error) {
 
 	if dbToken == "" {
 		ts.logger.Debug.Print("no token in database, asking to authorize")
-		token, err = ts.authorizeToken()
+		token, err = ts.Authorize(ctx)
 	} else {
 		ts.logger.Trace.Print("marshalling database json token to oauth token")
 		err = json2.Unmarshal([]byte(dbToken), &token)
@@ -81,6 +97,36 @@ func (ts *TokenService) LoadToken() (token *oauth2.Token, err error) {
 	return
 }
 
+// TokenSource returns a source of valid tokens that saves refreshed tokens to the database. The
+// stored token is refreshed straight away, so a revoked one leads to authorising again here rather
+// than failing halfway through a sync
+func (ts *TokenService) TokenSource(ctx context.Context) (source oauth2.TokenSource, err error) {
+	token, err := ts.LoadToken(ctx)
+	if err != nil {
+		return
+	}
+
+	source = ts.newTokenSource(token)
+	_, err = source.Token()
+	if errors.Is(err, ErrTokenRevoked) {
+		ts.logger.Error.Print("stored token was revoked, asking to authorize again")
+		token, err = ts.Authorize(ctx)
+		if err != nil {
+			return nil, err
+		}
+		source = ts.newTokenSource(token)
+		_, err = source.Token()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (ts *TokenService) newTokenSource(token *oauth2.Token) oauth2.TokenSource {
+	return newPersistingTokenSource(ts.Config.TokenSource(context.Background(), token), token, ts.saveToken, ts.logger)
+}
+
 func (ts *TokenService) saveToken(token *oauth2.Token) (err error) {
 	tokenBytes, err := json2.Marshal(token)
 	if err != nil {
@@ -90,19 +136,29 @@ func (ts *TokenService) saveToken(token *oauth2.Token) (err error) {
 	return
 }
 
-func (ts *TokenService) authorizeToken() (token *oauth2.Token, err error) {
-	fullUrl, err := buildAuthorizationUrl(ts.Config)
+// Authorize asks the user to grant access to their library and stores the resulting token. PKCE
+// ties the authorisation code to this process, so an intercepted code is of no use to anyone else
+func (ts *TokenService) Authorize(ctx context.Context) (token *oauth2.Token, err error) {
+	state, err := generateState()
 	if err != nil {
 		return
 	}
+	verifier := oauth2.GenerateVerifier()
 
-	ts.logger.Default.Printf("Please go here and authorize, %s\n", fullUrl)
-	ts.logger.Default.Printf("Paste the response token here: ")
-	reader := bufio.NewReader(os.Stdin)
-	authCode, _ := reader.ReadString('\n')
+	config := ts.Config
+	var authCode string
+	if ts.headless {
+		config.RedirectURL = headlessRedirectUrl
+		authCode, err = ts.readCode(config, state, verifier)
+	} else {
+		authCode, err = ts.receiveCode(ctx, &config, state, verifier)
+	}
+	if err != nil {
+		return
+	}
 
 	ts.logger.Debug.Print("using authcode to request new token json")
-	token, err = ts.Config.Exchange(context.TODO(), authCode)
+	token, err = config.Exchange(ctx, authCode, oauth2.VerifierOption(verifier))
 	if err == nil {
 		err = ts.saveToken(token)
 	}
@@ -110,14 +166,40 @@ func (ts *TokenService) authorizeToken() (token *oauth2.Token, err error) {
 	return
 }
 
-func buildAuthorizationUrl(config oauth2.Config) (authUrl string, err error) {
-	state, err := generateState()
+// receiveCode waits for google to redirect the browser to a listener on the loopback interface
+func (ts *TokenService) receiveCode(ctx context.Context, config *oauth2.Config, state string, verifier string) (string, error) {
+	listener, err := newLoopbackListener(state)
 	if err != nil {
-		return
+		return "", err
 	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	config.RedirectURL = listener.redirectUrl()
+	ts.logger.Default.Printf("Please go here and authorize, %s\n", buildAuthorizationUrl(*config, state, verifier))
+	ts.logger.Default.Printf("Waiting for the browser to return to %s", config.RedirectURL)
+	return listener.wait(ctx, authorisationTimeout)
+}
 
-	authUrl = config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("prompt", "select_account"))
-	return
+// readCode asks for the address the browser was redirected to, for machines without a browser
+func (ts *TokenService) readCode(config oauth2.Config, state string, verifier string) (string, error) {
+	ts.logger.Default.Printf("Please go here and authorize, %s\n", buildAuthorizationUrl(config, state, verifier))
+	ts.logger.Default.Printf("The browser then fails to load a page on %s, paste its address here: ", config.RedirectURL)
+	reader := bufio.NewReader(ts.input)
+	response, _ := reader.ReadString('\n')
+	response = strings.TrimSpace(response)
+
+	redirectUrl, err := url.Parse(response)
+	if err != nil {
+		return "", err
+	}
+	return parseCallback(redirectUrl.Query(), state)
+}
+
+func buildAuthorizationUrl(config oauth2.Config, state string, verifier string) string {
+	return config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("prompt", "select_account"))
 }
 
 // GenerateRandomString returns a securely generated random string.
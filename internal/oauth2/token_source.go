@@ -0,0 +1,60 @@
+package oauth2
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"golang.org/x/oauth2"
+)
+
+var ErrTokenRevoked = errors.New("the stored refresh token was revoked or has expired, run 'auth -force' to authorise again")
+
+// persistingTokenSource saves every refreshed token, so the next run doesn't start with an
+// expired access token
+type persistingTokenSource struct {
+	mutex       sync.Mutex
+	source      oauth2.TokenSource
+	accessToken string
+	save        func(token *oauth2.Token) error
+	logger      utils.Logger
+}
+
+func newPersistingTokenSource(source oauth2.TokenSource, token *oauth2.Token, save func(token *oauth2.Token) error, logger utils.Logger) *persistingTokenSource {
+	accessToken := ""
+	if token != nil {
+		accessToken = token.AccessToken
+	}
+	return &persistingTokenSource{source: source, accessToken: accessToken, save: save, logger: logger}
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err := s.source.Token()
+	if err != nil {
+		if isInvalidGrant(err) {
+			return nil, fmt.Errorf("%w: %s", ErrTokenRevoked, err)
+		}
+		return nil, err
+	}
+
+	if token.AccessToken != s.accessToken {
+		s.logger.Debug.Print("access token was refreshed, saving it to the database")
+		// the refreshed token is still usable, it only has to be refreshed again by the next run
+		saveErr := s.save(token)
+		if saveErr != nil {
+			s.logger.Error.Printf("saving refreshed token failed: %s", saveErr)
+		} else {
+			s.accessToken = token.AccessToken
+		}
+	}
+	return token, nil
+}
+
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
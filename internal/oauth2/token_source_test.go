@@ -0,0 +1,55 @@
+package oauth2
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+type mockTokenSource struct {
+	token *oauth2.Token
+	err   error
+}
+
+func (m *mockTokenSource) Token() (*oauth2.Token, error) {
+	return m.token, m.err
+}
+
+func TestPersistingTokenSource_SavesRefreshedTokens(t *testing.T) {
+	stored := &oauth2.Token{AccessToken: "stored", RefreshToken: "refresh"}
+	source := mockTokenSource{token: stored}
+	var saved []string
+	save := func(token *oauth2.Token) error {
+		saved = append(saved, token.AccessToken)
+		return nil
+	}
+	tokenSource := newPersistingTokenSource(&source, stored, save, utils.NewLogger(utils.Silent))
+
+	token, err := tokenSource.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", token.AccessToken)
+	assert.Empty(t, saved)
+
+	source.token = &oauth2.Token{AccessToken: "refreshed", RefreshToken: "refresh"}
+	_, err = tokenSource.Token()
+	assert.NoError(t, err)
+	_, err = tokenSource.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"refreshed"}, saved)
+}
+
+func TestPersistingTokenSource_DetectsRevokedTokens(t *testing.T) {
+	source := mockTokenSource{err: &oauth2.RetrieveError{ErrorCode: "invalid_grant"}}
+	save := func(token *oauth2.Token) error { return nil }
+	tokenSource := newPersistingTokenSource(&source, nil, save, utils.NewLogger(utils.Silent))
+
+	_, err := tokenSource.Token()
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	source.err = errors.New("connection refused")
+	_, err = tokenSource.Token()
+	assert.NotErrorIs(t, err, ErrTokenRevoked)
+}
@@ -1 +1,160 @@
 package options
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/internal/services"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type Options struct {
+	LibraryRoot            string
+	ClientSecretPath       string
+	Headless               bool
+	RequestsPerMinute      int
+	MediaRequestsPerMinute int
+	Workers                int
+	Bandwidth              services.BandwidthSchedule
+	MetricsAddress         string
+	AlbumLinks             services.LinkMode
+	IncludeShared          bool
+	Layout                 string
+	DryRun                 bool
+	DeletionPolicy         services.DeletionPolicy
+	TrashDays              int
+	Force                  bool
+	FullSyncDays           int
+	Schedule               services.Schedule
+	LogLevel               utils.LogLevel
+	LogFormat              utils.LogFormat
+	requiresApi            bool
+	downloads              bool
+	relayout               bool
+}
+
+// RegisterLibraryFlags adds the flags needed by every command that opens a library
+func (o *Options) RegisterLibraryFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.LibraryRoot, "library", "", "root `directory` of the photo library, also holds the database")
+	flags.TextVar(&o.LogLevel, "log-level", utils.Info, "logging `level`: silent, error, info, debug or trace")
+	flags.TextVar(&o.LogFormat, "log-format", utils.TextFormat, "logging `format`: text, or json with one object per line for log collectors")
+}
+
+// RegisterApiFlags adds the flags needed by commands that talk to the google photos api
+func (o *Options) RegisterApiFlags(flags *flag.FlagSet) {
+	o.requiresApi = true
+	flags.StringVar(&o.ClientSecretPath, "client-secret", "", "`path` to the google oauth2 client secret json file")
+	flags.BoolVar(&o.Headless, "headless", false, "authorise by pasting the redirect address, for machines without a browser")
+	flags.IntVar(&o.RequestsPerMinute, "requests-per-minute", 120, "`limit` of api calls per minute, 0 for no limit")
+	flags.IntVar(&o.MediaRequestsPerMinute, "media-requests-per-minute", 600, "`limit` of media downloads started per minute, 0 for no limit")
+}
+
+// RegisterDownloadFlags adds the flags needed by commands that download media items
+func (o *Options) RegisterDownloadFlags(flags *flag.FlagSet) {
+	o.downloads = true
+	flags.IntVar(&o.Workers, "workers", 5, "`number` of concurrent downloads")
+	flags.TextVar(&o.Bandwidth, "bandwidth", services.BandwidthSchedule{}, "bytes per second shared by all downloads, with optional times of day, e.g. 2MB,00:00-07:00=0")
+	flags.StringVar(&o.MetricsAddress, "metrics-address", "", "`address` to serve prometheus metrics on at /metrics, e.g. 127.0.0.1:9090, not served by default")
+	flags.TextVar(&o.AlbumLinks, "album-links", services.Symlinks, "how albums are materialised: symlink, hardlink or none to skip albums")
+	flags.BoolVar(&o.IncludeShared, "include-shared", false, "download media items other people added to shared albums")
+	flags.StringVar(&o.Layout, "layout", "", "layout `template` of downloaded files, defaults to the one stored in the library or "+layout.DefaultTemplate)
+}
+
+// RegisterFullSyncFlags adds the flags of commands that run a full sync when it is due. The interval
+// is stored in the library when given
+func (o *Options) RegisterFullSyncFlags(flags *flag.FlagSet) {
+	flags.IntVar(&o.FullSyncDays, "full-sync-days", -1, "`days` between listing the whole library to find missed media items, 0 disables it")
+}
+
+// RegisterDaemonFlags adds the flags of the daemon command
+func (o *Options) RegisterDaemonFlags(flags *flag.FlagSet) {
+	hourly, _ := services.ParseSchedule("1h")
+	flags.TextVar(&o.Schedule, "schedule", hourly, "how often to sync, an interval like 30m or a cron expression like '0 3 * * *'")
+}
+
+// RegisterDryRunFlags adds the flag of commands that can plan a sync without changing the library
+func (o *Options) RegisterDryRunFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&o.DryRun, "dry-run", false, "list new media items and the files that would be downloaded, without downloading or indexing them")
+}
+
+// RegisterRelayoutFlags adds the flags of the relayout command
+func (o *Options) RegisterRelayoutFlags(flags *flag.FlagSet) {
+	o.relayout = true
+	flags.StringVar(&o.Layout, "layout", "", "new layout `template` of downloaded files")
+	flags.BoolVar(&o.DryRun, "dry-run", false, "show where files would be moved without moving them")
+}
+
+// RegisterStatusFlags adds the flags of the status command
+func (o *Options) RegisterStatusFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&o.IncludeShared, "include-shared", false, "count media items other people added to shared albums as pending")
+}
+
+// RegisterReconcileFlags adds the flags of the reconcile command. The deletion policy and trash days
+// are stored in the library when given, so later runs use them too
+func (o *Options) RegisterReconcileFlags(flags *flag.FlagSet) {
+	flags.TextVar(&o.DeletionPolicy, "deleted", services.DeletionPolicy(""), "`policy` for files of media items deleted from google photos: keep, trash or delete")
+	flags.IntVar(&o.TrashDays, "trash-days", -1, "`days` files stay in the trash before they are removed, 0 removes them straight away")
+	flags.BoolVar(&o.Force, "force", false, "mark media items as deleted even when more than half of the library is missing from the listing")
+}
+
+func (o *Options) Validate() error {
+	if o.LibraryRoot == "" {
+		return errors.New("-library is required")
+	}
+
+	stat, err := os.Stat(o.LibraryRoot)
+	if err != nil {
+		return fmt.Errorf("library root '%s' is not accessible: %w", o.LibraryRoot, err)
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("library root '%s' is not a directory", o.LibraryRoot)
+	}
+
+	if o.requiresApi && o.ClientSecretPath == "" {
+		return errors.New("-client-secret is required")
+	}
+
+	if o.RequestsPerMinute < 0 {
+		return fmt.Errorf("-requests-per-minute must not be negative, got %d", o.RequestsPerMinute)
+	}
+
+	if o.MediaRequestsPerMinute < 0 {
+		return fmt.Errorf("-media-requests-per-minute must not be negative, got %d", o.MediaRequestsPerMinute)
+	}
+
+	if o.downloads && o.Workers < 1 {
+		return fmt.Errorf("-workers must be at least 1, got %d", o.Workers)
+	}
+
+	if o.MetricsAddress != "" {
+		_, _, err = net.SplitHostPort(o.MetricsAddress)
+		if err != nil {
+			return fmt.Errorf("-metrics-address is invalid: %w", err)
+		}
+	}
+
+	if o.TrashDays < -1 {
+		return fmt.Errorf("-trash-days must not be negative, got %d", o.TrashDays)
+	}
+
+	if o.FullSyncDays < -1 {
+		return fmt.Errorf("-full-sync-days must not be negative, got %d", o.FullSyncDays)
+	}
+
+	if o.relayout && o.Layout == "" {
+		return errors.New("-layout is required")
+	}
+
+	if o.Layout != "" {
+		_, err = layout.Parse(o.Layout)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
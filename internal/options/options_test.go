@@ -0,0 +1,193 @@
+package options
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/services"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseOptions(t *testing.T, args ...string) Options {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterApiFlags(flags)
+	opts.RegisterDownloadFlags(flags)
+
+	err := flags.Parse(args)
+	assert.NoError(t, err)
+	return opts
+}
+
+func TestOptionsDefaults(t *testing.T) {
+	opts := parseOptions(t)
+
+	assert.Equal(t, utils.Info, opts.LogLevel)
+	assert.Equal(t, utils.TextFormat, opts.LogFormat)
+	assert.Equal(t, 5, opts.Workers)
+	assert.Equal(t, services.Symlinks, opts.AlbumLinks)
+	assert.Empty(t, opts.LibraryRoot)
+	assert.Empty(t, opts.ClientSecretPath)
+	assert.Equal(t, 120, opts.RequestsPerMinute)
+	assert.Equal(t, 600, opts.MediaRequestsPerMinute)
+	assert.Zero(t, opts.Bandwidth.Limit(time.Now()))
+	assert.Empty(t, opts.MetricsAddress)
+}
+
+func TestOptionsParsesBandwidthSchedule(t *testing.T) {
+	opts := parseOptions(t, "-bandwidth", "2MB,00:00-07:00=0")
+
+	assert.Equal(t, int64(2000000), opts.Bandwidth.Limit(time.Date(2021, 12, 3, 12, 0, 0, 0, time.Local)))
+	assert.Zero(t, opts.Bandwidth.Limit(time.Date(2021, 12, 3, 3, 0, 0, 0, time.Local)))
+}
+
+func TestOptionsParsesFlags(t *testing.T) {
+	opts := parseOptions(t, "-library", os.TempDir(), "-client-secret", "secret.json", "-workers", "2", "-log-level", "trace", "-log-format", "json", "-album-links", "hardlink", "-layout", "{year}/{filename}", "-headless")
+
+	assert.Equal(t, os.TempDir(), opts.LibraryRoot)
+	assert.Equal(t, "secret.json", opts.ClientSecretPath)
+	assert.True(t, opts.Headless)
+	assert.Equal(t, 2, opts.Workers)
+	assert.Equal(t, utils.Trace, opts.LogLevel)
+	assert.Equal(t, utils.JsonFormat, opts.LogFormat)
+	assert.Equal(t, services.Hardlinks, opts.AlbumLinks)
+	assert.Equal(t, "{year}/{filename}", opts.Layout)
+	assert.NoError(t, opts.Validate())
+}
+
+func TestOptionsValidate(t *testing.T) {
+	missingDir := filepath.Join(os.TempDir(), "gphotos-downloader-missing-library")
+
+	type testCase struct {
+		name     string
+		args     []string
+		expected string
+	}
+	testCases := []testCase{
+		{name: "missing library", args: []string{"-client-secret", "secret.json"}, expected: "-library is required"},
+		{name: "missing client secret", args: []string{"-library", os.TempDir()}, expected: "-client-secret is required"},
+		{name: "negative requests per minute", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-requests-per-minute", "-1"}, expected: "-requests-per-minute must not be negative, got -1"},
+		{name: "negative media requests per minute", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-media-requests-per-minute", "-5"}, expected: "-media-requests-per-minute must not be negative, got -5"},
+		{name: "zero workers", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-workers", "0"}, expected: "-workers must be at least 1, got 0"},
+		{name: "library does not exist", args: []string{"-library", missingDir, "-client-secret", "a"}, expected: "library root '" + missingDir + "' is not accessible"},
+		{name: "invalid metrics address", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-metrics-address", "9090"}, expected: "-metrics-address is invalid"},
+		{name: "invalid layout", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-layout", "{year}"}, expected: "must end with a segment containing {filename}"},
+		{name: "reserved layout", args: []string{"-library", os.TempDir(), "-client-secret", "a", "-layout", ".staging/{filename}"}, expected: "can't place files in '.staging', the downloader uses it"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := parseOptions(t, tc.args...)
+			err := opts.Validate()
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+func TestOptionsValidateOnlyChecksRegisteredFlags(t *testing.T) {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterLibraryFlags(flags)
+
+	err := flags.Parse([]string{"-library", os.TempDir()})
+	assert.NoError(t, err)
+	assert.NoError(t, opts.Validate())
+}
+
+func TestOptionsValidateRequiresLayoutForRelayout(t *testing.T) {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterRelayoutFlags(flags)
+
+	err := flags.Parse([]string{"-library", os.TempDir(), "-dry-run"})
+	assert.NoError(t, err)
+	assert.True(t, opts.DryRun)
+	assert.EqualError(t, opts.Validate(), "-layout is required")
+}
+
+func TestOptionsParsesReconcileFlags(t *testing.T) {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterReconcileFlags(flags)
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir()}))
+	assert.Equal(t, services.DeletionPolicy(""), opts.DeletionPolicy)
+	assert.Equal(t, -1, opts.TrashDays)
+	assert.False(t, opts.Force)
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-deleted", "trash", "-trash-days", "7", "-force"}))
+	assert.Equal(t, services.TrashDeleted, opts.DeletionPolicy)
+	assert.Equal(t, 7, opts.TrashDays)
+	assert.True(t, opts.Force)
+	assert.NoError(t, opts.Validate())
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-trash-days", "0"}))
+	assert.Equal(t, 0, opts.TrashDays)
+	assert.NoError(t, opts.Validate())
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-trash-days", "-2"}))
+	assert.EqualError(t, opts.Validate(), "-trash-days must not be negative, got -2")
+
+	assert.Error(t, flags.Parse([]string{"-deleted", "archive"}))
+}
+
+func TestOptionsParsesFullSyncFlags(t *testing.T) {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterFullSyncFlags(flags)
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir()}))
+	assert.Equal(t, -1, opts.FullSyncDays)
+	assert.NoError(t, opts.Validate())
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-full-sync-days", "0"}))
+	assert.Equal(t, 0, opts.FullSyncDays)
+	assert.NoError(t, opts.Validate())
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-full-sync-days", "-2"}))
+	assert.EqualError(t, opts.Validate(), "-full-sync-days must not be negative, got -2")
+}
+
+func TestOptionsParsesDaemonFlags(t *testing.T) {
+	opts := Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterDaemonFlags(flags)
+	started := time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir()}))
+	assert.Equal(t, started.Add(time.Hour), opts.Schedule.Next(started))
+
+	assert.NoError(t, flags.Parse([]string{"-schedule", "0 3 * * *"}))
+	assert.Equal(t, time.Date(2021, 12, 4, 3, 0, 0, 0, time.UTC), opts.Schedule.Next(started))
+
+	assert.Error(t, flags.Parse([]string{"-schedule", "sometimes"}))
+}
+
+func TestOptionsParsesDryRunFlags(t *testing.T) {
+	opts := parseOptions(t)
+	assert.False(t, opts.DryRun)
+
+	opts = Options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.RegisterLibraryFlags(flags)
+	opts.RegisterDryRunFlags(flags)
+
+	assert.NoError(t, flags.Parse([]string{"-library", os.TempDir(), "-dry-run"}))
+	assert.True(t, opts.DryRun)
+	assert.NoError(t, opts.Validate())
+}
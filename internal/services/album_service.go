@@ -0,0 +1,541 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	api "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// AlbumsDir is the directory, relative to the library root, that albums are materialised in
+const AlbumsDir = "albums"
+
+type LinkMode string
+
+const (
+	NoLinks   LinkMode = "none"
+	Symlinks  LinkMode = "symlink"
+	Hardlinks LinkMode = "hardlink"
+)
+
+func ParseLinkMode(mode string) (LinkMode, error) {
+	switch linkMode := LinkMode(mode); linkMode {
+	case NoLinks, Symlinks, Hardlinks:
+		return linkMode, nil
+	}
+	return NoLinks, fmt.Errorf("unknown album link mode '%s'", mode)
+}
+
+func (l LinkMode) MarshalText() ([]byte, error) {
+	return []byte(l), nil
+}
+
+func (l *LinkMode) UnmarshalText(text []byte) (err error) {
+	*l, err = ParseLinkMode(string(text))
+	return
+}
+
+type AlbumOptions struct {
+	RootDir  string
+	LinkMode LinkMode
+	Layout   layout.Template
+	// IncludeShared queues media items other people added to shared albums for download
+	IncludeShared bool
+}
+
+type AlbumService struct {
+	api             googlephotos.Downloader
+	db              database.PhotoDatabase
+	indexer         mediaItemIndexer
+	logger          utils.Logger
+	rootDir         string
+	linkMode        LinkMode
+	includeShared   bool
+	albumPagingSize int
+	pagingSize      int
+	// media items of shared albums, kept until the library has been indexed
+	sharedItems []api.MediaItem
+}
+
+func NewAlbumService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, logger utils.Logger, options AlbumOptions) AlbumService {
+	return AlbumService{
+		api:             api,
+		db:              db,
+		indexer:         mediaItemIndexer{db: db, download: download, layout: options.Layout, logger: logger},
+		logger:          logger,
+		rootDir:         options.RootDir,
+		linkMode:        options.LinkMode,
+		includeShared:   options.IncludeShared,
+		albumPagingSize: 50,
+		pagingSize:      100,
+	}
+}
+
+type albumLister func(ctx context.Context, options api.PagingOptions) (api.Albums, error)
+
+// Sync indexes the albums of the library, the shared albums of the user and which media items
+// belong to them. It runs before the library is indexed so album names are known when media
+// items are laid out, IndexShared is called afterwards for the items of shared albums
+func (s *AlbumService) Sync(ctx context.Context) error {
+	existingAlbums, err := s.db.Albums.GetAll()
+	if err != nil {
+		return err
+	}
+
+	s.sharedItems = nil
+	state := albumSyncState{
+		albums:      map[string]database.Album{},
+		usedPaths:   map[string]bool{},
+		synced:      map[string]bool{},
+		sharedItems: map[string]bool{},
+	}
+	for _, album := range existingAlbums {
+		state.albums[album.RemoteId] = album
+		state.usedPaths[album.LocalPath] = true
+	}
+
+	err = s.syncAlbums(ctx, s.api.ListAlbums, false, &state)
+	if err != nil {
+		return err
+	}
+	err = s.syncAlbums(ctx, s.api.ListSharedAlbums, true, &state)
+	if err != nil {
+		return err
+	}
+
+	// albums that are no longer listed were deleted or left, their directories go with them in Link
+	for _, album := range existingAlbums {
+		if state.synced[album.RemoteId] {
+			continue
+		}
+		s.logger.Info.Printf("album '%s' is no longer in the library, removing it", album.Title)
+		err = s.db.Albums.Delete(album.Uuid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type albumSyncState struct {
+	albums    map[string]database.Album
+	usedPaths map[string]bool
+	// an album can be returned by both albums.list and sharedAlbums.list
+	synced map[string]bool
+	// a media item can be in more than one shared album
+	sharedItems map[string]bool
+}
+
+func (s *AlbumService) syncAlbums(ctx context.Context, list albumLister, shared bool, state *albumSyncState) error {
+	options := api.PagingOptions{Size: s.albumPagingSize}
+	for {
+		albums, err := list(ctx, options)
+		if err != nil {
+			return err
+		}
+
+		for _, apiAlbum := range albums.Albums {
+			if state.synced[apiAlbum.Id] {
+				continue
+			}
+			state.synced[apiAlbum.Id] = true
+
+			album, ok := state.albums[apiAlbum.Id]
+			if !ok {
+				album = database.Album{RemoteId: apiAlbum.Id, LocalPath: albumLocalPath(apiAlbum.Title, state.usedPaths)}
+				state.usedPaths[album.LocalPath] = true
+			} else if album.Title != apiAlbum.Title {
+				// a renamed album moves to a directory named after its new title
+				delete(state.usedPaths, album.LocalPath)
+				album.LocalPath = albumLocalPath(apiAlbum.Title, state.usedPaths)
+				state.usedPaths[album.LocalPath] = true
+			}
+			album.Shared = shared || apiAlbum.IsShared()
+
+			err = s.syncAlbum(ctx, apiAlbum, album, ok, state)
+			if err != nil {
+				return err
+			}
+		}
+
+		if albums.NextPageToken == "" {
+			break
+		}
+		options.Token = albums.NextPageToken
+	}
+	return nil
+}
+
+func (s *AlbumService) syncAlbum(ctx context.Context, apiAlbum api.Album, album database.Album, exists bool, state *albumSyncState) error {
+	s.logger.Debug.Printf("indexing album '%s'", apiAlbum.Title)
+	items, err := s.albumMediaItems(ctx, apiAlbum.Id)
+	if err != nil {
+		return err
+	}
+
+	if album.Shared {
+		for _, item := range items {
+			if !state.sharedItems[item.Id] {
+				state.sharedItems[item.Id] = true
+				s.sharedItems = append(s.sharedItems, item)
+			}
+		}
+	}
+
+	remoteIds := make([]string, 0, len(items))
+	for _, item := range items {
+		remoteIds = append(remoteIds, item.Id)
+	}
+
+	album.Title = apiAlbum.Title
+	album.ItemCount = len(remoteIds)
+	album.SyncedAt = time.Now()
+	if exists {
+		err = s.db.Albums.Update(album)
+	} else {
+		err = s.db.Albums.Save(&album)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.db.Albums.ReplaceItems(album.Uuid, remoteIds)
+}
+
+// IndexShared indexes the media items of shared albums that aren't already known. It is called
+// after the library has been indexed, so these are the items other people contributed
+func (s *AlbumService) IndexShared() error {
+	items := s.sharedItems
+	s.sharedItems = nil
+
+	var newItems []api.MediaItem
+	for _, item := range items {
+		exists, err := s.db.MediaItems.Exists(item.Id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			newItems = append(newItems, item)
+		}
+	}
+
+	if len(newItems) == 0 {
+		return nil
+	}
+
+	s.logger.Debug.Printf("indexing %d media items contributed to shared albums", len(newItems))
+	return s.indexer.index(newItems, database.SourceShared, s.includeShared)
+}
+
+func (s *AlbumService) albumMediaItems(ctx context.Context, albumId string) (mediaItems []api.MediaItem, err error) {
+	options := api.SearchOptions{AlbumId: albumId, Size: s.pagingSize}
+	for {
+		var items api.MediaItems
+		items, err = s.api.Search(ctx, options)
+		if err != nil {
+			return
+		}
+
+		mediaItems = append(mediaItems, items.MediaItems...)
+
+		if items.NextPageToken == "" {
+			break
+		}
+		options.Token = items.NextPageToken
+	}
+	return
+}
+
+// Link materialises every album as a directory of links to the downloaded files of its media items.
+// Links of media items no longer in an album, and directories of albums that were renamed or
+// removed, are cleaned up. Only links are removed, other files under albums/ are left alone
+func (s *AlbumService) Link() error {
+	if s.linkMode == NoLinks {
+		return nil
+	}
+
+	albums, err := s.db.Albums.GetAll()
+	if err != nil {
+		return err
+	}
+
+	albumPaths := map[string]bool{}
+	for _, album := range albums {
+		albumPaths[album.LocalPath] = true
+		err = s.linkAlbum(album)
+		if err != nil {
+			return err
+		}
+	}
+	return s.removeStaleAlbumDirs(albumPaths)
+}
+
+func (s *AlbumService) linkAlbum(album database.Album) error {
+	items, err := s.db.Albums.GetDownloadedItems(album.Uuid)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return s.removeAlbumDir(album.LocalPath)
+	}
+
+	albumDir := filepath.Join(s.rootDir, album.LocalPath)
+	s.logger.Trace.Printf("ensuring album directory '%s'", albumDir)
+	err = os.MkdirAll(albumDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	usedNames := map[string]bool{}
+	for _, item := range items {
+		target := filepath.Join(s.rootDir, item.LocalPath, item.LocalFilename)
+		// a missing file is reported by verify, it shouldn't stop the other links being made
+		_, err = os.Stat(target)
+		if err != nil {
+			s.logger.Error.Printf("not linking '%s' into album '%s': %s", item.LocalFilename, album.Title, err)
+			continue
+		}
+
+		linkName := item.LocalFilename
+		for counter := 2; usedNames[linkName]; counter++ {
+			linkName = generateNewFilename(counter, item.LocalFilename)
+		}
+		usedNames[linkName] = true
+
+		linkPath := filepath.Join(albumDir, linkName)
+		replaceable, err := s.replaceable(linkPath)
+		if err != nil {
+			return err
+		}
+		if !replaceable {
+			s.logger.Error.Printf("not linking '%s' into album '%s', a file the downloader didn't make is in the way", linkName, album.Title)
+			continue
+		}
+
+		err = s.link(target, linkPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.removeStaleLinks(albumDir, usedNames)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug.Printf("linked %d media items into album '%s'", len(usedNames), album.Title)
+	return nil
+}
+
+// removeStaleLinks removes the links in an album directory that aren't named in linkNames
+func (s *AlbumService) removeStaleLinks(albumDir string, linkNames map[string]bool) error {
+	entries, err := os.ReadDir(albumDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if linkNames[entry.Name()] {
+			continue
+		}
+
+		err = s.removeLink(albumDir, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleAlbumDirs removes the directories of albums that were renamed or removed
+func (s *AlbumService) removeStaleAlbumDirs(albumPaths map[string]bool) error {
+	entries, err := os.ReadDir(filepath.Join(s.rootDir, AlbumsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		localPath := filepath.Join(AlbumsDir, entry.Name())
+		if albumPaths[localPath] {
+			continue
+		}
+
+		if entry.IsDir() {
+			s.logger.Debug.Printf("removing '%s', its album was renamed or removed", localPath)
+			err = s.removeAlbumDir(localPath)
+		} else {
+			err = s.removeLink(filepath.Join(s.rootDir, AlbumsDir), entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAlbumDir removes the links in the directory of an album, and the directory when nothing
+// else is left in it
+func (s *AlbumService) removeAlbumDir(localPath string) error {
+	albumDir := filepath.Join(s.rootDir, localPath)
+	err := s.removeStaleLinks(albumDir, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(albumDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Debug.Printf("keeping '%s', it holds files the downloader didn't make", localPath)
+	}
+	return nil
+}
+
+// removeLink removes an entry of dir if it is a link made by Link
+func (s *AlbumService) removeLink(dir string, entry fs.DirEntry) error {
+	path := filepath.Join(dir, entry.Name())
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	madeByLink, err := s.madeByLink(path, info)
+	if err != nil {
+		return err
+	}
+	if !madeByLink {
+		s.logger.Debug.Printf("keeping '%s', the downloader didn't make it", path)
+		return nil
+	}
+
+	s.logger.Trace.Printf("removing link '%s' of a media item no longer in the album", path)
+	return removeExisting(path)
+}
+
+// replaceable reports whether a link can be made at path, because nothing is there or only a link
+// made by Link
+func (s *AlbumService) replaceable(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.madeByLink(path, info)
+}
+
+// madeByLink reports whether the file at path is a link Link makes: a symlink, or a hard link to
+// the downloaded file of a media item somewhere else in the library
+func (s *AlbumService) madeByLink(path string, info fs.FileInfo) (bool, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return true, nil
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	items, err := s.db.MediaItems.GetDownloadedWithSize(int(info.Size()))
+	if err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		target := filepath.Join(s.rootDir, item.LocalPath, item.LocalFilename)
+		// the downloaded file itself isn't a link to it
+		if target == path {
+			continue
+		}
+
+		targetInfo, err := os.Stat(target)
+		if err == nil && os.SameFile(info, targetInfo) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *AlbumService) link(target string, linkPath string) error {
+	if s.linkMode == Hardlinks {
+		return s.hardlink(target, linkPath)
+	}
+	return s.symlink(target, linkPath)
+}
+
+func (s *AlbumService) symlink(target string, linkPath string) error {
+	// relative links keep working if the whole library is moved
+	relativeTarget, err := filepath.Rel(filepath.Dir(linkPath), target)
+	if err != nil {
+		return err
+	}
+
+	existingTarget, err := os.Readlink(linkPath)
+	if err == nil && existingTarget == relativeTarget {
+		return nil
+	}
+
+	err = removeExisting(linkPath)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Trace.Printf("linking '%s' to '%s'", linkPath, relativeTarget)
+	return os.Symlink(relativeTarget, linkPath)
+}
+
+func (s *AlbumService) hardlink(target string, linkPath string) error {
+	targetStat, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	linkStat, err := os.Lstat(linkPath)
+	if err == nil && os.SameFile(targetStat, linkStat) {
+		return nil
+	}
+
+	err = removeExisting(linkPath)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Trace.Printf("hard linking '%s' to '%s'", linkPath, target)
+	return os.Link(target, linkPath)
+}
+
+func removeExisting(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// albumLocalPath builds a directory name for an album that is safe on common filesystems
+// and not already used by another album
+func albumLocalPath(title string, usedPaths map[string]bool) string {
+	name := layout.SafeName(strings.Trim(title, " ."))
+	if name == "" {
+		name = "untitled"
+	}
+
+	localPath := filepath.Join(AlbumsDir, name)
+	for counter := 2; usedPaths[localPath]; counter++ {
+		localPath = filepath.Join(AlbumsDir, fmt.Sprintf("%s_%03d", name, counter))
+	}
+	return localPath
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func createAlbumService(t *testing.T, downloader googlephotos.Downloader, linkMode LinkMode) AlbumService {
+	db := database.CreateTestDatabase(t)
+	return NewAlbumService(downloader, db, &mockQueuer{}, db.Logger, AlbumOptions{RootDir: t.TempDir(), LinkMode: linkMode, Layout: layout.Default})
+}
+
+func albumSearchResult(ids ...string) models.MediaItems {
+	var items models.MediaItems
+	for _, id := range ids {
+		items.MediaItems = append(items.MediaItems, models.MediaItem{Id: id})
+	}
+	return items
+}
+
+func TestAlbumServiceIndexesAlbumsAndMembership(t *testing.T) {
+	downloader := mockDownloader{
+		listAlbums: func(options models.PagingOptions) (albums models.Albums, err error) {
+			if options.Token == "" {
+				return models.Albums{Albums: []models.Album{{Id: "album-1", Title: "Holiday"}}, NextPageToken: "next"}, nil
+			}
+			return models.Albums{Albums: []models.Album{{Id: "album-2", Title: "Holiday"}}}, nil
+		},
+		search: func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+			assert.Empty(t, options.Filters)
+			if options.AlbumId == "album-1" {
+				if options.Token == "" {
+					items := albumSearchResult("item-1")
+					items.NextPageToken = "next"
+					return items, nil
+				}
+				return albumSearchResult("item-2"), nil
+			}
+			return albumSearchResult("item-3"), nil
+		},
+	}
+
+	service := createAlbumService(t, &downloader, Symlinks)
+	err := service.Sync(context.Background())
+	assert.NoError(t, err)
+
+	albums, err := service.db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, albums, 2)
+	assert.Equal(t, "album-1", albums[0].RemoteId)
+	assert.Equal(t, filepath.Join("albums", "Holiday"), albums[0].LocalPath)
+	assert.Equal(t, 2, albums[0].ItemCount)
+	assert.Equal(t, "album-2", albums[1].RemoteId)
+	assert.Equal(t, filepath.Join("albums", "Holiday_002"), albums[1].LocalPath)
+	assert.Equal(t, 1, albums[1].ItemCount)
+
+	// a second sync keeps the existing albums and their directories
+	err = service.Sync(context.Background())
+	assert.NoError(t, err)
+
+	resyncedAlbums, err := service.db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, resyncedAlbums, 2)
+	assert.Equal(t, albums[0].Uuid, resyncedAlbums[0].Uuid)
+	assert.Equal(t, albums[1].LocalPath, resyncedAlbums[1].LocalPath)
+}
+
+func TestAlbumServiceIndexesItemsContributedToSharedAlbums(t *testing.T) {
+	ownItem := createMediaItem(t)
+	contributedItem := createMediaItem(t)
+	contributedItem.Id = "ALU181gS07lNXbEvg"
+	contributedItem.Filename = "family.jpg"
+	contributedItem.ContributorInfo.DisplayName = "Jane"
+
+	downloader := mockDownloader{
+		listSharedAlbums: func(options models.PagingOptions) (albums models.Albums, err error) {
+			shareInfo := &models.ShareInfo{IsJoined: true}
+			return models.Albums{Albums: []models.Album{{Id: "shared-1", Title: "Family", ShareInfo: shareInfo}}}, nil
+		},
+		search: func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+			return models.MediaItems{MediaItems: []models.MediaItem{ownItem, contributedItem}}, nil
+		},
+	}
+
+	for _, includeShared := range []bool{false, true} {
+		queuer := mockQueuer{}
+		db := database.CreateTestDatabase(t)
+		service := NewAlbumService(&downloader, db, &queuer, db.Logger, AlbumOptions{RootDir: t.TempDir(), IncludeShared: includeShared, Layout: layout.Default})
+
+		err := service.Sync(context.Background())
+		assert.NoError(t, err)
+
+		// the library is indexed between syncing albums and indexing shared items
+		existing := convertToDatabaseMediaItem(layout.Default, ownItem)[0]
+		assert.NoError(t, db.MediaItems.Save(existing))
+
+		err = service.IndexShared()
+		assert.NoError(t, err)
+
+		albums, err := db.Albums.GetAll()
+		assert.NoError(t, err)
+		assert.Len(t, albums, 1)
+		assert.True(t, albums[0].Shared)
+		assert.Equal(t, 2, albums[0].ItemCount)
+
+		dbItems, err := db.MediaItems.GetAll()
+		assert.NoError(t, err)
+		assert.Len(t, dbItems, 2)
+		assert.Equal(t, database.SourceLibrary, dbItems[0].Source)
+		assert.Equal(t, database.SourceShared, dbItems[1].Source)
+		assert.Equal(t, "Jane", dbItems[1].Contributor)
+
+		if includeShared {
+			assert.Equal(t, []string{dbItems[1].Uuid}, queuer.queuedIds)
+		} else {
+			assert.Empty(t, queuer.queuedIds)
+		}
+	}
+}
+
+func TestAlbumServiceLaysOutSharedItemsByAlbum(t *testing.T) {
+	contributedItem := createMediaItem(t)
+	downloader := mockDownloader{
+		listSharedAlbums: func(options models.PagingOptions) (albums models.Albums, err error) {
+			return models.Albums{Albums: []models.Album{{Id: "shared-1", Title: "Family: 2021", ShareInfo: &models.ShareInfo{}}}}, nil
+		},
+		search: func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+			return models.MediaItems{MediaItems: []models.MediaItem{contributedItem}}, nil
+		},
+	}
+
+	db := database.CreateTestDatabase(t)
+	options := AlbumOptions{RootDir: t.TempDir(), Layout: layout.MustParse("{album}/{filename}")}
+	service := NewAlbumService(&downloader, db, &mockQueuer{}, db.Logger, options)
+	assert.NoError(t, service.Sync(context.Background()))
+	assert.NoError(t, service.IndexShared())
+
+	dbItems, err := db.MediaItems.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, dbItems, 1)
+	assert.Equal(t, "Family_ 2021", dbItems[0].LocalPath)
+}
+
+func TestAlbumServiceSyncsAlbumsListedTwiceOnce(t *testing.T) {
+	albums := models.Albums{Albums: []models.Album{{Id: "album-1", Title: "Family", ShareInfo: &models.ShareInfo{IsOwned: true}}}}
+	downloader := mockDownloader{
+		listAlbums: func(options models.PagingOptions) (models.Albums, error) {
+			return albums, nil
+		},
+		listSharedAlbums: func(options models.PagingOptions) (models.Albums, error) {
+			return albums, nil
+		},
+	}
+	searchCount := 0
+	downloader.search = func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+		searchCount++
+		return albumSearchResult("item-1"), nil
+	}
+
+	service := createAlbumService(t, &downloader, Symlinks)
+	assert.NoError(t, service.Sync(context.Background()))
+	assert.Equal(t, 1, searchCount)
+
+	dbAlbums, err := service.db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, dbAlbums, 1)
+	assert.True(t, dbAlbums[0].Shared)
+}
+
+func saveLinkedAlbum(t *testing.T, service AlbumService) (database.Album, database.MediaItem, database.MediaItem) {
+	first := database.CreateTestMediaItem(t)
+	second := database.CreateTestMediaItem(t)
+	second.LocalPath = "2012/12/13"
+	second.LocalFilename = first.LocalFilename
+	assert.NoError(t, service.db.MediaItems.Save(&first, &second))
+	writeLibraryFile(t, service.rootDir, first, "first")
+	writeLibraryFile(t, service.rootDir, second, "second")
+
+	album := database.Album{RemoteId: "album-1", Title: "Holiday", LocalPath: filepath.Join(AlbumsDir, "Holiday")}
+	assert.NoError(t, service.db.Albums.Save(&album))
+	assert.NoError(t, service.db.Albums.ReplaceItems(album.Uuid, []string{first.RemoteId, second.RemoteId}))
+	return album, first, second
+}
+
+func TestAlbumServiceCreatesSymlinks(t *testing.T) {
+	service := createAlbumService(t, nil, Symlinks)
+	album, first, second := saveLinkedAlbum(t, service)
+
+	assert.NoError(t, service.Link())
+	// linking again leaves the existing links in place
+	assert.NoError(t, service.Link())
+
+	albumDir := filepath.Join(service.rootDir, album.LocalPath)
+	target, err := os.Readlink(filepath.Join(albumDir, first.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "..", first.LocalPath, first.LocalFilename), target)
+
+	content, err := os.ReadFile(filepath.Join(albumDir, generateNewFilename(2, second.LocalFilename)))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+}
+
+func TestAlbumServiceCreatesHardlinks(t *testing.T) {
+	service := createAlbumService(t, nil, Hardlinks)
+	album, first, _ := saveLinkedAlbum(t, service)
+
+	assert.NoError(t, service.Link())
+	assert.NoError(t, service.Link())
+
+	linkStat, err := os.Lstat(filepath.Join(service.rootDir, album.LocalPath, first.LocalFilename))
+	assert.NoError(t, err)
+	targetStat, err := os.Stat(filepath.Join(service.rootDir, first.LocalPath, first.LocalFilename))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(targetStat, linkStat))
+}
+
+func TestAlbumServiceRemovesStaleLinksAndAlbumDirs(t *testing.T) {
+	service := createAlbumService(t, nil, Symlinks)
+	album, first, second := saveLinkedAlbum(t, service)
+	assert.NoError(t, service.Link())
+
+	// the second media item left the album, and an album was removed since the last link
+	assert.NoError(t, service.db.Albums.ReplaceItems(album.Uuid, []string{first.RemoteId}))
+	removedDir := filepath.Join(service.rootDir, AlbumsDir, "Removed")
+	assert.NoError(t, os.MkdirAll(removedDir, 0755))
+
+	assert.NoError(t, service.Link())
+
+	albumDir := filepath.Join(service.rootDir, album.LocalPath)
+	entries, err := os.ReadDir(albumDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, first.LocalFilename, entries[0].Name())
+	assert.NoDirExists(t, removedDir)
+	assert.FileExists(t, filepath.Join(service.rootDir, second.LocalPath, second.LocalFilename))
+}
+
+func TestAlbumServiceOnlyRemovesLinksItMade(t *testing.T) {
+	service := createAlbumService(t, nil, Hardlinks)
+	album, first, second := saveLinkedAlbum(t, service)
+	// a download laid out under albums/ before the directory was reserved
+	legacy := database.CreateTestMediaItem(t)
+	legacy.LocalPath = filepath.Join(AlbumsDir, "2012")
+	assert.NoError(t, service.db.MediaItems.Save(&legacy))
+	writeLibraryFile(t, service.rootDir, legacy, "legacy")
+	for item, size := range map[string]int64{first.Uuid: 5, second.Uuid: 6, legacy.Uuid: 6} {
+		assert.NoError(t, service.db.MediaItems.MarkAsSynced(item, size, ""))
+	}
+	assert.NoError(t, service.Link())
+
+	albumDir := filepath.Join(service.rootDir, album.LocalPath)
+	removedDir := filepath.Join(service.rootDir, AlbumsDir, "Removed")
+	assert.NoError(t, os.MkdirAll(removedDir, 0755))
+	assert.NoError(t, os.Link(filepath.Join(service.rootDir, first.LocalPath, first.LocalFilename), filepath.Join(removedDir, "old link.png")))
+	userFiles := []string{
+		filepath.Join(albumDir, "notes.txt"),
+		filepath.Join(removedDir, "keep.txt"),
+		filepath.Join(service.rootDir, AlbumsDir, "readme.txt"),
+	}
+	for _, userFile := range userFiles {
+		assert.NoError(t, os.WriteFile(userFile, []byte("mine"), 0644))
+	}
+	emptyDir := filepath.Join(service.rootDir, AlbumsDir, "Empty")
+	assert.NoError(t, os.MkdirAll(emptyDir, 0755))
+
+	assert.NoError(t, service.db.Albums.ReplaceItems(album.Uuid, []string{first.RemoteId}))
+	assert.NoError(t, service.Link())
+
+	assert.FileExists(t, filepath.Join(albumDir, first.LocalFilename))
+	assert.NoFileExists(t, filepath.Join(albumDir, generateNewFilename(2, second.LocalFilename)))
+	assert.NoFileExists(t, filepath.Join(removedDir, "old link.png"))
+	assert.NoDirExists(t, emptyDir)
+	for _, userFile := range userFiles {
+		assert.FileExists(t, userFile)
+	}
+	content, err := os.ReadFile(filepath.Join(service.rootDir, legacy.LocalPath, legacy.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", string(content))
+}
+
+func TestAlbumServiceSkipsMissingFiles(t *testing.T) {
+	service := createAlbumService(t, nil, Hardlinks)
+	album, first, second := saveLinkedAlbum(t, service)
+	assert.NoError(t, os.Remove(filepath.Join(service.rootDir, first.LocalPath, first.LocalFilename)))
+
+	assert.NoError(t, service.Link())
+
+	entries, err := os.ReadDir(filepath.Join(service.rootDir, album.LocalPath))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, second.LocalFilename, entries[0].Name())
+}
+
+func TestAlbumServiceMovesRenamedAndRemovesDeletedAlbums(t *testing.T) {
+	listed := []models.Album{{Id: "album-1", Title: "Holiday"}, {Id: "album-2", Title: "Family"}}
+	downloader := mockDownloader{
+		listAlbums: func(options models.PagingOptions) (models.Albums, error) {
+			return models.Albums{Albums: listed}, nil
+		},
+		search: func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+			return albumSearchResult("item-1"), nil
+		},
+	}
+	service := createAlbumService(t, &downloader, Symlinks)
+	assert.NoError(t, service.Sync(context.Background()))
+
+	listed = []models.Album{{Id: "album-1", Title: "Summer Holiday"}}
+	assert.NoError(t, service.Sync(context.Background()))
+
+	dbAlbums, err := service.db.Albums.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, dbAlbums, 1)
+	assert.Equal(t, "Summer Holiday", dbAlbums[0].Title)
+	assert.Equal(t, filepath.Join(AlbumsDir, "Summer Holiday"), dbAlbums[0].LocalPath)
+}
+
+func TestAlbumServiceDoesNotLinkWhenDisabled(t *testing.T) {
+	service := createAlbumService(t, nil, NoLinks)
+	album, _, _ := saveLinkedAlbum(t, service)
+
+	assert.NoError(t, service.Link())
+
+	_, err := os.Stat(filepath.Join(service.rootDir, album.LocalPath))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAlbumLocalPathIsSafeAndUnique(t *testing.T) {
+	usedPaths := map[string]bool{filepath.Join(AlbumsDir, "Trip"): true}
+
+	assert.Equal(t, filepath.Join(AlbumsDir, "a_b_c"), albumLocalPath("a/b:c", usedPaths))
+	assert.Equal(t, filepath.Join(AlbumsDir, "untitled"), albumLocalPath(" .. ", usedPaths))
+	assert.Equal(t, filepath.Join(AlbumsDir, "Trip_002"), albumLocalPath("Trip", usedPaths))
+}
+
+func TestParseLinkMode(t *testing.T) {
+	mode, err := ParseLinkMode("hardlink")
+	assert.NoError(t, err)
+	assert.Equal(t, Hardlinks, mode)
+
+	_, err = ParseLinkMode("copy")
+	assert.EqualError(t, err, "unknown album link mode 'copy'")
+}
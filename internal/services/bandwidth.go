@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// bandwidthWindow limits the bandwidth between two times of day, wrapping around midnight when it
+// ends before it starts
+type bandwidthWindow struct {
+	start time.Duration
+	end   time.Duration
+	limit int64
+}
+
+func (w bandwidthWindow) contains(timeOfDay time.Duration) bool {
+	if w.start <= w.end {
+		return timeOfDay >= w.start && timeOfDay < w.end
+	}
+	return timeOfDay >= w.start || timeOfDay < w.end
+}
+
+// BandwidthSchedule is the number of bytes per second downloads may use at each time of day. It is
+// written as a default limit and windows with their own limit, e.g. '2MB,00:00-07:00=0' allows
+// 2 MB/s except at night. A limit of 0 doesn't limit downloads
+type BandwidthSchedule struct {
+	text         string
+	defaultLimit int64
+	windows      []bandwidthWindow
+}
+
+func ParseBandwidthSchedule(text string) (schedule BandwidthSchedule, err error) {
+	schedule.text = text
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	defaultSeen := false
+	for _, entry := range strings.Split(text, ",") {
+		window, limit, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			if defaultSeen {
+				return BandwidthSchedule{}, fmt.Errorf("bandwidth schedule '%s' has more than one default limit", text)
+			}
+			defaultSeen = true
+			schedule.defaultLimit, err = utils.ParseBytes(window)
+			if err != nil {
+				return BandwidthSchedule{}, err
+			}
+			continue
+		}
+
+		var parsed bandwidthWindow
+		parsed, err = parseBandwidthWindow(window)
+		if err != nil {
+			return BandwidthSchedule{}, err
+		}
+		parsed.limit, err = utils.ParseBytes(limit)
+		if err != nil {
+			return BandwidthSchedule{}, err
+		}
+		schedule.windows = append(schedule.windows, parsed)
+	}
+	return
+}
+
+func parseBandwidthWindow(window string) (bandwidthWindow, error) {
+	start, end, found := strings.Cut(window, "-")
+	if !found {
+		return bandwidthWindow{}, fmt.Errorf("invalid bandwidth window '%s', expected hh:mm-hh:mm", window)
+	}
+
+	startTime, startErr := time.Parse("15:04", strings.TrimSpace(start))
+	endTime, endErr := time.Parse("15:04", strings.TrimSpace(end))
+	if startErr != nil || endErr != nil {
+		return bandwidthWindow{}, fmt.Errorf("invalid bandwidth window '%s', expected hh:mm-hh:mm", window)
+	}
+	return bandwidthWindow{start: timeOfDay(startTime), end: timeOfDay(endTime)}, nil
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// Limit returns the bytes per second allowed at now, the first window containing now wins
+func (s BandwidthSchedule) Limit(now time.Time) int64 {
+	current := timeOfDay(now)
+	for _, window := range s.windows {
+		if window.contains(current) {
+			return window.limit
+		}
+	}
+	return s.defaultLimit
+}
+
+func (s BandwidthSchedule) String() string {
+	return s.text
+}
+
+func (s BandwidthSchedule) MarshalText() ([]byte, error) {
+	return []byte(s.text), nil
+}
+
+func (s *BandwidthSchedule) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseBandwidthSchedule(string(text))
+	return
+}
+
+// BandwidthLimiter shares the bandwidth allowed by the schedule between all downloads, and keeps
+// track of their throughput
+type BandwidthLimiter struct {
+	schedule BandwidthSchedule
+	logger   utils.Logger
+	now      func() time.Time
+	mutex    sync.Mutex
+	// tokens are the bytes that can be read straight away, at most a second's worth
+	tokens float64
+	last   time.Time
+	// transferred counts the bytes read since the throughput was last measured
+	transferred int64
+	measured    time.Time
+}
+
+func NewBandwidthLimiter(schedule BandwidthSchedule, logger utils.Logger) *BandwidthLimiter {
+	now := time.Now()
+	return &BandwidthLimiter{schedule: schedule, logger: logger, now: time.Now, last: now, measured: now}
+}
+
+// WaitN blocks until reading bytes fits in the bandwidth, or ctx is done
+func (b *BandwidthLimiter) WaitN(ctx context.Context, bytes int) error {
+	wait := b.reserve(bytes)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes bytes from the bandwidth and returns how long until they are available
+func (b *BandwidthLimiter) reserve(bytes int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.transferred += int64(bytes)
+	limit := float64(b.schedule.Limit(now))
+	if limit <= 0 {
+		b.tokens = 0
+		b.last = now
+		return 0
+	}
+
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.last).Seconds()*limit)
+	b.last = now
+	b.tokens -= float64(bytes)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / limit * float64(time.Second))
+}
+
+// Throughput returns the bytes per second read since it was last called, and the current limit
+func (b *BandwidthLimiter) Throughput() (bytesPerSecond int64, limit int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	elapsed := now.Sub(b.measured).Seconds()
+	if elapsed > 0 {
+		bytesPerSecond = int64(float64(b.transferred) / elapsed)
+	}
+	b.transferred = 0
+	b.measured = now
+	return bytesPerSecond, b.schedule.Limit(now)
+}
+
+// ReportThroughput logs the throughput of downloads every interval until ctx is done. Nothing is
+// logged while nothing is downloaded
+func (b *BandwidthLimiter) ReportThroughput(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			bytesPerSecond, limit := b.Throughput()
+			if bytesPerSecond == 0 {
+				continue
+			}
+			if limit > 0 {
+				b.logger.Info.Printf("downloading at %s/s, limited to %s/s", utils.FormatBytes(bytesPerSecond), utils.FormatBytes(limit))
+			} else {
+				b.logger.Info.Printf("downloading at %s/s", utils.FormatBytes(bytesPerSecond))
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2021, 12, 3, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	testCases := []struct {
+		text     string
+		now      time.Time
+		expected int64
+	}{
+		{text: "", now: at(12, 0), expected: 0},
+		{text: "2MB", now: at(12, 0), expected: 2000000},
+		{text: "2MB,00:00-07:00=0", now: at(3, 0), expected: 0},
+		{text: "2MB,00:00-07:00=0", now: at(7, 0), expected: 2000000},
+		{text: "23:00-07:00=0, 09:00-17:00=1MiB, 512KiB", now: at(23, 30), expected: 0},
+		{text: "23:00-07:00=0, 09:00-17:00=1MiB, 512KiB", now: at(6, 59), expected: 0},
+		{text: "23:00-07:00=0, 09:00-17:00=1MiB, 512KiB", now: at(12, 0), expected: 1024 * 1024},
+		{text: "23:00-07:00=0, 09:00-17:00=1MiB, 512KiB", now: at(18, 0), expected: 512 * 1024},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.text+" at "+tc.now.Format("15:04"), func(t *testing.T) {
+			schedule, err := ParseBandwidthSchedule(tc.text)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, schedule.Limit(tc.now))
+			assert.Equal(t, tc.text, schedule.String())
+		})
+	}
+}
+
+func TestParseBandwidthSchedule_RejectsInvalidSchedules(t *testing.T) {
+	for _, text := range []string{"fast", "1MB,2MB", "07:00=1MB", "7-9=1MB", "07:00-25:00=1MB", "07:00-09:00=fast"} {
+		_, err := ParseBandwidthSchedule(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestBandwidthLimiter_SharesLimitBetweenReads(t *testing.T) {
+	now := at(12, 0)
+	schedule, err := ParseBandwidthSchedule("1000")
+	assert.NoError(t, err)
+	limiter := NewBandwidthLimiter(schedule, utils.NewLogger(utils.Silent))
+	limiter.now = func() time.Time { return now }
+	limiter.last = now.Add(-time.Hour)
+
+	// a second's worth is read straight away, after which reads wait for their share
+	assert.Equal(t, time.Duration(0), limiter.reserve(1000))
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(500))
+	assert.Equal(t, time.Second, limiter.reserve(500))
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, time.Duration(0), limiter.reserve(1000))
+}
+
+func TestBandwidthLimiter_UnlimitedWindowDoesNotWait(t *testing.T) {
+	now := at(3, 0)
+	schedule, err := ParseBandwidthSchedule("1000,00:00-07:00=0")
+	assert.NoError(t, err)
+	limiter := NewBandwidthLimiter(schedule, utils.NewLogger(utils.Silent))
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), limiter.reserve(1000000))
+}
+
+func TestBandwidthLimiter_Throughput(t *testing.T) {
+	now := at(12, 0)
+	schedule, err := ParseBandwidthSchedule("1MB")
+	assert.NoError(t, err)
+	limiter := NewBandwidthLimiter(schedule, utils.NewLogger(utils.Silent))
+	limiter.now = func() time.Time { return now }
+	limiter.measured = now
+
+	limiter.reserve(3000)
+	limiter.reserve(1000)
+	now = now.Add(2 * time.Second)
+
+	bytesPerSecond, limit := limiter.Throughput()
+	assert.Equal(t, int64(2000), bytesPerSecond)
+	assert.Equal(t, int64(1000000), limit)
+
+	now = now.Add(time.Second)
+	bytesPerSecond, _ = limiter.Throughput()
+	assert.Zero(t, bytesPerSecond)
+}
@@ -1,9 +1,13 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
@@ -16,41 +20,69 @@ type DownloadJob struct {
 	api          googlephotos.Downloader
 	db           database.PhotoDatabase
 	retryFactory RetryFactory
+	progress     *Progress
+	metrics      *Metrics
 	logger       utils.Logger
 	rootDir      string
 }
 
-func (j *DownloadJob) Process() {
-	err := j.process()
+func (j *DownloadJob) Process(ctx context.Context) {
+	started := time.Now()
+	err := j.process(ctx)
+
+	// an interrupted download isn't a failure of the media item, it is tried again by the next run
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		j.logger.Info.Print("download cancelled")
+		return
+	}
 
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) saving last error", j.Id)
+		j.progress.Failed()
+		j.metrics.Failed(time.Since(started))
+		j.logger.With(utils.Err(err)).Error.Print("saving last error")
 		err = j.db.MediaItems.MarkAsErrored(j.Id, err)
 		if err != nil {
-			j.logger.Error.Printf("(id: %s) saving last error failed: %s", j.Id, err.Error())
+			j.logger.Error.Printf("saving last error failed: %s", err.Error())
 		}
 	}
 }
 
-func (j *DownloadJob) process() (err error) {
-	j.logger.Trace.Printf("(id: %s) retrieving db item", j.Id)
+func (j *DownloadJob) process(ctx context.Context) (err error) {
+	started := time.Now()
+	j.logger.Trace.Print("retrieving db item")
 	item, err := j.db.MediaItems.Get(j.Id)
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) database retrieval failed: %s", j.Id, err.Error())
+		j.logger.Error.Printf("database retrieval failed: %s", err.Error())
 		return
 	}
 
 	if item.Downloaded {
+		j.progress.Duplicate()
 		return
 	}
 
+	stagingDir := filepath.Join(j.rootDir, StagingDir)
+	j.logger.Trace.Printf("ensuring staging directory '%s'", stagingDir)
+	err = os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		j.logger.Error.Printf("ensuring staging directory '%s' failed: %s", stagingDir, err.Error())
+		return
+	}
+
+	// partial files are named after the media item, so a later run can pick up where this one stopped
+	partial := models.PartialDownload{Path: filepath.Join(stagingDir, item.Uuid+partialFileSuffix), Validator: item.PartialValidator}
 	retry := j.retryFactory.Create()
-	var tmpFilepath string
+	var download models.DownloadedFile
 	for {
-		tmpFilepath, err = j.downloadItem(item)
+		download, err = j.downloadItem(ctx, partial, item)
 		if err != nil {
-			if retry.ShouldRetry(err) {
-				retry.Wait()
+			partial.Validator = download.Validator
+			j.recordPartial(item, download)
+			if ctx.Err() == nil && retry.ShouldRetry(err) {
+				err = retry.Wait(ctx)
+				if err != nil {
+					return
+				}
 				continue
 			}
 			return
@@ -58,121 +90,146 @@ func (j *DownloadJob) process() (err error) {
 		break
 	}
 
+	tmpFilepath := download.Path
 	relativePath := filepath.Join(item.LocalPath, item.LocalFilename)
 	itemFilepath := filepath.Join(j.rootDir, relativePath)
 
-	j.logger.Trace.Printf("(id: %s) getting stat of root dir '%s'", j.Id, j.rootDir)
+	j.logger.Trace.Printf("getting stat of root dir '%s'", j.rootDir)
 	stat, err := os.Stat(j.rootDir)
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) getting stat of root dir '%s' failed: %s", j.Id, j.rootDir, err.Error())
+		j.logger.Error.Printf("getting stat of root dir '%s' failed: %s", j.rootDir, err.Error())
 		return
 	}
 
 	dir := filepath.Dir(itemFilepath)
-	j.logger.Trace.Printf("(id: %s) ensuring item directory path for '%s'", j.Id, dir)
+	j.logger.Trace.Printf("ensuring item directory path for '%s'", dir)
 	err = os.MkdirAll(dir, stat.Mode())
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) ensuring item directory path for '%s' failed: %s", j.Id, dir, err.Error())
+		j.logger.Error.Printf("ensuring item directory path for '%s' failed: %s", dir, err.Error())
 		return
 	}
 
-	j.logger.Debug.Printf("(id: %s) moving file from '%s' to '%s'", j.Id, tmpFilepath, relativePath)
+	j.logger.Debug.Printf("moving file from '%s' to '%s'", tmpFilepath, relativePath)
 	// will overwrite an existing file
 	err = os.Rename(tmpFilepath, itemFilepath)
 	if err != nil {
-		j.logger.Trace.Printf("(id: %s) moving failed, copying file from '%s' to '%s'", j.Id, tmpFilepath, relativePath)
+		j.logger.Trace.Printf("moving failed, copying file from '%s' to '%s'", tmpFilepath, relativePath)
 		err = j.copyFile(tmpFilepath, itemFilepath)
 		if err != nil {
 			return
 		}
 
-		j.logger.Trace.Printf("(id: %s) deleting temporary file '%s'", j.Id, tmpFilepath)
+		j.logger.Trace.Printf("deleting temporary file '%s'", tmpFilepath)
 		err = os.Remove(tmpFilepath)
 		if err != nil {
-			j.logger.Debug.Printf("(id: %s) deleting temporary file '%s' failed: %s", j.Id, tmpFilepath, err.Error())
+			j.logger.Debug.Printf("deleting temporary file '%s' failed: %s", tmpFilepath, err.Error())
 			return
 		}
 	}
 
-	j.logger.Trace.Printf("(id: %s) getting file size for '%s'", j.Id, relativePath)
+	j.logger.Trace.Printf("getting file size for '%s'", relativePath)
 	fileStat, err := os.Stat(itemFilepath)
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) getting file size for '%s' failed: %s", j.Id, relativePath, err.Error())
+		j.logger.Error.Printf("getting file size for '%s' failed: %s", relativePath, err.Error())
 		return
 	}
 
-	j.logger.Debug.Printf("(id: %s) marking file '%s' as synced", j.Id, relativePath)
-	err = j.db.MediaItems.MarkAsSynced(j.Id, fileStat.Size())
+	// a copy across filesystems can be cut short
+	if fileStat.Size() != download.Size {
+		err = fmt.Errorf("file '%s' has %d bytes, expected %d", relativePath, fileStat.Size(), download.Size)
+		j.logger.Error.Print(err.Error())
+		return
+	}
+
+	j.logger.Debug.Printf("marking file '%s' as synced", relativePath)
+	err = j.db.MediaItems.MarkAsSynced(j.Id, fileStat.Size(), download.Sha256)
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) marking file '%s' as synced failed: %s", j.Id, relativePath, err.Error())
+		j.logger.Error.Printf("marking file '%s' as synced failed: %s", relativePath, err.Error())
 		return
 	}
 
-	j.logger.Info.Printf("(id: %s) downloaded '%s'", j.Id, relativePath)
+	took := time.Since(started)
+	j.progress.Downloaded(fileStat.Size(), took)
+	j.metrics.Downloaded(fileStat.Size(), took)
+	j.logger.With(utils.RemoteId(item.RemoteId), utils.Path(relativePath), utils.Duration(took)).Info.Printf("downloaded '%s'", relativePath)
 	return
 }
 
-func (j *DownloadJob) downloadItem(item database.MediaItem) (string, error) {
-	j.logger.Debug.Printf("(id: %s) downloading content of remote id '%s'", j.Id, item.RemoteId)
-	tmpFilepath, downloadError := j.api.Download(j.rootDir, item.BaseUrl, item.IsPhoto())
+// recordPartial stores how much of a failed download can be resumed
+func (j *DownloadJob) recordPartial(item database.MediaItem, download models.DownloadedFile) {
+	if download.Path == "" {
+		return
+	}
+
+	j.logger.Debug.Printf("recording %d bytes of partial download", download.Size)
+	err := j.db.MediaItems.UpdatePartial(item.Uuid, download.Size, download.Validator)
+	if err != nil {
+		j.logger.Error.Printf("recording partial download failed: %s", err.Error())
+	}
+}
+
+// downloadItem returns the partial file alongside an error, so the download can be resumed
+func (j *DownloadJob) downloadItem(ctx context.Context, partial models.PartialDownload, item database.MediaItem) (models.DownloadedFile, error) {
+	j.logger.Debug.Printf("downloading content of remote id '%s'", item.RemoteId)
+	download, downloadError := j.api.Download(ctx, partial, item.BaseUrl, item.IsPhoto())
 	if downloadError == nil {
-		return tmpFilepath, nil
+		return download, nil
 	}
 
-	j.logger.Error.Printf("(id: %s) downloading content of remote id '%s' failed: %s", j.Id, item.RemoteId, downloadError.Error())
+	j.logger.With(utils.RemoteId(item.RemoteId), utils.Err(downloadError)).Error.Printf("downloading content of remote id '%s' failed: %s", item.RemoteId, downloadError.Error())
 
 	// check for 403, which likely means the BaseUrl has changed
 	apiError, ok := downloadError.(models.ApiError)
 	if !ok || apiError.StatusCode != 403 {
-		return "", downloadError
+		return download, downloadError
 	}
 
-	j.logger.Debug.Printf("(id: %s) getting new base url", j.Id)
-	apiItem, err := j.api.Get(item.RemoteId)
+	j.logger.Debug.Print("getting new base url")
+	apiItem, err := j.api.Get(ctx, item.RemoteId)
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) getting new base url failed: %s", j.Id, err.Error())
-		return "", err
+		j.logger.Error.Printf("getting new base url failed: %s", err.Error())
+		return download, err
 	}
 
 	if item.BaseUrl == apiItem.BaseUrl {
-		j.logger.Debug.Printf("(id: %s) fresh base url is the same as old one, nothing to do here", j.Id)
-		return "", downloadError
+		j.logger.Debug.Print("fresh base url is the same as old one, nothing to do here")
+		return download, downloadError
 	}
 
-	j.logger.Debug.Printf("(id: %s) updating base url in database", j.Id)
+	j.logger.Debug.Print("updating base url in database")
 	err = j.db.MediaItems.UpdateBaseUrl(item.RemoteId, apiItem.BaseUrl)
 	if err != nil {
-		j.logger.Debug.Printf("(id: %s) updating base url in database failed: %s", j.Id, err.Error())
-		return "", err
+		j.logger.Debug.Printf("updating base url in database failed: %s", err.Error())
+		return download, err
 	}
 
-	j.logger.Debug.Printf("(id: %s) attempting content download of remote id '%s' with new base url", j.Id, item.RemoteId)
-	tmpFilepath, err = j.api.Download(j.rootDir, apiItem.BaseUrl, item.IsPhoto())
+	j.logger.Debug.Printf("attempting content download of remote id '%s' with new base url", item.RemoteId)
+	download, err = j.api.Download(ctx, partial, apiItem.BaseUrl, item.IsPhoto())
 	if err != nil {
-		j.logger.Error.Printf("(id: %s) downloading content of remote id '%s' failed: %s", j.Id, item.RemoteId, err.Error())
-		return "", err
+		j.logger.Error.Printf("downloading content of remote id '%s' failed: %s", item.RemoteId, err.Error())
+		return download, err
 	}
-	return tmpFilepath, nil
+	return download, nil
 }
 
 func (j *DownloadJob) copyFile(src, dest string) (err error) {
 	sourceFile, err := os.Open(src)
 	if err != nil {
-		j.logger.Debug.Printf("(id: %s) opening source file '%s' failed: %s", j.Id, src, err.Error())
+		j.logger.Debug.Printf("opening source file '%s' failed: %s", src, err.Error())
 		return
 	}
 	defer utils.CheckClose(sourceFile, &err)
 
 	newFile, err := os.Create(dest)
 	if err != nil {
-		j.logger.Debug.Printf("(id: %s) creating destination file '%s' failed: %s", j.Id, dest, err.Error())
+		j.logger.Debug.Printf("creating destination file '%s' failed: %s", dest, err.Error())
 		return
 	}
 	defer utils.CheckClose(newFile, &err)
 
 	_, err = io.Copy(newFile, sourceFile)
 	if err != nil {
-		j.logger.Debug.Printf("(id: %s) copying from '%s' to '%s' failed: %s", j.Id, src, dest, err.Error())
+		j.logger.Debug.Printf("copying from '%s' to '%s' failed: %s", src, dest, err.Error())
 		return
 	}
 	return
@@ -1,6 +1,9 @@
 package services
 
 import (
+	"context"
+	"time"
+
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
 	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
@@ -9,7 +12,7 @@ import (
 
 type RetryTracker interface {
 	ShouldRetry(err error) bool
-	Wait()
+	Wait(ctx context.Context) error
 }
 
 type RetryFactory interface {
@@ -26,14 +29,19 @@ type DownloadService struct {
 	logger       utils.Logger
 	queue        *workerpool.JobQueue
 	retryFactory RetryFactory
+	progress     *Progress
+	metrics      *Metrics
 	rootDir      string
 	maxWorkers   int
+	gracePeriod  time.Duration
 }
 
 type Option func(svc *DownloadService)
 
-func NewDownloadService(api googlephotos.Downloader, db database.PhotoDatabase, rootDir string, opts ...Option) DownloadService {
-	service := DownloadService{api: api, db: db, rootDir: rootDir, logger: utils.NewLogger(utils.Silent)}
+// NewDownloadService starts the download workers. Once ctx is done no more downloads are started,
+// downloads in progress are aborted if they don't finish within the grace period
+func NewDownloadService(ctx context.Context, api googlephotos.Downloader, db database.PhotoDatabase, rootDir string, opts ...Option) DownloadService {
+	service := DownloadService{api: api, db: db, rootDir: rootDir, logger: utils.NewLogger(utils.Silent), gracePeriod: 30 * time.Second}
 	for _, opt := range opts {
 		opt(&service)
 	}
@@ -42,19 +50,29 @@ func NewDownloadService(api googlephotos.Downloader, db database.PhotoDatabase,
 		service.retryFactory = NoRetryFactory{}
 	}
 
-	service.queue = workerpool.NewJobQueue(service.maxWorkers)
+	service.queue = workerpool.NewJobQueue(ctx, service.maxWorkers, service.gracePeriod)
 	service.queue.Start()
+	service.metrics.trackQueue(service.queue)
 
 	return service
 }
 
 func (s *DownloadService) QueueDownload(ids ...string) {
-	for _, id := range ids {
-		job := DownloadJob{Id: id, api: s.api, db: s.db, logger: s.logger, rootDir: s.rootDir, retryFactory: s.retryFactory}
-		s.queue.Submit(&job)
+	for i, id := range ids {
+		job := DownloadJob{Id: id, api: s.api, db: s.db, logger: s.logger.With(utils.JobId(id)), rootDir: s.rootDir, retryFactory: s.retryFactory, progress: s.progress, metrics: s.metrics}
+		err := s.queue.Submit(&job)
+		if err != nil {
+			s.logger.Debug.Printf("not queueing %d downloads: %s", len(ids)-i, err)
+			return
+		}
 	}
 }
 
+// Wait blocks until the downloads queued so far have finished, the workers are kept for more
+func (s *DownloadService) Wait() {
+	s.queue.Drain()
+}
+
 func (s *DownloadService) Finish() {
 	s.queue.Stop()
 }
@@ -65,12 +83,33 @@ func WithRetryFactory(factory RetryFactory) Option {
 	}
 }
 
+// WithProgress counts finished and failed downloads in progress
+func WithProgress(progress *Progress) Option {
+	return func(service *DownloadService) {
+		service.progress = progress
+	}
+}
+
+// WithMetrics records downloads and the depth of the queue in metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(service *DownloadService) {
+		service.metrics = metrics
+	}
+}
+
 func WithMaxWorkers(maxWorkers int) Option {
 	return func(service *DownloadService) {
 		service.maxWorkers = maxWorkers
 	}
 }
 
+// WithGracePeriod sets how long downloads in progress have to finish once cancelled
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(service *DownloadService) {
+		service.gracePeriod = gracePeriod
+	}
+}
+
 func WithLogger(logger utils.Logger) Option {
 	return func(service *DownloadService) {
 		service.logger = logger
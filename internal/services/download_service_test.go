@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -18,7 +21,7 @@ import (
 func createDownloadService(t *testing.T, downloader googlephotos.Downloader, opts ...Option) DownloadService {
 	db := database.CreateTestDatabase(t)
 	allOptions := append([]Option{WithMaxWorkers(1)}, opts...)
-	return NewDownloadService(downloader, db, os.TempDir(), allOptions...)
+	return NewDownloadService(context.Background(), downloader, db, t.TempDir(), allOptions...)
 }
 
 func createMediaItemToDownload(t *testing.T) database.MediaItem {
@@ -30,7 +33,7 @@ func createMediaItemToDownload(t *testing.T) database.MediaItem {
 	return item
 }
 
-func writeTempFile(t *testing.T, content string) string {
+func writeTempFile(t *testing.T, content string) models.DownloadedFile {
 	file, err := ioutil.TempFile("", "downloadedfile.*.tmp")
 	assert.NoError(t, err)
 	defer utils.CheckClose(file, &err)
@@ -38,7 +41,8 @@ func writeTempFile(t *testing.T, content string) string {
 	_, err = file.WriteString(content)
 	assert.NoError(t, err)
 
-	return file.Name()
+	checksum := sha256.Sum256([]byte(content))
+	return models.DownloadedFile{Path: file.Name(), Size: int64(len(content)), Sha256: hex.EncodeToString(checksum[:])}
 }
 
 func assertItemDownloaded(t *testing.T, service DownloadService, id string, finishedTime int64) {
@@ -47,14 +51,17 @@ func assertItemDownloaded(t *testing.T, service DownloadService, id string, fini
 
 	assert.True(t, dbItem.Downloaded)
 	assert.Equal(t, 4, dbItem.FileSize)
+	assert.Equal(t, "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589", dbItem.Sha256)
 	assert.InDelta(t, finishedTime, dbItem.SyncedAt.UnixMilli(), 10000)
 }
 
 func TestDownloadService_DownloadsASingleFile(t *testing.T) {
 	item := createMediaItemToDownload(t)
 
+	var downloadPath string
 	downloader := mockDownloader{
-		download: func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+			downloadPath = partial.Path
 			assert.Equal(t, item.BaseUrl, baseUrl)
 			assert.Equal(t, item.IsPhoto(), isPhoto)
 
@@ -62,7 +69,8 @@ func TestDownloadService_DownloadsASingleFile(t *testing.T) {
 		},
 	}
 
-	service := createDownloadService(t, &downloader)
+	progress := NewProgress()
+	service := createDownloadService(t, &downloader, WithProgress(progress))
 	err := service.db.MediaItems.Save(&item)
 	assert.NoError(t, err)
 
@@ -71,7 +79,12 @@ func TestDownloadService_DownloadsASingleFile(t *testing.T) {
 	finishedTime := time.Now().UnixMilli()
 
 	assert.Equal(t, 1, downloader.downloadCallCount)
+	assert.Equal(t, filepath.Join(service.rootDir, StagingDir, item.Uuid+".part"), downloadPath)
 	assertItemDownloaded(t, service, item.Uuid, finishedTime)
+
+	counts := progress.Counts()
+	assert.Equal(t, 1, counts.Downloaded)
+	assert.Equal(t, int64(4), counts.Bytes)
 }
 
 func TestDownloadService_DownloadsMultipleFiles(t *testing.T) {
@@ -79,7 +92,7 @@ func TestDownloadService_DownloadsMultipleFiles(t *testing.T) {
 	itemTwo := createMediaItemToDownload(t)
 
 	downloader := mockDownloader{
-		download: func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
 			if itemOne.BaseUrl == baseUrl {
 				assert.Equal(t, itemOne.BaseUrl, baseUrl)
 				assert.Equal(t, itemOne.IsPhoto(), isPhoto)
@@ -112,11 +125,11 @@ func TestDownloadService_UpdatesBaseUrlIfIncorrect(t *testing.T) {
 	item := createMediaItemToDownload(t)
 	newBaseUrl := "https://lh3.googleusercontent.com/lr/AFBm1_bKC3xpsBsbtwcD3wKVcEMdwlf0Sk61"
 	downloader := mockDownloader{}
-	downloader.download = func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
+	downloader.download = func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
 		if downloader.downloadCallCount == 1 {
 			assert.Equal(t, item.BaseUrl, baseUrl)
 			assert.Equal(t, item.IsPhoto(), isPhoto)
-			return "", models.ApiError{StatusCode: 403}
+			return models.DownloadedFile{}, models.ApiError{StatusCode: 403}
 		}
 		if downloader.downloadCallCount == 2 {
 			assert.Equal(t, newBaseUrl, baseUrl)
@@ -150,12 +163,35 @@ func TestDownloadService_UpdatesBaseUrlIfIncorrect(t *testing.T) {
 	assertItemDownloaded(t, service, item.Uuid, finishedTime)
 }
 
+func TestDownloadService_WaitKeepsWorkersForMoreDownloads(t *testing.T) {
+	first := createMediaItemToDownload(t)
+	second := createMediaItemToDownload(t)
+	downloader := mockDownloader{
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (models.DownloadedFile, error) {
+			return writeTempFile(t, "abcd"), nil
+		},
+	}
+
+	service := createDownloadService(t, &downloader)
+	assert.NoError(t, service.db.MediaItems.Save(&first))
+	assert.NoError(t, service.db.MediaItems.Save(&second))
+
+	service.QueueDownload(first.Uuid)
+	service.Wait()
+	assertItemDownloaded(t, service, first.Uuid, time.Now().UnixMilli())
+
+	service.QueueDownload(second.Uuid)
+	service.Wait()
+	assertItemDownloaded(t, service, second.Uuid, time.Now().UnixMilli())
+	service.Finish()
+}
+
 func TestDownloadService_HandlesNetworkFailureAndRetries(t *testing.T) {
 	item := createMediaItemToDownload(t)
 	downloader := mockDownloader{}
-	downloader.download = func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
+	downloader.download = func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
 		if downloader.downloadCallCount < 3 {
-			return "", models.ApiError{StatusCode: 500}
+			return models.DownloadedFile{}, models.ApiError{StatusCode: 500}
 		}
 		assert.Equal(t, item.BaseUrl, baseUrl)
 		assert.Equal(t, item.IsPhoto(), isPhoto)
@@ -163,7 +199,7 @@ func TestDownloadService_HandlesNetworkFailureAndRetries(t *testing.T) {
 		return writeTempFile(t, "abcd"), nil
 	}
 
-	factory := NewExponentialRetryFactory()
+	factory := NewExponentialRetryFactory(DefaultErrorClassifier{})
 	factory.baseTimeInSeconds = 0.1
 	service := createDownloadService(t, &downloader, WithRetryFactory(factory))
 	err := service.db.MediaItems.Save(&item)
@@ -181,12 +217,13 @@ func TestDownloadService_LogErrorInDatabase(t *testing.T) {
 	item := createMediaItemToDownload(t)
 
 	downloader := mockDownloader{
-		download: func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
-			return "", errors.New("invalid url")
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+			return models.DownloadedFile{}, errors.New("invalid url")
 		},
 	}
 
-	service := createDownloadService(t, &downloader)
+	progress := NewProgress()
+	service := createDownloadService(t, &downloader, WithProgress(progress))
 	err := service.db.MediaItems.Save(&item)
 	assert.NoError(t, err)
 
@@ -201,6 +238,31 @@ func TestDownloadService_LogErrorInDatabase(t *testing.T) {
 	assert.Equal(t, 0, dbItem.FileSize)
 	assert.Empty(t, dbItem.SyncedAt)
 	assert.Equal(t, "invalid url", dbItem.LastError)
+	assert.Equal(t, 1, progress.Counts().Failed)
+}
+
+func TestDownloadService_DetectsIncompleteFile(t *testing.T) {
+	item := createMediaItemToDownload(t)
+
+	downloader := mockDownloader{
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+			download = writeTempFile(t, "abcd")
+			download.Size = 10
+			return download, nil
+		},
+	}
+
+	service := createDownloadService(t, &downloader)
+	err := service.db.MediaItems.Save(&item)
+	assert.NoError(t, err)
+
+	service.QueueDownload(item.Uuid)
+	service.Finish()
+
+	dbItem, err := service.db.MediaItems.Get(item.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+	assert.Contains(t, dbItem.LastError, "has 4 bytes, expected 10")
 }
 
 func TestDownloadService_CheckItemHasNotBeenDownloaded(t *testing.T) {
@@ -220,3 +282,84 @@ func TestDownloadService_CheckItemHasNotBeenDownloaded(t *testing.T) {
 	assert.NotEmpty(t, dbItem.SyncedAt)
 	assert.Empty(t, dbItem.LastError)
 }
+
+func TestDownloadService_AbortsDownloadsAfterGracePeriodWhenCancelled(t *testing.T) {
+	item := createMediaItemToDownload(t)
+	started := make(chan bool)
+
+	downloader := mockDownloader{
+		download: func(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+			started <- true
+			<-ctx.Done()
+			return models.DownloadedFile{}, ctx.Err()
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db := database.CreateTestDatabase(t)
+	service := NewDownloadService(ctx, &downloader, db, t.TempDir(), WithMaxWorkers(1), WithGracePeriod(10*time.Millisecond))
+	assert.NoError(t, db.MediaItems.Save(&item))
+
+	service.QueueDownload(item.Uuid)
+	<-started
+	cancel()
+	// no more downloads are started once cancelled
+	service.QueueDownload(item.Uuid)
+	service.Finish()
+
+	assert.Equal(t, 1, downloader.downloadCallCount)
+	dbItem, err := db.MediaItems.Get(item.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+	assert.Empty(t, dbItem.LastError)
+}
+
+func TestDownloadService_ResumesPartialDownloads(t *testing.T) {
+	item := createMediaItemToDownload(t)
+	item.PartialValidator = `"stored"`
+	var validators []string
+	downloader := mockDownloader{}
+	downloader.download = func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+		validators = append(validators, partial.Validator)
+		if downloader.downloadCallCount < 2 {
+			return models.DownloadedFile{Path: partial.Path, Size: 2, Validator: `"etag"`}, models.ApiError{StatusCode: 500}
+		}
+		return writeTempFile(t, "abcd"), nil
+	}
+
+	factory := NewExponentialRetryFactory(DefaultErrorClassifier{})
+	factory.baseTimeInSeconds = 0.01
+	service := createDownloadService(t, &downloader, WithRetryFactory(factory))
+	assert.NoError(t, service.db.MediaItems.Save(&item))
+
+	service.QueueDownload(item.Uuid)
+	service.Finish()
+
+	assert.Equal(t, []string{`"stored"`, `"etag"`}, validators)
+	dbItem, err := service.db.MediaItems.Get(item.Uuid)
+	assert.NoError(t, err)
+	assert.True(t, dbItem.Downloaded)
+	assert.Zero(t, dbItem.PartialBytes)
+	assert.Empty(t, dbItem.PartialValidator)
+}
+
+func TestDownloadService_RecordsPartialDownloads(t *testing.T) {
+	item := createMediaItemToDownload(t)
+	downloader := mockDownloader{
+		download: func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+			return models.DownloadedFile{Path: partial.Path, Size: 2048, Validator: `"etag"`}, errors.New("connection reset by peer")
+		},
+	}
+
+	service := createDownloadService(t, &downloader)
+	assert.NoError(t, service.db.MediaItems.Save(&item))
+
+	service.QueueDownload(item.Uuid)
+	service.Finish()
+
+	dbItem, err := service.db.MediaItems.Get(item.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+	assert.Equal(t, int64(2048), dbItem.PartialBytes)
+	assert.Equal(t, `"etag"`, dbItem.PartialValidator)
+}
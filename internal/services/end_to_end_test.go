@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/fake"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// endToEnd syncs a library served by the fake google photos server through the real api client
+type endToEnd struct {
+	server   *fake.Server
+	db       database.PhotoDatabase
+	rootDir  string
+	api      googlephotos.Downloader
+	download *DownloadService
+	progress *Progress
+	logger   utils.Logger
+}
+
+func fastRetryFactory() ExponentialRetryFactory {
+	factory := NewExponentialRetryFactory(DefaultErrorClassifier{})
+	factory.baseTimeInSeconds = 0.001
+	return factory
+}
+
+func createEndToEnd(t *testing.T, items ...fake.MediaItem) *endToEnd {
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+	server.AddMediaItems(items...)
+
+	logger := utils.NewLogger(utils.Silent)
+	photosApi := googlephotos.NewPhotosApi(googlephotos.Options{BaseUrl: server.BaseUrl(), Client: server.Client(), Logger: logger})
+	api := NewRetryingDownloader(&photosApi, fastRetryFactory(), NewBackoff(), logger)
+	db := database.CreateTestDatabase(t)
+	rootDir := t.TempDir()
+	progress := NewProgress()
+
+	download := NewDownloadService(context.Background(), api, db, rootDir,
+		WithMaxWorkers(2),
+		WithRetryFactory(fastRetryFactory()),
+		WithProgress(progress),
+		WithLogger(logger),
+	)
+	t.Cleanup(download.Finish)
+
+	return &endToEnd{server: server, db: db, rootDir: rootDir, api: api, download: &download, progress: progress, logger: logger}
+}
+
+// sync runs the steps of the sync command
+func (e *endToEnd) sync(t *testing.T) {
+	ctx := context.Background()
+	syncService := NewSyncService(e.api, e.db, e.download, layout.Default, e.progress, e.logger)
+	syncService.pagingSize = 2
+	albumService := NewAlbumService(e.api, e.db, e.download, e.logger, AlbumOptions{RootDir: e.rootDir, LinkMode: Symlinks, Layout: layout.Default})
+	undownloadedService := NewUndownloadedService(e.api, e.db, e.download, false, e.progress, e.logger)
+
+	assert.NoError(t, undownloadedService.Update(ctx))
+	assert.NoError(t, albumService.Sync(ctx))
+	assert.NoError(t, syncService.Sync(ctx))
+	assert.NoError(t, albumService.IndexShared())
+	e.download.Wait()
+	assert.NoError(t, albumService.Link())
+}
+
+func (e *endToEnd) items(t *testing.T) map[string]database.MediaItem {
+	items, err := e.db.MediaItems.GetAll()
+	assert.NoError(t, err)
+
+	byRemoteId := map[string]database.MediaItem{}
+	for _, item := range items {
+		byRemoteId[item.RemoteId] = item
+	}
+	return byRemoteId
+}
+
+func (e *endToEnd) assertDownloaded(t *testing.T, remoteId string, content string) {
+	item, ok := e.items(t)[remoteId]
+	assert.True(t, ok, "media item %s not indexed", remoteId)
+	assert.True(t, item.Downloaded, "media item %s not downloaded: %s", remoteId, item.LastError)
+
+	fileContent, err := os.ReadFile(filepath.Join(e.rootDir, item.LocalPath, item.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(fileContent))
+}
+
+func libraryItems(count int, created time.Time) []fake.MediaItem {
+	var items []fake.MediaItem
+	for i := 0; i < count; i++ {
+		filename := fmt.Sprintf("IMG_%04d.jpg", i)
+		if i%3 == 2 {
+			filename = fmt.Sprintf("VID_%04d.mp4", i)
+		}
+		items = append(items, fake.NewMediaItem(fmt.Sprintf("item%d", i), filename, created.AddDate(0, 0, -i), fmt.Sprintf("content of %d", i)))
+	}
+	return items
+}
+
+func TestEndToEnd_InitialSyncDownloadsWholeLibraryAndLinksAlbums(t *testing.T) {
+	e := createEndToEnd(t, libraryItems(5, time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC))...)
+	e.server.AddAlbum(fake.Album{Album: models.Album{Id: "holiday", Title: "Holiday"}, MediaItemIds: []string{"item0", "item2"}})
+
+	e.sync(t)
+
+	for i := 0; i < 5; i++ {
+		e.assertDownloaded(t, fmt.Sprintf("item%d", i), fmt.Sprintf("content of %d", i))
+	}
+	assert.Equal(t, 3, e.server.Requests(fake.MediaItemsList))
+	assert.Equal(t, 5, e.server.Requests(fake.Download))
+
+	video := e.items(t)["item2"]
+	linked, err := os.ReadFile(filepath.Join(e.rootDir, AlbumsDir, "Holiday", video.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, "content of 2", string(linked))
+	assert.Equal(t, ProgressCounts{Indexed: 5, Queued: 5, Downloaded: 5}, withoutTimes(e.progress.Counts()))
+}
+
+func withoutTimes(counts ProgressCounts) ProgressCounts {
+	counts.Bytes = 0
+	counts.DownloadTime = 0
+	counts.Elapsed = 0
+	return counts
+}
+
+func TestEndToEnd_LaterSyncSearchesForNewMediaItems(t *testing.T) {
+	e := createEndToEnd(t, libraryItems(2, time.Now().AddDate(0, 0, -30))...)
+	e.sync(t)
+
+	e.server.AddMediaItems(fake.NewMediaItem("new", "IMG_new.jpg", time.Now(), "new content"))
+	e.sync(t)
+
+	e.assertDownloaded(t, "new", "new content")
+	assert.Equal(t, 1, e.server.Requests(fake.MediaItemsList))
+	assert.Equal(t, 1, e.server.Requests(fake.MediaItemsSearch))
+	assert.Equal(t, 3, e.server.Requests(fake.Download))
+}
+
+func TestEndToEnd_ExpiredBaseUrlIsRefreshed(t *testing.T) {
+	e := createEndToEnd(t, libraryItems(3, time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC))...)
+	queuer := &mockQueuer{}
+	syncService := NewSyncService(e.api, e.db, queuer, layout.Default, nil, e.logger)
+	assert.NoError(t, syncService.Sync(context.Background()))
+
+	// base urls are only valid for an hour, downloads queued before they expire get a fresh one
+	e.server.ExpireBaseUrls()
+	e.download.QueueDownload(queuer.queuedIds...)
+	e.download.Wait()
+
+	for i := 0; i < 3; i++ {
+		e.assertDownloaded(t, fmt.Sprintf("item%d", i), fmt.Sprintf("content of %d", i))
+	}
+	assert.Equal(t, 3, e.server.Requests(fake.MediaItemsGet))
+	assert.Equal(t, 6, e.server.Requests(fake.Download))
+}
+
+func TestEndToEnd_RetriesFaultsAndQuotaErrors(t *testing.T) {
+	e := createEndToEnd(t, libraryItems(3, time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC))...)
+	e.server.Inject(fake.Fault{Endpoint: fake.MediaItemsList, Status: 500, Times: 1})
+	e.server.Inject(fake.Fault{Endpoint: fake.MediaItemsList, Status: 429, Times: 1, RetryAfter: "0"})
+	e.server.Inject(fake.Fault{Endpoint: fake.Download, Status: 503, Times: 2, RetryAfter: "0"})
+	e.server.SetLatency(5 * time.Millisecond)
+
+	e.sync(t)
+
+	for i := 0; i < 3; i++ {
+		e.assertDownloaded(t, fmt.Sprintf("item%d", i), fmt.Sprintf("content of %d", i))
+	}
+	assert.Equal(t, 4, e.server.Requests(fake.MediaItemsList))
+	assert.Equal(t, 5, e.server.Requests(fake.Download))
+}
+
+func TestEndToEnd_RetryFailedSkipsMediaItemsDeletedFromLibrary(t *testing.T) {
+	e := createEndToEnd(t, libraryItems(3, time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC))...)
+	// fails every download of the first sync, more times than they are retried
+	e.server.Inject(fake.Fault{Endpoint: fake.Download, Status: 400, Times: 3})
+	e.sync(t)
+
+	items := e.items(t)
+	for _, item := range items {
+		assert.False(t, item.Downloaded)
+		assert.Contains(t, item.LastError, "status code: 400")
+	}
+	assert.Equal(t, 3, e.progress.Counts().Failed)
+
+	// batchGet reports the deleted media item as an error, the others get a fresh base url
+	e.server.RemoveMediaItem("item1")
+	e.server.ExpireBaseUrls()
+	undownloadedService := NewUndownloadedService(e.api, e.db, e.download, false, e.progress, e.logger)
+	assert.NoError(t, undownloadedService.Update(context.Background()))
+	e.download.Wait()
+
+	e.assertDownloaded(t, "item0", "content of 0")
+	e.assertDownloaded(t, "item2", "content of 2")
+	assert.False(t, e.items(t)["item1"].Downloaded)
+	assert.Equal(t, 1, e.server.Requests(fake.MediaItemsBatchGet))
+	assert.Zero(t, e.server.Requests(fake.MediaItemsGet))
+}
+
+func TestEndToEnd_DryRunPlansTheFilesSyncDownloads(t *testing.T) {
+	items := libraryItems(3, time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC))
+	items = append(items, fake.NewMediaItem("clash", items[0].Filename, items[0].Metadata.CreationTime, "same name"))
+	e := createEndToEnd(t, items...)
+
+	planner := NewSyncPlanner(e.api, e.db, layout.Default, e.rootDir, false, e.logger)
+	plan, err := planner.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, plan.New)
+	assert.Equal(t, 1, plan.Renamed)
+	assert.Zero(t, e.server.Requests(fake.Download))
+	entries, err := os.ReadDir(e.rootDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	e.sync(t)
+
+	indexed := e.items(t)
+	for _, download := range plan.Downloads {
+		item := indexed[download.RemoteId]
+		assert.Equal(t, filepath.Join(item.LocalPath, item.LocalFilename), download.Path())
+		assert.FileExists(t, filepath.Join(e.rootDir, download.Path()))
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/oauth2"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+)
+
+// ErrorClass says what can be done about an error
+type ErrorClass int
+
+const (
+	// Permanent errors fail the same way however often they are tried
+	Permanent ErrorClass = iota
+	// Retryable errors are likely to go away, e.g. a dropped connection
+	Retryable
+	// AuthRequired errors need the user to authorise again before anything succeeds
+	AuthRequired
+	// QuotaExhausted errors are the api asking to slow down
+	QuotaExhausted
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Retryable:
+		return "retryable"
+	case AuthRequired:
+		return "auth required"
+	case QuotaExhausted:
+		return "quota exhausted"
+	default:
+		return "permanent"
+	}
+}
+
+type ErrorClassifier interface {
+	Classify(err error) ErrorClass
+}
+
+// DefaultErrorClassifier classifies network, system call and api errors, however deeply they are
+// wrapped
+type DefaultErrorClassifier struct {
+}
+
+// retryableErrnos are connection failures that usually succeed on a second attempt
+var retryableErrnos = []syscall.Errno{syscall.ECONNRESET, syscall.ETIMEDOUT, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE}
+
+func (d DefaultErrorClassifier) Classify(err error) ErrorClass {
+	if errors.Is(err, oauth2.ErrTokenRevoked) {
+		return AuthRequired
+	}
+
+	var apiError models.ApiError
+	if errors.As(err, &apiError) {
+		return classifyStatusCode(apiError)
+	}
+
+	// a cancelled run is stopping, a deadline is a request that took too long
+	if errors.Is(err, context.Canceled) {
+		return Permanent
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Retryable
+	}
+
+	for _, errno := range retryableErrnos {
+		if errors.Is(err, errno) {
+			return Retryable
+		}
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return Retryable
+	}
+
+	var opError *net.OpError
+	var dnsError *net.DNSError
+	if errors.As(err, &opError) || errors.As(err, &dnsError) {
+		return Retryable
+	}
+	return Permanent
+}
+
+func classifyStatusCode(apiError models.ApiError) ErrorClass {
+	if apiError.IsQuotaExhausted() {
+		return QuotaExhausted
+	}
+
+	switch apiError.StatusCode {
+	case http.StatusUnauthorized:
+		return AuthRequired
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return Retryable
+	default:
+		return Permanent
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/oauth2"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectionRefused dials a port nothing listens on, returning the error chain the http client sees
+func connectionRefused(t *testing.T) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	_, err = http.Get("http://" + address)
+	assert.Error(t, err)
+	return err
+}
+
+// clientTimeout makes a request that takes longer than the client allows
+func clientTimeout(t *testing.T) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.Timeout = 10 * time.Millisecond
+	_, err := client.Get(server.URL)
+	assert.Error(t, err)
+	return err
+}
+
+func TestDefaultErrorClassifier_Classify(t *testing.T) {
+	connectionReset := &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: os.NewSyscallError("read", syscall.ECONNRESET),
+	}}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{name: "misc error", err: errors.New("misc error"), expected: Permanent},
+		{name: "connection refused", err: connectionRefused(t), expected: Retryable},
+		{name: "client timeout", err: clientTimeout(t), expected: Retryable},
+		{name: "connection reset", err: connectionReset, expected: Retryable},
+		{name: "wrapped connection reset", err: fmt.Errorf("listing failed: %w", connectionReset), expected: Retryable},
+		{name: "timed out syscall", err: os.NewSyscallError("connect", syscall.ETIMEDOUT), expected: Retryable},
+		{name: "dns failure", err: &net.DNSError{Err: "no such host", Name: "photoslibrary.googleapis.com"}, expected: Retryable},
+		{name: "truncated body", err: fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), expected: Retryable},
+		{name: "deadline exceeded", err: &url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded}, expected: Retryable},
+		{name: "cancelled", err: &url.Error{Op: "Get", URL: "http://example.com", Err: cancelled.Err()}, expected: Permanent},
+		{name: "token revoked", err: &url.Error{Op: "Get", URL: "http://example.com", Err: fmt.Errorf("%w: invalid_grant", oauth2.ErrTokenRevoked)}, expected: AuthRequired},
+		{name: "unauthorised", err: models.ApiError{StatusCode: http.StatusUnauthorized}, expected: AuthRequired},
+		{name: "too many requests", err: fmt.Errorf("search failed: %w", models.ApiError{StatusCode: http.StatusTooManyRequests}), expected: QuotaExhausted},
+		{name: "service unavailable", err: models.ApiError{StatusCode: http.StatusServiceUnavailable}, expected: QuotaExhausted},
+		{name: "server error", err: models.ApiError{StatusCode: http.StatusInternalServerError}, expected: Retryable},
+		{name: "bad request", err: models.ApiError{StatusCode: http.StatusBadRequest}, expected: Permanent},
+		{name: "not found", err: models.ApiError{StatusCode: http.StatusNotFound}, expected: Permanent},
+	}
+
+	classifier := DefaultErrorClassifier{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, classifier.Classify(tc.err), "%s", tc.err)
+		})
+	}
+}
@@ -1,22 +1,25 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
-	"reflect"
 	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
 )
 
 type ExponentialRetryFactory struct {
 	baseTimeInSeconds float64
 	sideEffects       sideEffects
 	maxTimeInSeconds  float64
-	errorTypes        []interface{}
+	classifier        ErrorClassifier
 }
 
 type sideEffects interface {
-	Sleep(d time.Duration)
+	Sleep(ctx context.Context, d time.Duration) error
 	RandomFloat64() float64
 }
 
@@ -27,17 +30,27 @@ func (d2 defaultSideEffects) RandomFloat64() float64 {
 	return rand.Float64()
 }
 
-func (d2 defaultSideEffects) Sleep(d time.Duration) {
-	time.Sleep(d)
+func (d2 defaultSideEffects) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func NewExponentialRetryFactory(errorTypes ...interface{}) ExponentialRetryFactory {
+// NewExponentialRetryFactory creates trackers that retry the errors classifier says are worth
+// retrying. Without a classifier every error is retried
+func NewExponentialRetryFactory(classifier ErrorClassifier) ExponentialRetryFactory {
 	rand.Seed(time.Now().UnixNano())
 	return ExponentialRetryFactory{
 		baseTimeInSeconds: 1,
 		sideEffects:       defaultSideEffects{},
 		maxTimeInSeconds:  30,
-		errorTypes:        errorTypes,
+		classifier:        classifier,
 	}
 }
 
@@ -47,7 +60,7 @@ func (e ExponentialRetryFactory) Create() RetryTracker {
 		baseTimeInSeconds: e.baseTimeInSeconds,
 		sideEffects:       e.sideEffects,
 		timeoutTime:       timeout,
-		errorTypes:        e.errorTypes,
+		classifier:        e.classifier,
 	}
 }
 
@@ -56,11 +69,14 @@ type ExponentialRetryTracker struct {
 	baseTimeInSeconds float64
 	sideEffects       sideEffects
 	timeoutTime       time.Time
-	errorTypes        []interface{}
+	classifier        ErrorClassifier
+	// lastError decides whether the api asked for a specific wait
+	lastError error
 }
 
 func (e *ExponentialRetryTracker) ShouldRetry(err error) bool {
 	e.tries += 1
+	e.lastError = err
 	if time.Now().After(e.timeoutTime) {
 		return false
 	}
@@ -69,45 +85,42 @@ func (e *ExponentialRetryTracker) ShouldRetry(err error) bool {
 		return true
 	}
 
-	if len(e.errorTypes) == 0 {
+	if e.classifier == nil {
 		return true
 	}
 
-	return searchForErrorTypes(e.errorTypes, err)
+	class := e.classifier.Classify(err)
+	return class == Retryable || class == QuotaExhausted
 }
 
-func (e *ExponentialRetryTracker) Wait() {
+// Wait sleeps before the next try, for no longer than the retries have left. When the api asks
+// for a longer wait than that the last error is returned instead, as is ctx's when it is cancelled
+func (e *ExponentialRetryTracker) Wait(ctx context.Context) error {
 	// using full jitter algorithm
 	// sleep = random_between(0, min(cap, base * 2 ** attempt))
 	// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
 
 	maxSleepTimeInSeconds := e.baseTimeInSeconds * math.Pow(2, float64(e.tries))
 	waitTimeInMilliseconds := maxSleepTimeInSeconds * e.sideEffects.RandomFloat64() * 1000
-	e.sideEffects.Sleep(time.Duration(int64(waitTimeInMilliseconds)) * time.Millisecond)
+	wait := time.Duration(int64(waitTimeInMilliseconds)) * time.Millisecond
+
+	remaining := max(time.Until(e.timeoutTime), 0)
+	// never retry sooner than the api asked for
+	apiWait := retryAfter(e.lastError)
+	if apiWait > remaining {
+		return fmt.Errorf("google photos asked to wait %s, longer than is left for retrying: %w", apiWait, e.lastError)
+	}
+	wait = min(max(wait, apiWait), remaining)
+	return e.sideEffects.Sleep(ctx, wait)
 }
 
-func searchForErrorTypes(validErrorTypes []interface{}, err interface{}) bool {
-	for {
-		value := reflect.Indirect(reflect.ValueOf(err))
-		if !value.IsValid() {
-			break
-		}
-
-		if value.Kind() != reflect.Struct {
-			break
-		}
-
-		for _, errorType := range validErrorTypes {
-			if fmt.Sprintf("%T", errorType) == fmt.Sprintf("%T", err) {
-				return true
-			}
-		}
-
-		field := value.FieldByName("Err")
-		if !field.IsValid() {
-			break
-		}
-		err = field.Interface()
+// retryAfter returns how long the api asked to wait before trying again, if it asked at all
+func retryAfter(err error) time.Duration {
+	var apiError models.ApiError
+	if err == nil || !errors.As(err, &apiError) || !apiError.IsQuotaExhausted() {
+		return 0
 	}
-	return false
+
+	wait, _ := apiError.RetryAfter(time.Now())
+	return wait
 }
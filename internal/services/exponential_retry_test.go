@@ -1,13 +1,17 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,8 +23,9 @@ func (m *mockSleeper) RandomFloat64() float64 {
 	return 1
 }
 
-func (m *mockSleeper) Sleep(d time.Duration) {
+func (m *mockSleeper) Sleep(_ context.Context, d time.Duration) error {
 	m.durations = append(m.durations, d.Milliseconds())
+	return nil
 }
 
 func TestExponentialRetryTracker_ShouldRetryUntilTimeoutReached(t *testing.T) {
@@ -50,7 +55,7 @@ func TestExponentialRetryTracker_Wait_DurationsAreCorrect(t *testing.T) {
 
 	for i := 0; i < 4; i++ {
 		tracker.ShouldRetry(errors.New("wait test"))
-		tracker.Wait()
+		assert.NoError(t, tracker.Wait(context.Background()))
 	}
 
 	assert.Equal(t, []int64{2000, 4000, 8000, 16000}, sleeper.durations)
@@ -69,7 +74,7 @@ func TestExponentialRetryTracker_Wait_UnevenDurationsAreCorrect(t *testing.T) {
 
 	for i := 0; i < 4; i++ {
 		tracker.ShouldRetry(errors.New("wait test"))
-		tracker.Wait()
+		assert.NoError(t, tracker.Wait(context.Background()))
 	}
 
 	assert.Equal(t, []int64{600, 1200, 2400, 4800}, sleeper.durations)
@@ -121,10 +126,7 @@ func TestExponentialRetryTracker_ShouldRetryReturnsTrueOnCertainErrors(t *testin
 		baseTimeInSeconds: 1,
 		sideEffects:       &mockSleeper{},
 		maxTimeInSeconds:  30,
-		errorTypes: []interface{}{
-			net.OpError{},
-			new(net.OpError),
-		},
+		classifier:        DefaultErrorClassifier{},
 	}
 	tracker, ok := factory.Create().(*ExponentialRetryTracker)
 	assert.True(t, ok)
@@ -147,3 +149,97 @@ func TestExponentialRetryTracker_ShouldRetry_ReturnsTrueIfNoErrorsSpecified(t *t
 
 	assert.True(t, tracker.ShouldRetry(errors.New("test error")))
 }
+
+func TestExponentialRetryTracker_ShouldRetryWhenApiAsksToSlowDown(t *testing.T) {
+	factory := ExponentialRetryFactory{
+		baseTimeInSeconds: 1,
+		sideEffects:       &mockSleeper{},
+		maxTimeInSeconds:  30,
+		classifier:        DefaultErrorClassifier{},
+	}
+
+	testCases := []struct {
+		statusCode int
+		pass       bool
+	}{
+		{statusCode: http.StatusTooManyRequests, pass: true},
+		{statusCode: http.StatusServiceUnavailable, pass: true},
+		{statusCode: http.StatusBadRequest, pass: false},
+	}
+	for _, tc := range testCases {
+		t.Run(strconv.Itoa(tc.statusCode), func(t *testing.T) {
+			tracker := factory.Create()
+			err := fmt.Errorf("listing failed: %w", models.ApiError{StatusCode: tc.statusCode})
+			assert.Equal(t, tc.pass, tracker.ShouldRetry(err))
+		})
+	}
+}
+
+func TestExponentialRetryTracker_Wait_HonoursRetryAfter(t *testing.T) {
+	sleeper := &mockSleeper{}
+	factory := ExponentialRetryFactory{
+		baseTimeInSeconds: 1,
+		sideEffects:       sleeper,
+		maxTimeInSeconds:  30,
+	}
+	tracker := factory.Create()
+
+	headers := http.Header{}
+	headers.Set("Retry-After", "20")
+	tracker.ShouldRetry(models.ApiError{StatusCode: http.StatusTooManyRequests, Headers: headers})
+	assert.NoError(t, tracker.Wait(context.Background()))
+
+	// a shorter Retry-After than the backoff doesn't shorten the wait
+	headers.Set("Retry-After", "1")
+	tracker.ShouldRetry(models.ApiError{StatusCode: http.StatusTooManyRequests, Headers: headers})
+	assert.NoError(t, tracker.Wait(context.Background()))
+
+	assert.Equal(t, []int64{20000, 4000}, sleeper.durations)
+}
+
+func TestExponentialRetryTracker_Wait_StopsWhenRetryAfterIsLongerThanTimeLeft(t *testing.T) {
+	sleeper := &mockSleeper{}
+	factory := ExponentialRetryFactory{
+		baseTimeInSeconds: 1,
+		sideEffects:       sleeper,
+		maxTimeInSeconds:  30,
+	}
+	tracker := factory.Create()
+
+	headers := http.Header{}
+	headers.Set("Retry-After", "60")
+	assert.True(t, tracker.ShouldRetry(models.ApiError{StatusCode: http.StatusTooManyRequests, Headers: headers}))
+
+	err := tracker.Wait(context.Background())
+	assert.ErrorAs(t, err, &models.ApiError{})
+	assert.Empty(t, sleeper.durations)
+}
+
+func TestExponentialRetryTracker_Wait_IsCappedAtTimeLeft(t *testing.T) {
+	sleeper := &mockSleeper{}
+	factory := ExponentialRetryFactory{
+		baseTimeInSeconds: 10,
+		sideEffects:       sleeper,
+		maxTimeInSeconds:  30,
+	}
+	tracker, ok := factory.Create().(*ExponentialRetryTracker)
+	assert.True(t, ok)
+
+	tracker.timeoutTime = time.Now().Add(5 * time.Second)
+	tracker.ShouldRetry(errors.New("wait test"))
+	assert.NoError(t, tracker.Wait(context.Background()))
+
+	assert.Len(t, sleeper.durations, 1)
+	assert.LessOrEqual(t, sleeper.durations[0], int64(5000))
+	assert.Greater(t, sleeper.durations[0], int64(4000))
+}
+
+func TestExponentialRetryTracker_Wait_IsCancelled(t *testing.T) {
+	factory := NewExponentialRetryFactory(nil)
+	tracker := factory.Create()
+	tracker.ShouldRetry(errors.New("wait test"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, tracker.Wait(ctx), context.Canceled)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+var ErrLayoutChanged = errors.New("layout template differs from the one used by the library")
+
+// ResolveLayout returns the layout template to index media items with. The template is stored in
+// the library the first time it is used, an empty requested template means the stored one. A
+// different template is refused once media items have been indexed, otherwise the library would
+// end up with files laid out in two different ways
+func ResolveLayout(db database.PhotoDatabase, requested string, logger utils.Logger) (layout.Template, error) {
+	template, store, err := chooseLayout(db, requested)
+	if err != nil || !store {
+		return template, err
+	}
+
+	logger.Info.Printf("using layout template '%s'", template)
+	err = db.Settings.UpdateLayoutTemplate(template.String())
+	if err != nil {
+		return layout.Template{}, err
+	}
+	return template, nil
+}
+
+// PreviewLayout returns the layout template ResolveLayout would, without storing it
+func PreviewLayout(db database.PhotoDatabase, requested string) (layout.Template, error) {
+	template, _, err := chooseLayout(db, requested)
+	return template, err
+}
+
+// chooseLayout returns the layout template to use and whether it differs from the stored one
+func chooseLayout(db database.PhotoDatabase, requested string) (template layout.Template, store bool, err error) {
+	stored, err := db.Settings.LayoutTemplate()
+	if err != nil {
+		return
+	}
+
+	if requested == "" || requested == stored {
+		if stored == "" {
+			requested = layout.DefaultTemplate
+		} else {
+			template, err = layout.Parse(stored)
+			return
+		}
+	}
+
+	template, err = layout.Parse(requested)
+	if err != nil {
+		return
+	}
+
+	if stored != "" {
+		var counts database.MediaItemCounts
+		counts, err = db.MediaItems.Counts(false)
+		if err != nil {
+			return
+		}
+		if counts.Total > 0 {
+			err = fmt.Errorf("%w: library uses '%s', run relayout to move existing files to '%s'", ErrLayoutChanged, stored, requested)
+			return layout.Template{}, false, err
+		}
+	}
+	return template, true, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/internal/lockfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveLayoutStoresDefaultForNewLibrary(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+
+	template, err := ResolveLayout(db, "", db.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, layout.DefaultTemplate, template.String())
+
+	stored, err := db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, layout.DefaultTemplate, stored)
+}
+
+func TestResolveLayoutUsesStoredTemplate(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateLayoutTemplate(layout.LegacyTemplate))
+
+	template, err := ResolveLayout(db, "", db.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, layout.LegacyTemplate, template.String())
+}
+
+func TestResolveLayoutChangesTemplateOfEmptyLibrary(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateLayoutTemplate(layout.LegacyTemplate))
+
+	template, err := ResolveLayout(db, "{year}/{filename}", db.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "{year}/{filename}", template.String())
+
+	stored, err := db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, "{year}/{filename}", stored)
+}
+
+func TestResolveLayoutRefusesChangedTemplate(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateLayoutTemplate(layout.LegacyTemplate))
+	item := database.CreateTestMediaItem(t)
+	assert.NoError(t, db.MediaItems.Save(&item))
+
+	_, err := ResolveLayout(db, "{year}/{filename}", db.Logger)
+	assert.True(t, errors.Is(err, ErrLayoutChanged))
+
+	stored, err := db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, layout.LegacyTemplate, stored)
+}
+
+func TestPreviewLayoutDoesNotStoreTemplate(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+
+	template, err := PreviewLayout(db, "{year}/{filename}")
+	assert.NoError(t, err)
+	assert.Equal(t, "{year}/{filename}", template.String())
+
+	stored, err := db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Empty(t, stored)
+
+	item := database.CreateTestMediaItem(t)
+	assert.NoError(t, db.MediaItems.Save(&item))
+	assert.NoError(t, db.Settings.UpdateLayoutTemplate(layout.LegacyTemplate))
+	_, err = PreviewLayout(db, "{year}/{filename}")
+	assert.True(t, errors.Is(err, ErrLayoutChanged))
+}
+
+func TestLayoutReservesTheDownloadersOwnNames(t *testing.T) {
+	for _, name := range []string{AlbumsDir, TrashDir, StagingDir, relayoutDir, database.GooglePhotosDatabaseFile, lockfile.FileName} {
+		assert.True(t, layout.IsReserved(name), name)
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"math"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	api "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// mediaItemIndexer saves media items returned by the api in the database, renaming local files
+// that would clash, and queues the new ones for download
+type mediaItemIndexer struct {
+	db       database.PhotoDatabase
+	download DownloaderQueuer
+	layout   layout.Template
+	progress *Progress
+	logger   utils.Logger
+}
+
+func (i *mediaItemIndexer) index(items []api.MediaItem, source string, queue bool) (err error) {
+	for _, item := range items {
+		dbItem := convertToDatabaseMediaItem(i.layout, item)[0]
+		dbItem.Source = source
+		if i.layout.UsesAlbum() {
+			var album string
+			album, err = i.db.Albums.TitleForMediaItem(item.Id)
+			if err != nil {
+				return
+			}
+			dbItem.LocalPath, dbItem.LocalFilename = i.layout.Render(layoutFields(*dbItem, album))
+		}
+		filename := dbItem.LocalFilename
+
+		for counter := 2; counter < math.MaxInt; counter++ {
+			err = i.db.MediaItems.Save(dbItem)
+			if err == nil {
+				break
+			}
+
+			sqliteError, ok := err.(sqlite3.Error)
+			if ok &&
+				sqliteError.Code == database.ConstraintPrimaryError &&
+				sqliteError.ExtendedCode == database.ConstraintUniqueExtendedError {
+				if strings.Contains(sqliteError.Error(), "media_items.local_path, media_items.local_filename") {
+					newFilename := generateNewFilename(counter, filename)
+					dbItem.LocalFilename = newFilename
+					i.logger.Debug.Printf("duplicate file '%s' detected in '%s', trying renaming to '%s'", dbItem.LocalFilename, dbItem.LocalPath, newFilename)
+					continue
+				}
+				if strings.Contains(sqliteError.Error(), "media_items.remote_id") {
+					i.logger.Info.Printf("remote id '%s' already exists in db, skipping...", item.Id)
+					// already exists, so ignore
+					i.progress.Duplicate()
+					err = nil
+					goto noqueue
+				}
+			}
+
+			// unrecognized error so exit all loops
+			goto finished
+		}
+
+		i.progress.Indexed(1)
+		if queue {
+			i.download.QueueDownload(dbItem.Uuid)
+			i.progress.Queued(1)
+		}
+	noqueue:
+	}
+finished:
+	return
+}
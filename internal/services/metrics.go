@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/pkg/metrics"
+	"github.com/rjnienaber/gphotos_downloader/pkg/workerpool"
+)
+
+// Metrics are the counters and histograms exposed for alerting on scheduled runs. A nil Metrics
+// records nothing
+type Metrics struct {
+	Registry      *metrics.Registry
+	apiRequests   *metrics.Counter
+	downloadBytes *metrics.Counter
+	jobDuration   *metrics.Histogram
+	retries       *metrics.Counter
+	mutex         sync.Mutex
+	queue         *workerpool.JobQueue
+}
+
+func NewMetrics(db database.PhotoDatabase, includeShared bool) *Metrics {
+	registry := metrics.NewRegistry()
+	m := &Metrics{
+		Registry:      registry,
+		apiRequests:   registry.NewCounter("gphotos_api_requests_total", "Requests made to google photos by endpoint and status code, 0 when there was no answer.", "endpoint", "status"),
+		downloadBytes: registry.NewCounter("gphotos_download_bytes_total", "Bytes of media items downloaded."),
+		jobDuration:   registry.NewHistogram("gphotos_download_job_duration_seconds", "Time taken by download jobs, including retries.", metrics.DefaultBuckets, "result"),
+		retries:       registry.NewCounter("gphotos_retries_total", "Attempts retried after a failure, by operation.", "operation"),
+	}
+	registry.NewGaugeFunc("gphotos_download_queue_depth", "Downloads queued or in progress.", m.queueDepth)
+	registry.NewGaugeFunc("gphotos_media_items_pending", "Media items not downloaded yet.", func() float64 {
+		counts, err := db.MediaItems.Counts(includeShared)
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(counts.Pending)
+	})
+	return m
+}
+
+// ObserveRequest counts a request made to google photos
+func (m *Metrics) ObserveRequest(endpoint string, status int) {
+	if m == nil {
+		return
+	}
+	m.apiRequests.Inc(endpoint, strconv.Itoa(status))
+}
+
+func (m *Metrics) Downloaded(bytes int64, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.downloadBytes.Add(float64(bytes))
+	m.jobDuration.Observe(took.Seconds(), "downloaded")
+}
+
+func (m *Metrics) Failed(took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.jobDuration.Observe(took.Seconds(), "failed")
+}
+
+// CountRetries counts the retries of the trackers created by factory
+func (m *Metrics) CountRetries(factory RetryFactory, operation string) RetryFactory {
+	if m == nil {
+		return factory
+	}
+	return countingRetryFactory{factory: factory, retries: m.retries, operation: operation}
+}
+
+// trackQueue makes the depth of queue the one reported
+func (m *Metrics) trackQueue(queue *workerpool.JobQueue) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queue = queue
+}
+
+func (m *Metrics) queueDepth() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.queue == nil {
+		return 0
+	}
+	return float64(m.queue.Depth())
+}
+
+type countingRetryFactory struct {
+	factory   RetryFactory
+	retries   *metrics.Counter
+	operation string
+}
+
+func (c countingRetryFactory) Create() RetryTracker {
+	return countingRetryTracker{tracker: c.factory.Create(), retries: c.retries, operation: c.operation}
+}
+
+type countingRetryTracker struct {
+	tracker   RetryTracker
+	retries   *metrics.Counter
+	operation string
+}
+
+func (c countingRetryTracker) ShouldRetry(err error) bool {
+	retry := c.tracker.ShouldRetry(err)
+	if retry {
+		c.retries.Inc(c.operation)
+	}
+	return retry
+}
+
+func (c countingRetryTracker) Wait(ctx context.Context) error {
+	return c.tracker.Wait(ctx)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrapeMetrics(t *testing.T, metrics *Metrics) string {
+	server := httptest.NewServer(metrics.Registry)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_RecordsDownloadsAndRetries(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	downloaded := createMediaItemToDownload(t)
+	pending := createMediaItemToDownload(t)
+	assert.NoError(t, db.MediaItems.Save(&downloaded))
+	assert.NoError(t, db.MediaItems.Save(&pending))
+
+	downloader := mockDownloader{}
+	downloader.download = func(_ context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (models.DownloadedFile, error) {
+		if downloader.downloadCallCount < 2 {
+			return models.DownloadedFile{}, models.ApiError{StatusCode: 500}
+		}
+		return writeTempFile(t, "abcd"), nil
+	}
+
+	metrics := NewMetrics(db, false)
+	factory := NewExponentialRetryFactory(DefaultErrorClassifier{})
+	factory.baseTimeInSeconds = 0.01
+	service := NewDownloadService(context.Background(), &downloader, db, t.TempDir(),
+		WithMaxWorkers(1),
+		WithRetryFactory(metrics.CountRetries(factory, "download")),
+		WithMetrics(metrics),
+	)
+	service.QueueDownload(downloaded.Uuid)
+	service.Finish()
+	metrics.ObserveRequest("mediaItems.search", 200)
+	metrics.ObserveRequest("mediaItems.search", 0)
+
+	body := scrapeMetrics(t, metrics)
+	assert.Contains(t, body, "\ngphotos_api_requests_total{endpoint=\"mediaItems.search\",status=\"0\"} 1\n")
+	assert.Contains(t, body, "\ngphotos_api_requests_total{endpoint=\"mediaItems.search\",status=\"200\"} 1\n")
+	assert.Contains(t, body, "\ngphotos_download_bytes_total 4\n")
+	assert.Contains(t, body, "\ngphotos_download_job_duration_seconds_count{result=\"downloaded\"} 1\n")
+	assert.Contains(t, body, "\ngphotos_retries_total{operation=\"download\"} 1\n")
+	assert.Contains(t, body, "\ngphotos_download_queue_depth 0\n")
+	assert.Contains(t, body, "\ngphotos_media_items_pending 1\n")
+}
+
+func TestMetrics_NilRecordsNothing(t *testing.T) {
+	var metrics *Metrics
+	factory := NoRetryFactory{}
+
+	metrics.ObserveRequest("mediaItems.get", 200)
+	metrics.Downloaded(10, 0)
+	metrics.Failed(0)
+	assert.Equal(t, factory, metrics.CountRetries(factory, "api"))
+}
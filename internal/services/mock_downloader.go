@@ -1,6 +1,10 @@
 package services
 
-import "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+import (
+	"context"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+)
 
 type mockDownloader struct {
 	get               func(mediaItemId string) (mediaItem models.MediaItem, err error)
@@ -9,11 +13,13 @@ type mockDownloader struct {
 	batchGetCallCount int
 	list              func(options models.PagingOptions) (mediaItems models.MediaItems, err error)
 	search            func(options models.SearchOptions) (mediaItems models.MediaItems, err error)
-	download          func(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error)
+	listAlbums        func(options models.PagingOptions) (albums models.Albums, err error)
+	listSharedAlbums  func(options models.PagingOptions) (albums models.Albums, err error)
+	download          func(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error)
 	downloadCallCount int
 }
 
-func (m *mockDownloader) Get(mediaItemId string) (mediaItem models.MediaItem, err error) {
+func (m *mockDownloader) Get(_ context.Context, mediaItemId string) (mediaItem models.MediaItem, err error) {
 	m.getCallCount++
 	if m.get != nil {
 		return m.get(mediaItemId)
@@ -21,7 +27,7 @@ func (m *mockDownloader) Get(mediaItemId string) (mediaItem models.MediaItem, er
 	return
 }
 
-func (m *mockDownloader) BatchGet(mediaItemIds []string) (mediaItems models.MediaItemsResult, err error) {
+func (m *mockDownloader) BatchGet(_ context.Context, mediaItemIds []string) (mediaItems models.MediaItemsResult, err error) {
 	m.batchGetCallCount++
 	if m.batchGet != nil {
 		return m.batchGet(mediaItemIds)
@@ -29,24 +35,38 @@ func (m *mockDownloader) BatchGet(mediaItemIds []string) (mediaItems models.Medi
 	return
 }
 
-func (m *mockDownloader) List(options models.PagingOptions) (mediaItems models.MediaItems, err error) {
+func (m *mockDownloader) List(_ context.Context, options models.PagingOptions) (mediaItems models.MediaItems, err error) {
 	if m.list != nil {
 		return m.list(options)
 	}
 	return
 }
 
-func (m *mockDownloader) Search(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+func (m *mockDownloader) Search(_ context.Context, options models.SearchOptions) (mediaItems models.MediaItems, err error) {
 	if m.search != nil {
 		return m.search(options)
 	}
 	return
 }
 
-func (m *mockDownloader) Download(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
+func (m *mockDownloader) ListAlbums(_ context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	if m.listAlbums != nil {
+		return m.listAlbums(options)
+	}
+	return
+}
+
+func (m *mockDownloader) ListSharedAlbums(_ context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	if m.listSharedAlbums != nil {
+		return m.listSharedAlbums(options)
+	}
+	return
+}
+
+func (m *mockDownloader) Download(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
 	m.downloadCallCount++
 	if m.download != nil {
-		return m.download(tmpDir, baseUrl, isPhoto)
+		return m.download(ctx, partial, baseUrl, isPhoto)
 	}
 	return
 }
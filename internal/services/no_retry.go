@@ -1,5 +1,7 @@
 package services
 
+import "context"
+
 type NoRetryFactory struct {
 }
 
@@ -16,5 +18,6 @@ func (n NoRetryTracker) ShouldRetry(_ error) bool {
 	return false
 }
 
-func (n NoRetryTracker) Wait() {
+func (n NoRetryTracker) Wait(_ context.Context) error {
+	return nil
 }
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// Progress counts what a run has done so far. It is fed by indexing, refreshing and downloading,
+// and is safe to use from the download workers. A nil Progress counts nothing
+type Progress struct {
+	mutex    sync.Mutex
+	started  time.Time
+	now      func() time.Time
+	counts   ProgressCounts
+	reported ProgressCounts
+}
+
+type ProgressCounts struct {
+	Indexed    int
+	Refreshed  int
+	Queued     int
+	Downloaded int
+	// Duplicates counts media items that were already indexed or downloaded
+	Duplicates int
+	Failed     int
+	Bytes      int64
+	// DownloadTime adds up the time spent on each download, so it exceeds the elapsed time when
+	// several workers download at once
+	DownloadTime time.Duration
+	Elapsed      time.Duration
+}
+
+func NewProgress() *Progress {
+	return &Progress{started: time.Now(), now: time.Now}
+}
+
+// Reset starts counting from scratch, e.g. for the next pass of the daemon
+func (p *Progress) Reset() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.counts = ProgressCounts{}
+	p.reported = ProgressCounts{}
+	p.started = p.now()
+}
+
+func (p *Progress) update(fn func(counts *ProgressCounts)) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fn(&p.counts)
+}
+
+func (p *Progress) Indexed(items int) {
+	p.update(func(counts *ProgressCounts) { counts.Indexed += items })
+}
+
+func (p *Progress) Refreshed(items int) {
+	p.update(func(counts *ProgressCounts) { counts.Refreshed += items })
+}
+
+func (p *Progress) Queued(items int) {
+	p.update(func(counts *ProgressCounts) { counts.Queued += items })
+}
+
+func (p *Progress) Duplicate() {
+	p.update(func(counts *ProgressCounts) { counts.Duplicates++ })
+}
+
+func (p *Progress) Downloaded(bytes int64, took time.Duration) {
+	p.update(func(counts *ProgressCounts) {
+		counts.Downloaded++
+		counts.Bytes += bytes
+		counts.DownloadTime += took
+	})
+}
+
+func (p *Progress) Failed() {
+	p.update(func(counts *ProgressCounts) { counts.Failed++ })
+}
+
+// Counts returns what has been done since the run started
+func (p *Progress) Counts() ProgressCounts {
+	if p == nil {
+		return ProgressCounts{}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	counts := p.counts
+	counts.Elapsed = p.now().Sub(p.started)
+	return counts
+}
+
+// String is the one line version of the counts, used while the run is going
+func (c ProgressCounts) String() string {
+	line := fmt.Sprintf("indexed %d, queued %d, downloaded %d/%d (%s)", c.Indexed, c.Queued, c.Downloaded, c.Queued, utils.FormatBytes(c.Bytes))
+	if c.Failed > 0 {
+		line += fmt.Sprintf(", %d failed", c.Failed)
+	}
+	return line
+}
+
+// WriteSummary writes the counts of a finished run
+func (c ProgressCounts) WriteSummary(out io.Writer) error {
+	var rate string
+	if seconds := c.Elapsed.Seconds(); seconds > 0 && c.Bytes > 0 {
+		rate = fmt.Sprintf(", %s/s", utils.FormatBytes(int64(float64(c.Bytes)/seconds)))
+	}
+
+	lines := []string{
+		fmt.Sprintf("indexed:\t%d media items", c.Indexed),
+		fmt.Sprintf("refreshed:\t%d media items", c.Refreshed),
+		fmt.Sprintf("queued:\t%d downloads", c.Queued),
+		fmt.Sprintf("downloaded:\t%d media items, %s%s", c.Downloaded, utils.FormatBytes(c.Bytes), rate),
+		fmt.Sprintf("duplicates:\t%d skipped", c.Duplicates),
+		fmt.Sprintf("failed:\t%d media items", c.Failed),
+		fmt.Sprintf("download time:\t%s", c.DownloadTime.Round(time.Second)),
+		fmt.Sprintf("elapsed:\t%s", c.Elapsed.Round(time.Second)),
+	}
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Report shows the progress until ctx is done. On a terminal, live is given and its line is redrawn
+// every interval, otherwise the progress is logged every interval when it has changed
+func (p *Progress) Report(ctx context.Context, live *utils.LiveLine, interval time.Duration, logger utils.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if live != nil {
+				live.Clear()
+			}
+			return
+		case <-ticker.C:
+			counts := p.Counts()
+			if live != nil {
+				live.Show(counts.String())
+			} else if p.changed(counts) {
+				logger.Info.Printf("progress: %s", counts)
+			}
+		}
+	}
+}
+
+// changed reports whether counts differ from the ones last reported
+func (p *Progress) changed(counts ProgressCounts) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	counts.Elapsed = 0
+	if counts == p.reported {
+		return false
+	}
+	p.reported = counts
+	return true
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress_NilCountsNothing(t *testing.T) {
+	var progress *Progress
+	progress.Indexed(1)
+	progress.Downloaded(10, time.Second)
+
+	assert.Equal(t, ProgressCounts{}, progress.Counts())
+}
+
+func TestProgress_ResetStartsCountingAgain(t *testing.T) {
+	now := time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+	progress := NewProgress()
+	progress.now = func() time.Time { return now }
+	progress.Indexed(3)
+	progress.Failed()
+
+	now = now.Add(time.Hour)
+	progress.Reset()
+	progress.Indexed(1)
+	now = now.Add(time.Second)
+
+	assert.Equal(t, ProgressCounts{Indexed: 1, Elapsed: time.Second}, progress.Counts())
+}
+
+func TestProgress_WriteSummary(t *testing.T) {
+	started := time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+	progress := NewProgress()
+	progress.started = started
+	progress.now = func() time.Time { return started.Add(2 * time.Second) }
+
+	progress.Indexed(3)
+	progress.Queued(3)
+	progress.Duplicate()
+	progress.Downloaded(2048, time.Second)
+	progress.Downloaded(2048, 1500*time.Millisecond)
+	progress.Failed()
+
+	counts := progress.Counts()
+	assert.Equal(t, "indexed 3, queued 3, downloaded 2/3 (4.0 KiB), 1 failed", counts.String())
+
+	out := bytes.Buffer{}
+	assert.NoError(t, counts.WriteSummary(&out))
+	assert.Equal(t, `indexed:        3 media items
+refreshed:      0 media items
+queued:         3 downloads
+downloaded:     2 media items, 4.0 KiB, 2.0 KiB/s
+duplicates:     1 skipped
+failed:         1 media items
+download time:  3s
+elapsed:        2s
+`, out.String())
+}
+
+func TestProgress_ReportRedrawsLineOnTerminal(t *testing.T) {
+	progress := NewProgress()
+	progress.Queued(2)
+	out := &bytes.Buffer{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	progress.Report(ctx, utils.NewLiveLine(out), 10*time.Millisecond, utils.NewLogger(utils.Silent))
+
+	assert.Contains(t, out.String(), "\r\033[Kindexed 0, queued 2, downloaded 0/2 (0 B)")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\033[K"))
+}
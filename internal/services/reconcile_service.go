@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	api "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// maxDeletedPercent is the share of the library a full sync marks as deleted before it refuses to,
+// unless forced. A listing that misses that much is more likely a problem with the api
+const maxDeletedPercent = 50
+
+// TrashDir is the directory, relative to the library root, that files of deleted media items are
+// moved to with the trash policy
+const TrashDir = ".trash"
+
+// DeletionPolicy is what happens to the file of a media item deleted from google photos
+type DeletionPolicy string
+
+const (
+	KeepDeleted   DeletionPolicy = "keep"
+	TrashDeleted  DeletionPolicy = "trash"
+	RemoveDeleted DeletionPolicy = "delete"
+)
+
+func ParseDeletionPolicy(policy string) (DeletionPolicy, error) {
+	switch deletionPolicy := DeletionPolicy(policy); deletionPolicy {
+	case KeepDeleted, TrashDeleted, RemoveDeleted:
+		return deletionPolicy, nil
+	}
+	return KeepDeleted, fmt.Errorf("unknown deletion policy '%s'", policy)
+}
+
+func (d DeletionPolicy) MarshalText() ([]byte, error) {
+	return []byte(d), nil
+}
+
+func (d *DeletionPolicy) UnmarshalText(text []byte) (err error) {
+	*d, err = ParseDeletionPolicy(string(text))
+	return
+}
+
+type ReconcileReport struct {
+	Listed int
+	// Added counts media items the incremental sync missed, e.g. uploaded long after they were taken
+	Added    int
+	Deleted  int
+	Restored int
+	// Purged counts files removed from the trash after the retention period
+	Purged int
+}
+
+// ReconcileService compares the whole library in google photos with the database, to find media
+// items that are missing, were deleted, or were restored after being deleted
+type ReconcileService struct {
+	api        googlephotos.Downloader
+	db         database.PhotoDatabase
+	indexer    mediaItemIndexer
+	logger     utils.Logger
+	rootDir    string
+	pagingSize int
+	now        func() time.Time
+}
+
+func NewReconcileService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, template layout.Template, rootDir string, progress *Progress, logger utils.Logger) ReconcileService {
+	indexer := mediaItemIndexer{db: db, download: download, layout: template, progress: progress, logger: logger}
+	return ReconcileService{api: api, db: db, indexer: indexer, logger: logger, rootDir: rootDir, pagingSize: 100, now: time.Now}
+}
+
+// Due reports whether the full sync interval stored in the library has passed
+func (s *ReconcileService) Due() (bool, error) {
+	return fullSyncDue(s.db, s.now())
+}
+
+func fullSyncDue(db database.PhotoDatabase, now time.Time) (bool, error) {
+	days, err := db.Settings.FullSyncDays()
+	if err != nil || days == 0 {
+		return false, err
+	}
+
+	lastFullSync, err := db.Settings.LastFullSync()
+	if err != nil {
+		return false, err
+	}
+	return !now.Before(lastFullSync.AddDate(0, 0, days)), nil
+}
+
+// Reconcile lists the whole library and applies the deletion policy. Unless force is given, it
+// refuses to mark more than maxDeletedPercent of the library as deleted
+func (s *ReconcileService) Reconcile(ctx context.Context, force bool) (report ReconcileReport, err error) {
+	policyText, err := s.db.Settings.DeletionPolicy()
+	if err != nil {
+		return
+	}
+	policy, err := ParseDeletionPolicy(policyText)
+	if err != nil {
+		return
+	}
+
+	now := s.now()
+	knownIds, err := s.db.MediaItems.RemoteIds()
+	if err != nil {
+		return
+	}
+
+	remoteIds, missing, err := s.listRemote(ctx, knownIds)
+	if err != nil {
+		return
+	}
+	report.Listed = len(remoteIds)
+
+	if len(missing) > 0 {
+		s.logger.Info.Printf("found %d media items missed by previous syncs", len(missing))
+		err = s.indexer.index(missing, database.SourceLibrary, true)
+		if err != nil {
+			return
+		}
+		report.Added = len(missing)
+	}
+
+	items, err := s.db.MediaItems.GetAll()
+	if err != nil {
+		return
+	}
+
+	// an empty listing is far more likely to be a problem with the api than an empty library
+	if len(remoteIds) == 0 && len(items) > 0 {
+		return report, errors.New("google photos listed no media items, refusing to mark the whole library as deleted")
+	}
+
+	err = checkDeletions(items, remoteIds, force)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		// media items in shared albums aren't part of the library listing
+		if item.Source != database.SourceLibrary {
+			continue
+		}
+
+		deleted := !item.DeletedAt.IsZero()
+		if remoteIds[item.RemoteId] && deleted {
+			err = s.restore(item)
+			report.Restored++
+		} else if !remoteIds[item.RemoteId] && !deleted {
+			err = s.delete(item, policy, now)
+			report.Deleted++
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	report.Purged, err = s.purgeTrash(items, now)
+	if err != nil {
+		return
+	}
+
+	err = s.db.Settings.UpdateLastFullSync(now)
+	return
+}
+
+// checkDeletions refuses a listing that would mark more than maxDeletedPercent of the library as
+// deleted, unless forced
+func checkDeletions(items []database.MediaItem, remoteIds map[string]bool, force bool) error {
+	library, deleted := 0, 0
+	for _, item := range items {
+		if item.Source != database.SourceLibrary || !item.DeletedAt.IsZero() {
+			continue
+		}
+		library++
+		if !remoteIds[item.RemoteId] {
+			deleted++
+		}
+	}
+
+	if force || deleted*100 <= library*maxDeletedPercent {
+		return nil
+	}
+	return fmt.Errorf("%d of %d media items are missing from the listing of google photos, refusing to mark more than %d%% of the library as deleted, reconcile with -force if they were deleted",
+		deleted, library, maxDeletedPercent)
+}
+
+// listRemote lists the whole library, only keeping the media items that aren't known yet
+func (s *ReconcileService) listRemote(ctx context.Context, knownIds map[string]bool) (remoteIds map[string]bool, missing []api.MediaItem, err error) {
+	remoteIds = map[string]bool{}
+	options := api.PagingOptions{Size: s.pagingSize}
+	for {
+		var items api.MediaItems
+		items, err = s.api.List(ctx, options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, item := range items.MediaItems {
+			if !knownIds[item.Id] && !remoteIds[item.Id] {
+				missing = append(missing, item)
+			}
+			remoteIds[item.Id] = true
+		}
+
+		if items.NextPageToken == "" {
+			break
+		}
+		options.Token = items.NextPageToken
+	}
+	return
+}
+
+func (s *ReconcileService) delete(item database.MediaItem, policy DeletionPolicy, now time.Time) error {
+	relativePath := filepath.Join(item.LocalPath, item.LocalFilename)
+	s.logger.Info.Printf("'%s' was deleted from google photos, applying '%s' policy", relativePath, policy)
+	err := s.db.MediaItems.MarkAsDeleted(item.Uuid, now)
+	if err != nil || !item.Downloaded {
+		return err
+	}
+
+	switch policy {
+	case TrashDeleted:
+		trashPath := filepath.Join(TrashDir, item.LocalPath)
+		err = s.moveFile(item, trashPath)
+	case RemoveDeleted:
+		err = s.removeFile(item)
+	}
+	return err
+}
+
+// restore undoes the deletion of a media item. Removed files are downloaded again by the next sync
+func (s *ReconcileService) restore(item database.MediaItem) error {
+	s.logger.Info.Printf("'%s' is back in google photos, restoring it", item.LocalFilename)
+	err := s.db.MediaItems.Restore(item.Uuid)
+	if err != nil {
+		return err
+	}
+
+	if !isTrashed(item) {
+		return nil
+	}
+
+	originalPath, err := filepath.Rel(TrashDir, item.LocalPath)
+	if err != nil {
+		return err
+	}
+	if item.Downloaded {
+		return s.moveFile(item, originalPath)
+	}
+
+	// the file was purged, it is downloaded to its original location again
+	filename, err := s.freeFilename(item.LocalFilename, originalPath)
+	if err != nil {
+		return err
+	}
+	return s.db.MediaItems.UpdateLocation(item.Uuid, originalPath, filename)
+}
+
+// purgeTrash removes the files of media items that have been in the trash for longer than the
+// retention period
+func (s *ReconcileService) purgeTrash(items []database.MediaItem, now time.Time) (purged int, err error) {
+	retentionDays, err := s.db.Settings.TrashRetentionDays()
+	if err != nil {
+		return
+	}
+
+	cutoff := now.AddDate(0, 0, -retentionDays)
+	for _, item := range items {
+		if item.DeletedAt.IsZero() || !item.Downloaded || !isTrashed(item) || item.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		s.logger.Debug.Printf("purging '%s' from the trash", filepath.Join(item.LocalPath, item.LocalFilename))
+		err = s.removeFile(item)
+		if err != nil {
+			return
+		}
+		purged++
+	}
+	return
+}
+
+// moveFile moves the file of a media item to localPath, renaming it when another media item or file
+// has taken its name there
+func (s *ReconcileService) moveFile(item database.MediaItem, localPath string) error {
+	filename, err := s.freeFilename(item.LocalFilename, localPath)
+	if err != nil {
+		return err
+	}
+
+	from := filepath.Join(s.rootDir, item.LocalPath, item.LocalFilename)
+	to := filepath.Join(s.rootDir, localPath, filename)
+	s.logger.Debug.Printf("moving '%s' to '%s'", from, to)
+
+	err = os.MkdirAll(filepath.Dir(to), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(from, to)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.logger.Error.Printf("'%s' is missing, it will be downloaded again if needed", from)
+		err = s.db.MediaItems.MarkAsNotDownloaded(item.Uuid, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.db.MediaItems.UpdateLocation(item.Uuid, localPath, filename)
+	if err != nil {
+		return err
+	}
+	removeEmptyDirs(s.rootDir, item.LocalPath)
+	return nil
+}
+
+// freeFilename returns filename, or the first numbered version of it, that no media item uses in
+// localPath and no file is in the way of. Media items indexed while another was deleted can have
+// taken its location
+func (s *ReconcileService) freeFilename(filename string, localPath string) (string, error) {
+	candidate := filename
+	for counter := 2; ; counter++ {
+		used, err := s.db.MediaItems.LocationUsed(localPath, candidate)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = os.Lstat(filepath.Join(s.rootDir, localPath, candidate))
+		if !used && errors.Is(err, fs.ErrNotExist) {
+			if candidate != filename {
+				s.logger.Info.Printf("'%s' is taken, using '%s'", filepath.Join(localPath, filename), candidate)
+			}
+			return candidate, nil
+		}
+		candidate = generateNewFilename(counter, filename)
+	}
+}
+
+func (s *ReconcileService) removeFile(item database.MediaItem) error {
+	err := os.Remove(filepath.Join(s.rootDir, item.LocalPath, item.LocalFilename))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	removeEmptyDirs(s.rootDir, item.LocalPath)
+	return s.db.MediaItems.MarkAsNotDownloaded(item.Uuid, "")
+}
+
+func isTrashed(item database.MediaItem) bool {
+	return item.LocalPath == TrashDir || strings.HasPrefix(item.LocalPath, TrashDir+string(os.PathSeparator))
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func createReconcileService(t *testing.T, downloader googlephotos.Downloader, policy DeletionPolicy) ReconcileService {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateDeletionPolicy(string(policy)))
+	return NewReconcileService(downloader, db, &mockQueuer{}, layout.Default, t.TempDir(), nil, db.Logger)
+}
+
+func listing(remoteIds ...string) *mockDownloader {
+	return &mockDownloader{
+		list: func(options models.PagingOptions) (models.MediaItems, error) {
+			return albumSearchResult(remoteIds...), nil
+		},
+	}
+}
+
+func saveReconcileItems(t *testing.T, service ReconcileService) (kept database.MediaItem, deleted database.MediaItem) {
+	kept = database.CreateTestMediaItem(t)
+	deleted = database.CreateTestMediaItem(t)
+	shared := database.CreateTestMediaItem(t)
+	shared.Source = database.SourceShared
+	assert.NoError(t, service.db.MediaItems.Save(&kept, &deleted, &shared))
+	for _, item := range []database.MediaItem{kept, deleted, shared} {
+		writeLibraryFile(t, service.rootDir, item, item.Uuid)
+	}
+	return
+}
+
+func TestReconcileService_KeepsFilesOfDeletedItems(t *testing.T) {
+	service := createReconcileService(t, nil, KeepDeleted)
+	kept, deleted := saveReconcileItems(t, service)
+	service.api = listing(kept.RemoteId)
+
+	report, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileReport{Listed: 1, Deleted: 1}, report)
+
+	dbItem, err := service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.DeletedAt.IsZero())
+	assert.True(t, dbItem.Downloaded)
+	_, err = os.Stat(filepath.Join(service.rootDir, deleted.LocalPath, deleted.LocalFilename))
+	assert.NoError(t, err)
+
+	dbItem, err = service.db.MediaItems.Get(kept.Uuid)
+	assert.NoError(t, err)
+	assert.True(t, dbItem.DeletedAt.IsZero())
+}
+
+func TestReconcileService_RemovesFilesOfDeletedItems(t *testing.T) {
+	service := createReconcileService(t, nil, RemoveDeleted)
+	kept, deleted := saveReconcileItems(t, service)
+	service.api = listing(kept.RemoteId)
+
+	_, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+
+	dbItem, err := service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+	_, err = os.Stat(filepath.Join(service.rootDir, deleted.LocalPath, deleted.LocalFilename))
+	assert.True(t, os.IsNotExist(err))
+
+	// the file of the item that wasn't deleted shares the directory, so it stays
+	_, err = os.Stat(filepath.Join(service.rootDir, kept.LocalPath, kept.LocalFilename))
+	assert.NoError(t, err)
+}
+
+func TestReconcileService_TrashesAndPurgesDeletedItems(t *testing.T) {
+	service := createReconcileService(t, nil, TrashDeleted)
+	assert.NoError(t, service.db.Settings.UpdateTrashRetentionDays(7))
+	kept, deleted := saveReconcileItems(t, service)
+	service.api = listing(kept.RemoteId)
+
+	_, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+
+	trashedPath := filepath.Join(service.rootDir, TrashDir, deleted.LocalPath, deleted.LocalFilename)
+	content, err := os.ReadFile(trashedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted.Uuid, string(content))
+
+	dbItem, err := service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(TrashDir, deleted.LocalPath), dbItem.LocalPath)
+
+	// still within the retention period
+	report, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileReport{Listed: 1}, report)
+
+	service.now = func() time.Time { return time.Now().AddDate(0, 0, 8) }
+	report, err = service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Purged)
+
+	_, err = os.Stat(trashedPath)
+	assert.True(t, os.IsNotExist(err))
+	dbItem, err = service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+}
+
+func TestReconcileService_RestoresItemsBackInGooglePhotos(t *testing.T) {
+	service := createReconcileService(t, nil, TrashDeleted)
+	kept, deleted := saveReconcileItems(t, service)
+
+	service.api = listing(kept.RemoteId)
+	_, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+
+	service.api = listing(kept.RemoteId, deleted.RemoteId)
+	report, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Restored)
+
+	dbItem, err := service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.True(t, dbItem.DeletedAt.IsZero())
+	assert.Equal(t, deleted.LocalPath, dbItem.LocalPath)
+
+	content, err := os.ReadFile(filepath.Join(service.rootDir, deleted.LocalPath, deleted.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, deleted.Uuid, string(content))
+	_, err = os.Stat(filepath.Join(service.rootDir, TrashDir))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReconcileService_RestoresItemsWhoseLocationWasTaken(t *testing.T) {
+	service := createReconcileService(t, nil, TrashDeleted)
+	kept, deleted := saveReconcileItems(t, service)
+	purged := database.CreateTestMediaItem(t)
+	assert.NoError(t, service.db.MediaItems.Save(&purged))
+	writeLibraryFile(t, service.rootDir, purged, purged.Uuid)
+
+	service.api = listing(kept.RemoteId)
+	_, err := service.Reconcile(context.Background(), true)
+	assert.NoError(t, err)
+	purgedItem, err := service.db.MediaItems.Get(purged.Uuid)
+	assert.NoError(t, err)
+	assert.NoError(t, service.removeFile(purgedItem))
+
+	// media items indexed meanwhile were downloaded to the original locations
+	newcomer := database.CreateTestMediaItem(t)
+	newcomer.LocalFilename = deleted.LocalFilename
+	other := database.CreateTestMediaItem(t)
+	other.LocalFilename = purged.LocalFilename
+	assert.NoError(t, service.db.MediaItems.Save(&newcomer, &other))
+	writeLibraryFile(t, service.rootDir, newcomer, newcomer.Uuid)
+
+	service.api = listing(kept.RemoteId, deleted.RemoteId, purged.RemoteId, newcomer.RemoteId, other.RemoteId)
+	report, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Restored)
+
+	content, err := os.ReadFile(filepath.Join(service.rootDir, newcomer.LocalPath, newcomer.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, newcomer.Uuid, string(content))
+
+	restoredName := generateNewFilename(2, deleted.LocalFilename)
+	dbItem, err := service.db.MediaItems.Get(deleted.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, deleted.LocalPath, dbItem.LocalPath)
+	assert.Equal(t, restoredName, dbItem.LocalFilename)
+	content, err = os.ReadFile(filepath.Join(service.rootDir, deleted.LocalPath, restoredName))
+	assert.NoError(t, err)
+	assert.Equal(t, deleted.Uuid, string(content))
+
+	dbItem, err = service.db.MediaItems.Get(purged.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, purged.LocalPath, dbItem.LocalPath)
+	assert.Equal(t, generateNewFilename(2, purged.LocalFilename), dbItem.LocalFilename)
+}
+
+func TestReconcileService_IndexesMissedItems(t *testing.T) {
+	lateUpload := createMediaItem(t)
+	lateUpload.Metadata.CreationTime = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	existing := database.CreateTestMediaItem(t)
+
+	downloader := mockDownloader{}
+	downloader.list = func(options models.PagingOptions) (models.MediaItems, error) {
+		if options.Token == "" {
+			items := albumSearchResult(existing.RemoteId)
+			items.NextPageToken = "next"
+			return items, nil
+		}
+		return models.MediaItems{MediaItems: []models.MediaItem{lateUpload}}, nil
+	}
+
+	queuer := mockQueuer{}
+	db := database.CreateTestDatabase(t)
+	service := NewReconcileService(&downloader, db, &queuer, layout.Default, t.TempDir(), nil, db.Logger)
+	assert.NoError(t, db.MediaItems.Save(&existing))
+
+	report, err := service.Reconcile(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileReport{Listed: 2, Added: 1}, report)
+
+	items, err := db.MediaItems.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, lateUpload.Id, items[1].RemoteId)
+	assert.Equal(t, filepath.Join("2015", "06", "01"), items[1].LocalPath)
+	assert.Equal(t, []string{items[1].Uuid}, queuer.queuedIds)
+
+	lastFullSync, err := db.Settings.LastFullSync()
+	assert.NoError(t, err)
+	assert.False(t, lastFullSync.IsZero())
+}
+
+func TestReconcileService_IsDueAfterFullSyncInterval(t *testing.T) {
+	service := createReconcileService(t, nil, KeepDeleted)
+
+	due, err := service.Due()
+	assert.NoError(t, err)
+	assert.True(t, due)
+
+	assert.NoError(t, service.db.Settings.UpdateLastFullSync(time.Now()))
+	due, err = service.Due()
+	assert.NoError(t, err)
+	assert.False(t, due)
+
+	service.now = func() time.Time { return time.Now().AddDate(0, 0, 7) }
+	due, err = service.Due()
+	assert.NoError(t, err)
+	assert.True(t, due)
+
+	assert.NoError(t, service.db.Settings.UpdateFullSyncDays(0))
+	due, err = service.Due()
+	assert.NoError(t, err)
+	assert.False(t, due)
+}
+
+func TestReconcileService_RefusesEmptyListing(t *testing.T) {
+	service := createReconcileService(t, listing(), RemoveDeleted)
+	saveReconcileItems(t, service)
+
+	_, err := service.Reconcile(context.Background(), false)
+	assert.EqualError(t, err, "google photos listed no media items, refusing to mark the whole library as deleted")
+
+	counts, err := service.db.MediaItems.Counts(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, counts.Deleted)
+}
+
+func TestReconcileService_RefusesToDeleteMostOfTheLibraryUnlessForced(t *testing.T) {
+	service := createReconcileService(t, nil, RemoveDeleted)
+	kept, deleted := saveReconcileItems(t, service)
+	other := database.CreateTestMediaItem(t)
+	assert.NoError(t, service.db.MediaItems.Save(&other))
+	writeLibraryFile(t, service.rootDir, other, other.Uuid)
+	service.api = listing(kept.RemoteId)
+
+	_, err := service.Reconcile(context.Background(), false)
+	assert.EqualError(t, err, "2 of 3 media items are missing from the listing of google photos, refusing to mark more than 50% of the library as deleted, reconcile with -force if they were deleted")
+
+	counts, err := service.db.MediaItems.Counts(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, counts.Deleted)
+	assert.FileExists(t, filepath.Join(service.rootDir, deleted.LocalPath, deleted.LocalFilename))
+
+	report, err := service.Reconcile(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+	assert.NoFileExists(t, filepath.Join(service.rootDir, deleted.LocalPath, deleted.LocalFilename))
+}
+
+func TestParseDeletionPolicy(t *testing.T) {
+	policy, err := ParseDeletionPolicy("trash")
+	assert.NoError(t, err)
+	assert.Equal(t, TrashDeleted, policy)
+
+	_, err = ParseDeletionPolicy("archive")
+	assert.EqualError(t, err, "unknown deletion policy 'archive'")
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// relayoutDir holds files, relative to the library root, while they are being moved
+const relayoutDir = ".relayout"
+
+type RelayoutMove struct {
+	Item database.MediaItem
+	// LocalPath and LocalFilename are where the media item is moved to
+	LocalPath     string
+	LocalFilename string
+}
+
+func (m RelayoutMove) From() string {
+	return filepath.Join(m.Item.LocalPath, m.Item.LocalFilename)
+}
+
+func (m RelayoutMove) To() string {
+	return filepath.Join(m.LocalPath, m.LocalFilename)
+}
+
+type RelayoutPlan struct {
+	Template  layout.Template
+	Moves     []RelayoutMove
+	Unchanged int
+}
+
+type RelayoutService struct {
+	db      database.PhotoDatabase
+	logger  utils.Logger
+	rootDir string
+}
+
+func NewRelayoutService(db database.PhotoDatabase, rootDir string, logger utils.Logger) RelayoutService {
+	return RelayoutService{db: db, rootDir: rootDir, logger: logger}
+}
+
+// Plan works out where every media item ends up with the given template. Clashing filenames are
+// renamed the same way as when media items are indexed
+func (s *RelayoutService) Plan(template layout.Template) (plan RelayoutPlan, err error) {
+	plan.Template = template
+	items, err := s.db.MediaItems.GetAll()
+	if err != nil {
+		return
+	}
+
+	// files that will be moved out of the way don't block other files moving in
+	currentFiles := map[string]bool{}
+	for _, item := range items {
+		if item.Downloaded {
+			currentFiles[filepath.Join(item.LocalPath, item.LocalFilename)] = true
+		}
+	}
+
+	// files of deleted media items stay where the deletion policy put them, so their locations can't
+	// be moved into
+	claimed := map[string]bool{}
+	for _, item := range items {
+		if !item.DeletedAt.IsZero() {
+			claimed[filepath.Join(item.LocalPath, item.LocalFilename)] = true
+		}
+	}
+
+	for _, item := range items {
+		if !item.DeletedAt.IsZero() {
+			continue
+		}
+
+		var album string
+		if template.UsesAlbum() {
+			album, err = s.db.Albums.TitleForMediaItem(item.RemoteId)
+			if err != nil {
+				return
+			}
+		}
+
+		localPath, filename := template.Render(layoutFields(item, album))
+		localFilename := filename
+		for counter := 2; s.isTaken(filepath.Join(localPath, localFilename), claimed, currentFiles); counter++ {
+			localFilename = generateNewFilename(counter, filename)
+		}
+		claimed[filepath.Join(localPath, localFilename)] = true
+
+		if localPath == item.LocalPath && localFilename == item.LocalFilename {
+			plan.Unchanged++
+			continue
+		}
+		plan.Moves = append(plan.Moves, RelayoutMove{Item: item, LocalPath: localPath, LocalFilename: localFilename})
+	}
+	return
+}
+
+func (s *RelayoutService) isTaken(relativePath string, claimed map[string]bool, currentFiles map[string]bool) bool {
+	if claimed[relativePath] {
+		return true
+	}
+	if currentFiles[relativePath] {
+		return false
+	}
+
+	// a file the library doesn't know about
+	_, err := os.Lstat(filepath.Join(s.rootDir, relativePath))
+	return err == nil
+}
+
+type fileMove struct {
+	from string
+	to   string
+}
+
+// Apply moves the files of a plan and updates the database. Files are moved back if the database
+// can't be updated, so the library is either fully laid out with the new template or not at all
+func (s *RelayoutService) Apply(plan RelayoutPlan) (err error) {
+	var moved []fileMove
+	err = s.db.Transaction(func(tx database.PhotoDatabase) error {
+		txErr := s.updateLocations(tx, plan)
+		if txErr != nil {
+			return txErr
+		}
+		return s.moveFiles(plan, &moved)
+	})
+
+	if err != nil {
+		s.undoMoves(moved)
+	}
+
+	removeErr := os.RemoveAll(filepath.Join(s.rootDir, relayoutDir))
+	if removeErr != nil {
+		s.logger.Error.Printf("failed to remove '%s': %s", relayoutDir, removeErr)
+	}
+
+	if err == nil {
+		for _, move := range plan.Moves {
+			removeEmptyDirs(s.rootDir, move.Item.LocalPath)
+		}
+	}
+	return
+}
+
+func (s *RelayoutService) updateLocations(tx database.PhotoDatabase, plan RelayoutPlan) error {
+	// locations have to be unique, so everything is moved out of the way before being moved in
+	for _, move := range plan.Moves {
+		err := tx.MediaItems.UpdateLocation(move.Item.Uuid, relayoutDir, move.Item.Uuid)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, move := range plan.Moves {
+		err := tx.MediaItems.UpdateLocation(move.Item.Uuid, move.LocalPath, move.LocalFilename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Settings.UpdateLayoutTemplate(plan.Template.String())
+}
+
+func (s *RelayoutService) moveFiles(plan RelayoutPlan, moved *[]fileMove) error {
+	stagingDir := filepath.Join(s.rootDir, relayoutDir)
+	err := os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var staged []RelayoutMove
+	for _, move := range plan.Moves {
+		if !move.Item.Downloaded {
+			continue
+		}
+
+		from := filepath.Join(s.rootDir, move.From())
+		_, err = os.Lstat(from)
+		if errors.Is(err, fs.ErrNotExist) {
+			s.logger.Error.Printf("'%s' is missing, only updating its location", move.From())
+			continue
+		}
+
+		err = s.move(from, filepath.Join(stagingDir, move.Item.Uuid), moved)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, move)
+	}
+
+	for _, move := range staged {
+		s.logger.Debug.Printf("moving '%s' to '%s'", move.From(), move.To())
+		to := filepath.Join(s.rootDir, move.To())
+		err = os.MkdirAll(filepath.Dir(to), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = s.move(filepath.Join(stagingDir, move.Item.Uuid), to, moved)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RelayoutService) move(from string, to string, moved *[]fileMove) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		*moved = append(*moved, fileMove{from: from, to: to})
+	}
+	return err
+}
+
+func (s *RelayoutService) undoMoves(moved []fileMove) {
+	for i := len(moved) - 1; i >= 0; i-- {
+		err := os.Rename(moved[i].to, moved[i].from)
+		if err != nil {
+			s.logger.Error.Printf("failed to move '%s' back to '%s': %s", moved[i].to, moved[i].from, err)
+		}
+	}
+}
+
+// removeEmptyDirs removes a directory left empty by moving files out of it, and its empty parents
+func removeEmptyDirs(rootDir string, localPath string) {
+	for localPath != "" && localPath != "." {
+		err := os.Remove(filepath.Join(rootDir, localPath))
+		if err != nil {
+			return
+		}
+		localPath = filepath.Dir(localPath)
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/stretchr/testify/assert"
+)
+
+func createRelayoutService(t *testing.T) RelayoutService {
+	db := database.CreateTestDatabase(t)
+	return NewRelayoutService(db, t.TempDir(), db.Logger)
+}
+
+func saveLibraryItems(t *testing.T, service RelayoutService, items ...*database.MediaItem) {
+	assert.NoError(t, service.db.MediaItems.Save(items...))
+	for _, item := range items {
+		if item.Downloaded {
+			writeLibraryFile(t, service.rootDir, *item, item.Uuid)
+		}
+	}
+}
+
+func TestRelayoutService_MovesFilesAndUpdatesDatabase(t *testing.T) {
+	service := createRelayoutService(t)
+	first := database.CreateTestMediaItem(t)
+	second := database.CreateTestMediaItem(t)
+	second.LocalPath = "2012/12/13"
+	second.Filename = first.Filename
+	second.LocalFilename = first.LocalFilename
+	notDownloaded := database.CreateTestMediaItem(t)
+	notDownloaded.Downloaded = false
+	saveLibraryItems(t, service, &first, &second, &notDownloaded)
+
+	template := layout.MustParse("{year}/{filename}")
+	plan, err := service.Plan(template)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Moves, 3)
+	assert.Equal(t, filepath.Join("2012", first.LocalFilename), plan.Moves[0].To())
+	assert.Equal(t, filepath.Join("2012", generateNewFilename(2, first.LocalFilename)), plan.Moves[1].To())
+
+	// planning doesn't touch anything
+	_, err = os.Stat(filepath.Join(service.rootDir, first.LocalPath, first.LocalFilename))
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.Apply(plan))
+
+	for _, move := range plan.Moves {
+		dbItem, err := service.db.MediaItems.Get(move.Item.Uuid)
+		assert.NoError(t, err)
+		assert.Equal(t, move.LocalPath, dbItem.LocalPath)
+		assert.Equal(t, move.LocalFilename, dbItem.LocalFilename)
+	}
+
+	content, err := os.ReadFile(filepath.Join(service.rootDir, plan.Moves[1].To()))
+	assert.NoError(t, err)
+	assert.Equal(t, second.Uuid, string(content))
+
+	_, err = os.Stat(filepath.Join(service.rootDir, "2012", "12"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(service.rootDir, relayoutDir))
+	assert.True(t, os.IsNotExist(err))
+
+	stored, err := service.db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, template.String(), stored)
+
+	// the library is already laid out with the template
+	plan, err = service.Plan(template)
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Moves)
+	assert.Equal(t, 3, plan.Unchanged)
+}
+
+func TestRelayoutService_DoesNotMoveOntoFilesOfDeletedItems(t *testing.T) {
+	service := createRelayoutService(t)
+	// kept by the keep deletion policy, where the live media item would be moved to
+	deleted := database.CreateTestMediaItem(t)
+	deleted.LocalPath = "2012"
+	deleted.DeletedAt = deleted.CreatedAt
+	item := database.CreateTestMediaItem(t)
+	item.Filename = deleted.LocalFilename
+	item.LocalFilename = deleted.LocalFilename
+	saveLibraryItems(t, service, &deleted, &item)
+
+	plan, err := service.Plan(layout.MustParse("{year}/{filename}"))
+	assert.NoError(t, err)
+	assert.Len(t, plan.Moves, 1)
+	assert.Equal(t, item.Uuid, plan.Moves[0].Item.Uuid)
+	assert.Equal(t, filepath.Join("2012", generateNewFilename(2, item.LocalFilename)), plan.Moves[0].To())
+
+	assert.NoError(t, service.Apply(plan))
+	content, err := os.ReadFile(filepath.Join(service.rootDir, "2012", deleted.LocalFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, deleted.Uuid, string(content))
+}
+
+func TestRelayoutService_DoesNotOverwriteUnknownFiles(t *testing.T) {
+	service := createRelayoutService(t)
+	item := database.CreateTestMediaItem(t)
+	saveLibraryItems(t, service, &item)
+
+	stray := item
+	stray.LocalPath = "2012"
+	writeLibraryFile(t, service.rootDir, stray, "stray")
+
+	plan, err := service.Plan(layout.MustParse("{year}/{filename}"))
+	assert.NoError(t, err)
+	assert.Len(t, plan.Moves, 1)
+	assert.Equal(t, generateNewFilename(2, item.LocalFilename), plan.Moves[0].LocalFilename)
+}
+
+func TestRelayoutService_RestoresFilesWhenMoveFails(t *testing.T) {
+	service := createRelayoutService(t)
+	first := database.CreateTestMediaItem(t)
+	second := database.CreateTestMediaItem(t)
+	second.CreatedAt = second.CreatedAt.AddDate(1, 0, 0)
+	saveLibraryItems(t, service, &first, &second)
+
+	// a file where a directory has to be created
+	assert.NoError(t, os.WriteFile(filepath.Join(service.rootDir, "2013"), []byte{}, 0644))
+
+	plan, err := service.Plan(layout.MustParse("{year}/{filename}"))
+	assert.NoError(t, err)
+	assert.Error(t, service.Apply(plan))
+
+	for _, item := range []database.MediaItem{first, second} {
+		dbItem, err := service.db.MediaItems.Get(item.Uuid)
+		assert.NoError(t, err)
+		assert.Equal(t, item.LocalPath, dbItem.LocalPath)
+
+		content, err := os.ReadFile(filepath.Join(service.rootDir, item.LocalPath, item.LocalFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, item.Uuid, string(content))
+	}
+
+	stored, err := service.db.Settings.LayoutTemplate()
+	assert.NoError(t, err)
+	assert.Equal(t, "", stored)
+}
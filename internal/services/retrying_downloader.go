@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// defaultQuotaPause is how long every caller waits when the api signals quota exhaustion without
+// saying for how long
+const defaultQuotaPause = 30 * time.Second
+
+// Backoff is shared by everything calling the api. Once the api signals quota exhaustion to one
+// caller, every caller waits, instead of each one running into the limit on its own
+type Backoff struct {
+	mutex sync.Mutex
+	until time.Time
+	now   func() time.Time
+}
+
+func NewBackoff() *Backoff {
+	return &Backoff{now: time.Now}
+}
+
+// Pause holds off every caller for at least wait
+func (b *Backoff) Pause(wait time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until := b.now().Add(wait)
+	if until.After(b.until) {
+		b.until = until
+	}
+}
+
+// Wait blocks until the backoff has passed or ctx is done
+func (b *Backoff) Wait(ctx context.Context) error {
+	b.mutex.Lock()
+	wait := b.until.Sub(b.now())
+	b.mutex.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryingDownloader retries api calls that fail with errors worth retrying, and shares the
+// backoff asked for by the api between all callers. Downloads aren't retried here, the download
+// job retries them so it can resume partial files
+type retryingDownloader struct {
+	api          googlephotos.Downloader
+	retryFactory RetryFactory
+	backoff      *Backoff
+	logger       utils.Logger
+}
+
+func NewRetryingDownloader(api googlephotos.Downloader, retryFactory RetryFactory, backoff *Backoff, logger utils.Logger) googlephotos.Downloader {
+	return &retryingDownloader{api: api, retryFactory: retryFactory, backoff: backoff, logger: logger}
+}
+
+func (r *retryingDownloader) Get(ctx context.Context, mediaItemId string) (mediaItem models.MediaItem, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		mediaItem, callErr = r.api.Get(ctx, mediaItemId)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) BatchGet(ctx context.Context, mediaItemIds []string) (mediaItems models.MediaItemsResult, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		mediaItems, callErr = r.api.BatchGet(ctx, mediaItemIds)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) List(ctx context.Context, options models.PagingOptions) (mediaItems models.MediaItems, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		mediaItems, callErr = r.api.List(ctx, options)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) Search(ctx context.Context, options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		mediaItems, callErr = r.api.Search(ctx, options)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) ListAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		albums, callErr = r.api.ListAlbums(ctx, options)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) ListSharedAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	err = r.retry(ctx, func() (callErr error) {
+		albums, callErr = r.api.ListSharedAlbums(ctx, options)
+		return
+	})
+	return
+}
+
+func (r *retryingDownloader) Download(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
+	err = r.backoff.Wait(ctx)
+	if err != nil {
+		return
+	}
+
+	download, err = r.api.Download(ctx, partial, baseUrl, isPhoto)
+	r.pauseOnQuota(err)
+	return
+}
+
+func (r *retryingDownloader) retry(ctx context.Context, call func() error) error {
+	tracker := r.retryFactory.Create()
+	for {
+		err := r.backoff.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = call()
+		if err == nil {
+			return nil
+		}
+
+		r.pauseOnQuota(err)
+		if ctx.Err() != nil || !tracker.ShouldRetry(err) {
+			return err
+		}
+		r.logger.Debug.Printf("retrying api call after error: %s", err)
+		err = tracker.Wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *retryingDownloader) pauseOnQuota(err error) {
+	var apiError models.ApiError
+	if err == nil || !errors.As(err, &apiError) || !apiError.IsQuotaExhausted() {
+		return
+	}
+
+	wait, ok := apiError.RetryAfter(r.backoff.now())
+	if !ok {
+		wait = defaultQuotaPause
+	}
+	r.logger.Info.Printf("google photos asked to slow down, pausing api calls for %s", wait)
+	r.backoff.Pause(wait)
+}
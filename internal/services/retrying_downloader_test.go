@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func quotaError(retryAfter string) models.ApiError {
+	headers := http.Header{}
+	if retryAfter != "" {
+		headers.Set("Retry-After", retryAfter)
+	}
+	return models.ApiError{StatusCode: http.StatusTooManyRequests, Headers: headers}
+}
+
+func createRetryingDownloader(api *mockDownloader, backoff *Backoff) *retryingDownloader {
+	factory := ExponentialRetryFactory{
+		baseTimeInSeconds: 1,
+		sideEffects:       &mockSleeper{},
+		maxTimeInSeconds:  30,
+		classifier:        DefaultErrorClassifier{},
+	}
+	return NewRetryingDownloader(api, factory, backoff, utils.NewLogger(utils.Silent)).(*retryingDownloader)
+}
+
+func TestRetryingDownloader_RetriesListWhenApiAsksToSlowDown(t *testing.T) {
+	calls := 0
+	api := &mockDownloader{list: func(options models.PagingOptions) (models.MediaItems, error) {
+		calls++
+		if calls == 1 {
+			return models.MediaItems{}, quotaError("0")
+		}
+		return models.MediaItems{MediaItems: []models.MediaItem{{Id: "1"}}}, nil
+	}}
+	downloader := createRetryingDownloader(api, NewBackoff())
+
+	mediaItems, err := downloader.List(context.Background(), models.PagingOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, mediaItems.MediaItems, 1)
+}
+
+func TestRetryingDownloader_DoesNotRetryOtherApiErrors(t *testing.T) {
+	api := &mockDownloader{batchGet: func(mediaItemIds []string) (models.MediaItemsResult, error) {
+		return models.MediaItemsResult{}, models.ApiError{StatusCode: http.StatusBadRequest}
+	}}
+	downloader := createRetryingDownloader(api, NewBackoff())
+
+	_, err := downloader.BatchGet(context.Background(), []string{"1"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, api.batchGetCallCount)
+}
+
+func TestRetryingDownloader_QuotaExhaustionPausesOtherCallers(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	backoff := &Backoff{now: func() time.Time { return now }}
+	api := &mockDownloader{download: func(_ context.Context, _ models.PartialDownload, _ string, _ bool) (models.DownloadedFile, error) {
+		return models.DownloadedFile{}, quotaError("")
+	}}
+	downloader := createRetryingDownloader(api, backoff)
+
+	_, err := downloader.Download(context.Background(), models.PartialDownload{}, "http://example.com", true)
+	assert.Error(t, err)
+	assert.Equal(t, now.Add(defaultQuotaPause), backoff.until)
+
+	// a caller that hasn't seen the error waits out the pause too
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = downloader.Search(ctx, models.SearchOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, api.downloadCallCount)
+}
+
+func TestBackoff_PauseKeepsLongestWait(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	backoff := &Backoff{now: func() time.Time { return now }}
+
+	backoff.Pause(time.Minute)
+	backoff.Pause(time.Second)
+	assert.Equal(t, now.Add(time.Minute), backoff.until)
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when the daemon runs. It is written either as an interval like '30m' or '6h',
+// counted from the start of the previous pass, or as a cron expression with the five fields
+// minute, hour, day of month, month and day of week, e.g. '0 3 * * *' for 3am every day
+type Schedule struct {
+	text     string
+	interval time.Duration
+	cron     *cronExpression
+}
+
+func ParseSchedule(text string) (Schedule, error) {
+	text = strings.TrimSpace(text)
+	if interval, err := time.ParseDuration(text); err == nil {
+		if interval < time.Minute {
+			return Schedule{}, fmt.Errorf("schedule interval '%s' must be at least a minute", text)
+		}
+		return Schedule{text: text, interval: interval}, nil
+	}
+
+	cron, err := parseCron(text)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return Schedule{text: text, cron: cron}, nil
+}
+
+// Next returns when the pass after one started at previous is due
+func (s Schedule) Next(previous time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.next(previous)
+	}
+	return previous.Add(s.interval)
+}
+
+func (s Schedule) String() string {
+	return s.text
+}
+
+func (s Schedule) MarshalText() ([]byte, error) {
+	return []byte(s.text), nil
+}
+
+func (s *Schedule) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseSchedule(string(text))
+	return
+}
+
+// cronExpression holds the allowed values of each field, indexed by value
+type cronExpression struct {
+	minutes     []bool
+	hours       []bool
+	daysOfMonth []bool
+	months      []bool
+	daysOfWeek  []bool
+	// like cron, when both days are restricted a day matching either of them is due
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+func parseCron(text string) (*cronExpression, error) {
+	fields := strings.Fields(text)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule '%s', expected an interval like 1h or a cron expression with 5 fields", text)
+	}
+
+	values := make([][]bool, len(cronFields))
+	for i, field := range cronFields {
+		allowed, err := parseCronField(fields[i], field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", text, err)
+		}
+		values[i] = allowed
+	}
+
+	// sunday is both 0 and 7
+	values[4][0] = values[4][0] || values[4][7]
+	return &cronExpression{
+		minutes:       values[0],
+		hours:         values[1],
+		daysOfMonth:   values[2],
+		months:        values[3],
+		daysOfWeek:    values[4],
+		anyDayOfMonth: allowsAll(values[2], cronFields[2].min, cronFields[2].max),
+		anyDayOfWeek:  allowsAll(values[4], 0, 6),
+	}, nil
+}
+
+// allowsAll reports whether every value from min to max is allowed, like cron a field such as */1
+// counts as '*'
+func allowsAll(allowed []bool, min int, max int) bool {
+	for value := min; value <= max; value++ {
+		if !allowed[value] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCronField parses a comma separated list of '*', values and ranges, each with an optional step
+func parseCronField(text string, field cronField) ([]bool, error) {
+	allowed := make([]bool, field.max+1)
+	for _, part := range strings.Split(text, ",") {
+		values, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%s' in %s", stepText, field.name)
+			}
+		}
+
+		start, end := field.min, field.max
+		if values != "*" {
+			first, last, isRange := strings.Cut(values, "-")
+			var err error
+			start, err = parseCronValue(first, field)
+			if err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				end, err = parseCronValue(last, field)
+				if err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				end = field.max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range '%s' in %s", values, field.name)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			allowed[value] = true
+		}
+	}
+	return allowed, nil
+}
+
+func parseCronValue(text string, field cronField) (int, error) {
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("invalid %s '%s', expected %d to %d", field.name, text, field.min, field.max)
+	}
+	return value, nil
+}
+
+func (c *cronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	dayOfWeek := c.daysOfWeek[int(t.Weekday())]
+	switch {
+	case c.anyDayOfMonth && c.anyDayOfWeek:
+		return true
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first minute after t the expression matches, in the location of t. An
+// expression that never matches, like the 31st of February, gives up after a few years
+func (c *cronExpression) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return limit
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_IntervalCountsFromPreviousStart(t *testing.T) {
+	schedule, err := ParseSchedule("90m")
+	assert.NoError(t, err)
+
+	started := time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, started.Add(90*time.Minute), schedule.Next(started))
+	assert.Equal(t, "90m", schedule.String())
+}
+
+func TestSchedule_CronNext(t *testing.T) {
+	// friday
+	from := time.Date(2021, 12, 3, 12, 34, 56, 0, time.UTC)
+
+	type testCase struct {
+		expression string
+		expected   time.Time
+	}
+	testCases := []testCase{
+		{expression: "* * * * *", expected: time.Date(2021, 12, 3, 12, 35, 0, 0, time.UTC)},
+		{expression: "0 3 * * *", expected: time.Date(2021, 12, 4, 3, 0, 0, 0, time.UTC)},
+		{expression: "*/15 * * * *", expected: time.Date(2021, 12, 3, 12, 45, 0, 0, time.UTC)},
+		{expression: "0 */6 * * *", expected: time.Date(2021, 12, 3, 18, 0, 0, 0, time.UTC)},
+		{expression: "30 1 * * 0", expected: time.Date(2021, 12, 5, 1, 30, 0, 0, time.UTC)},
+		{expression: "30 1 * * 7", expected: time.Date(2021, 12, 5, 1, 30, 0, 0, time.UTC)},
+		{expression: "0 9 * * 1-5", expected: time.Date(2021, 12, 6, 9, 0, 0, 0, time.UTC)},
+		{expression: "0 0 1 * *", expected: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 29 2 *", expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expression: "0,30 22 * 12 *", expected: time.Date(2021, 12, 3, 22, 0, 0, 0, time.UTC)},
+		// either day matches when both are restricted
+		{expression: "0 0 10 * 6", expected: time.Date(2021, 12, 4, 0, 0, 0, 0, time.UTC)},
+		// a day field allowing every value is unrestricted, however it is written
+		{expression: "0 3 */1 * 1", expected: time.Date(2021, 12, 6, 3, 0, 0, 0, time.UTC)},
+		{expression: "0 3 1-31 * 1", expected: time.Date(2021, 12, 6, 3, 0, 0, 0, time.UTC)},
+		{expression: "0 0 10 * 0-6", expected: time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			schedule, err := ParseSchedule(tc.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, schedule.Next(from))
+		})
+	}
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	type testCase struct {
+		schedule string
+		expected string
+	}
+	testCases := []testCase{
+		{schedule: "10s", expected: "schedule interval '10s' must be at least a minute"},
+		{schedule: "daily", expected: "invalid schedule 'daily', expected an interval like 1h or a cron expression with 5 fields"},
+		{schedule: "60 * * * *", expected: "invalid schedule '60 * * * *': invalid minute '60', expected 0 to 59"},
+		{schedule: "* * * * 1-x", expected: "invalid schedule '* * * * 1-x': invalid day of week 'x', expected 0 to 7"},
+		{schedule: "*/0 * * * *", expected: "invalid schedule '*/0 * * * *': invalid step '0' in minute"},
+		{schedule: "* 5-2 * * *", expected: "invalid schedule '* 5-2 * * *': invalid range '5-2' in hour"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.schedule, func(t *testing.T) {
+			_, err := ParseSchedule(tc.schedule)
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestSchedule_TextRoundTrip(t *testing.T) {
+	var schedule Schedule
+	assert.NoError(t, schedule.UnmarshalText([]byte("0 3 * * *")))
+
+	text, err := schedule.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "0 3 * * *", string(text))
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// failureBackoff is how much later than scheduled the pass after a failure runs at least, it doubles
+// with every failure in a row up to maxFailureBackoff
+const (
+	failureBackoff    = 5 * time.Minute
+	maxFailureBackoff = 6 * time.Hour
+)
+
+// Scheduler runs passes on a schedule until ctx is done. A pass failing doesn't stop the scheduler,
+// unless isFatal says trying again won't help, but passes after repeated failures are held back
+type Scheduler struct {
+	schedule Schedule
+	isFatal  func(err error) bool
+	logger   utils.Logger
+	now      func() time.Time
+	after    func(d time.Duration) <-chan time.Time
+}
+
+func NewScheduler(schedule Schedule, isFatal func(err error) bool, logger utils.Logger) Scheduler {
+	return Scheduler{schedule: schedule, isFatal: isFatal, logger: logger, now: time.Now, after: time.After}
+}
+
+// Run runs the first pass straight away and the others when they are due. It returns nil once ctx
+// is done, or the error of a fatal pass
+func (s Scheduler) Run(ctx context.Context, pass func() error) error {
+	failures := 0
+	for {
+		started := s.now()
+		err := pass()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && s.isFatal(err) {
+			return err
+		}
+
+		next := s.schedule.Next(started)
+		if err != nil {
+			failures++
+			backoff := failureBackoffAfter(failures)
+			s.logger.Error.Printf("pass failed %d times in a row, waiting at least %s: %s", failures, backoff, err)
+			if held := s.now().Add(backoff); held.After(next) {
+				next = held
+			}
+		} else {
+			failures = 0
+		}
+
+		// a pass that overran the schedule is followed by the next one straight away
+		now := s.now()
+		if next.Before(now) {
+			next = now
+		}
+		s.logger.Info.Printf("next pass at %s", next.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.after(next.Sub(now)):
+		}
+	}
+}
+
+func failureBackoffAfter(failures int) time.Duration {
+	backoff := failureBackoff
+	for i := 1; i < failures && backoff < maxFailureBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxFailureBackoff {
+		return maxFailureBackoff
+	}
+	return backoff
+}
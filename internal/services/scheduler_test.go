@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFatal = errors.New("fatal")
+
+// fakeClock moves time forward by however long the scheduler waits
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func createScheduler(t *testing.T, text string, clock *fakeClock) Scheduler {
+	schedule, err := ParseSchedule(text)
+	assert.NoError(t, err)
+
+	scheduler := NewScheduler(schedule, func(err error) bool { return errors.Is(err, errFatal) }, utils.NewLogger(utils.Silent))
+	scheduler.now = func() time.Time { return clock.now }
+	scheduler.after = clock.after
+	return scheduler
+}
+
+func TestScheduler_RunsPassesOnInterval(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)}
+	scheduler := createScheduler(t, "1h", clock)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var starts []time.Time
+	err := scheduler.Run(ctx, func() error {
+		starts = append(starts, clock.now)
+		clock.now = clock.now.Add(10 * time.Minute)
+		if len(starts) == 3 {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC),
+		time.Date(2021, 12, 3, 13, 0, 0, 0, time.UTC),
+		time.Date(2021, 12, 3, 14, 0, 0, 0, time.UTC),
+	}, starts)
+}
+
+func TestScheduler_OverrunningPassIsFollowedStraightAway(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)}
+	scheduler := createScheduler(t, "1h", clock)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	passes := 0
+	_ = scheduler.Run(ctx, func() error {
+		passes++
+		clock.now = clock.now.Add(2 * time.Hour)
+		if passes == 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.Equal(t, []time.Duration{0}, clock.waits)
+}
+
+func TestScheduler_BacksOffOnRepeatedFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)}
+	scheduler := createScheduler(t, "*/10 * * * *", clock)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	passes := 0
+	_ = scheduler.Run(ctx, func() error {
+		passes++
+		switch passes {
+		case 5:
+			return nil
+		case 7:
+			cancel()
+		}
+		return errors.New("network down")
+	})
+
+	assert.Equal(t, []time.Duration{
+		10 * time.Minute,
+		10 * time.Minute,
+		20 * time.Minute,
+		40 * time.Minute,
+		10 * time.Minute,
+		10 * time.Minute,
+	}, clock.waits)
+}
+
+func TestScheduler_StopsOnFatalError(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)}
+	scheduler := createScheduler(t, "1h", clock)
+
+	err := scheduler.Run(context.Background(), func() error {
+		return errFatal
+	})
+
+	assert.ErrorIs(t, err, errFatal)
+	assert.Empty(t, clock.waits)
+}
+
+func TestFailureBackoffAfter(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, failureBackoffAfter(1))
+	assert.Equal(t, 40*time.Minute, failureBackoffAfter(4))
+	assert.Equal(t, 6*time.Hour, failureBackoffAfter(20))
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// StagingDir is the directory, relative to the library root, that files are downloaded to before
+// being moved to their place in the library
+const StagingDir = ".staging"
+
+// tempFilePattern matches the temporary files older versions downloaded to
+const tempFilePattern = "gphoto.*.tmp"
+
+// partialFileSuffix is added to the uuid of a media item to name the file it is downloaded to
+const partialFileSuffix = ".part"
+
+type SweepReport struct {
+	Removed int
+	Bytes   int64
+}
+
+// SweepStaging removes partial downloads that haven't been resumed for longer than maxAge, along
+// with temporary files left behind when the process was killed mid download. The caller has to hold
+// the library lock and sweep before its own downloads start, so no partial download belongs to a
+// run that is still going. Older versions didn't lock the library and downloaded straight into the
+// library root, their temporary files are removed once older than maxAge too
+func SweepStaging(rootDir string, maxAge time.Duration, now time.Time, logger utils.Logger) (report SweepReport, err error) {
+	cutoff := now.Add(-maxAge)
+	stagingDir := filepath.Join(rootDir, StagingDir)
+	patterns := []string{
+		filepath.Join(stagingDir, "*"+partialFileSuffix),
+		filepath.Join(stagingDir, tempFilePattern),
+		filepath.Join(rootDir, tempFilePattern),
+	}
+	for _, pattern := range patterns {
+		var paths []string
+		paths, err = filepath.Glob(pattern)
+		if err != nil {
+			return
+		}
+
+		for _, path := range paths {
+			info, statErr := os.Lstat(path)
+			if errors.Is(statErr, fs.ErrNotExist) {
+				continue
+			}
+			if statErr != nil {
+				return report, statErr
+			}
+			if !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+				continue
+			}
+
+			logger.Debug.Printf("removing stale temporary file '%s'", path)
+			removeErr := os.Remove(path)
+			if removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+				return report, removeErr
+			}
+			report.Removed++
+			report.Bytes += info.Size()
+		}
+	}
+	return report, nil
+}
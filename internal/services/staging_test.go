@@ -0,0 +1,49 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempFileAged(t *testing.T, path string, content string, age time.Duration) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	modified := time.Now().Add(-age)
+	assert.NoError(t, os.Chtimes(path, modified, modified))
+}
+
+func TestSweepStaging_RemovesStaleTemporaryFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	stale := filepath.Join(rootDir, StagingDir, "gphoto.1.tmp")
+	legacy := filepath.Join(rootDir, "gphoto.2.tmp")
+	inProgress := filepath.Join(rootDir, StagingDir, "gphoto.3.tmp")
+	photo := filepath.Join(rootDir, "photo.tmp")
+	writeTempFileAged(t, stale, "abcd", 48*time.Hour)
+	writeTempFileAged(t, legacy, "ab", 48*time.Hour)
+	writeTempFileAged(t, inProgress, "abcd", time.Minute)
+	writeTempFileAged(t, photo, "abcd", 48*time.Hour)
+
+	report, err := SweepStaging(rootDir, 24*time.Hour, time.Now(), utils.NewLogger(utils.Silent))
+	assert.NoError(t, err)
+	assert.Equal(t, SweepReport{Removed: 2, Bytes: 6}, report)
+
+	for _, path := range []string{stale, legacy} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+	for _, path := range []string{inProgress, photo} {
+		_, err = os.Stat(path)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSweepStaging_HandlesMissingStagingDirectory(t *testing.T) {
+	report, err := SweepStaging(t.TempDir(), time.Hour, time.Now(), utils.NewLogger(utils.Silent))
+	assert.NoError(t, err)
+	assert.Equal(t, SweepReport{}, report)
+}
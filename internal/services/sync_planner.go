@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	api "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// typical sizes of photos and videos from phone cameras, the api doesn't return the size of media
+// items so these estimate downloads until the library has downloaded some of its own
+const (
+	typicalPhotoSize = 4 << 20
+	typicalVideoSize = 60 << 20
+)
+
+// PlannedDownload is a file a sync would write
+type PlannedDownload struct {
+	RemoteId      string
+	LocalPath     string
+	LocalFilename string
+	// New is set for media items the sync would index, the others were indexed by an earlier run
+	New bool
+	// Renamed is set when the filename given by the layout clashes with another media item's
+	Renamed bool
+	// Overwrites is set when a file the library doesn't know about is in the way
+	Overwrites    bool
+	EstimatedSize int64
+}
+
+func (d PlannedDownload) Path() string {
+	return filepath.Join(d.LocalPath, d.LocalFilename)
+}
+
+type SyncPlan struct {
+	// FullListing is set when the whole library is listed, rather than searched for new media items
+	FullListing bool
+	// Listed counts the media items returned by the api, AlreadyIndexed the ones among them the
+	// library knows about
+	Listed         int
+	AlreadyIndexed int
+	Downloads      []PlannedDownload
+	New            int
+	Pending        int
+	Renamed        int
+	Overwrites     int
+	EstimatedSize  int64
+}
+
+func (p *SyncPlan) add(download PlannedDownload) {
+	p.Downloads = append(p.Downloads, download)
+	if download.New {
+		p.New++
+	} else {
+		p.Pending++
+	}
+	if download.Renamed {
+		p.Renamed++
+	}
+	if download.Overwrites {
+		p.Overwrites++
+	}
+	p.EstimatedSize += download.EstimatedSize
+}
+
+// SyncPlanner works out what a sync would do without changing the library. Media items are listed
+// through the api like a sync lists them, the database and the library root are only read
+type SyncPlanner struct {
+	api           googlephotos.Downloader
+	db            database.PhotoDatabase
+	layout        layout.Template
+	rootDir       string
+	includeShared bool
+	logger        utils.Logger
+	pagingSize    int
+}
+
+func NewSyncPlanner(api googlephotos.Downloader, db database.PhotoDatabase, template layout.Template, rootDir string, includeShared bool, logger utils.Logger) SyncPlanner {
+	return SyncPlanner{api: api, db: db, layout: template, rootDir: rootDir, includeShared: includeShared, logger: logger, pagingSize: 100}
+}
+
+// Plan lists the media items created since the last index, or the whole library when it has never
+// been indexed or a full sync is due, and works out where the sync would download them. Media items
+// indexed by earlier runs but not downloaded yet are planned too. Layouts using album titles only
+// know the albums indexed so far, and media items of shared albums are only planned once a sync has
+// indexed them
+func (s *SyncPlanner) Plan(ctx context.Context) (plan SyncPlan, err error) {
+	items, err := s.db.MediaItems.GetAll()
+	if err != nil {
+		return
+	}
+
+	sizes, err := s.db.MediaItems.AverageFileSizes()
+	if err != nil {
+		return
+	}
+
+	// locations are unique across every media item, including deleted ones
+	indexed := map[string]bool{}
+	taken := map[string]bool{}
+	for _, item := range items {
+		indexed[item.RemoteId] = true
+		taken[filepath.Join(item.LocalPath, item.LocalFilename)] = true
+
+		if item.Downloaded || !item.DeletedAt.IsZero() || (item.Source == database.SourceShared && !s.includeShared) {
+			continue
+		}
+		// interrupted downloads resume where they stopped
+		size := max(estimateSize(item, sizes)-item.PartialBytes, 0)
+		plan.add(PlannedDownload{RemoteId: item.RemoteId, LocalPath: item.LocalPath, LocalFilename: item.LocalFilename, EstimatedSize: size})
+	}
+
+	process := func(mediaItems []api.MediaItem) error {
+		for _, mediaItem := range mediaItems {
+			plan.Listed++
+			if indexed[mediaItem.Id] {
+				plan.AlreadyIndexed++
+				continue
+			}
+			indexed[mediaItem.Id] = true
+
+			download, err := s.planNew(mediaItem, taken)
+			if err != nil {
+				return err
+			}
+			download.EstimatedSize = estimateSize(database.MediaItem{MimeType: mediaItem.MimeType}, sizes)
+			plan.add(download)
+		}
+		return nil
+	}
+
+	lastIndex, err := s.db.Settings.LastIndex()
+	if err != nil {
+		return
+	}
+
+	fullSync, err := fullSyncDue(s.db, time.Now())
+	if err != nil {
+		return
+	}
+
+	plan.FullListing = fullSync || lastIndex == (time.Time{})
+	if plan.FullListing {
+		s.logger.Info.Print("listing the whole library")
+		err = listLibrary(ctx, s.api, s.pagingSize, process)
+	} else {
+		s.logger.Info.Printf("searching for media items created since %s", lastIndex.Format(time.RFC3339))
+		err = searchCreatedSince(ctx, s.api, lastIndex, s.pagingSize, process)
+	}
+	return
+}
+
+// planNew places a media item the way the indexer does, renaming it when its location is taken
+func (s *SyncPlanner) planNew(mediaItem api.MediaItem, taken map[string]bool) (download PlannedDownload, err error) {
+	dbItem := convertToDatabaseMediaItem(s.layout, mediaItem)[0]
+	if s.layout.UsesAlbum() {
+		var album string
+		album, err = s.db.Albums.TitleForMediaItem(mediaItem.Id)
+		if err != nil {
+			return
+		}
+		dbItem.LocalPath, dbItem.LocalFilename = s.layout.Render(layoutFields(*dbItem, album))
+	}
+
+	filename := dbItem.LocalFilename
+	for counter := 2; taken[filepath.Join(dbItem.LocalPath, dbItem.LocalFilename)]; counter++ {
+		dbItem.LocalFilename = generateNewFilename(counter, filename)
+	}
+	relativePath := filepath.Join(dbItem.LocalPath, dbItem.LocalFilename)
+	taken[relativePath] = true
+
+	// downloads replace whatever is at their location
+	_, statErr := os.Lstat(filepath.Join(s.rootDir, relativePath))
+	return PlannedDownload{
+		RemoteId:      mediaItem.Id,
+		LocalPath:     dbItem.LocalPath,
+		LocalFilename: dbItem.LocalFilename,
+		New:           true,
+		Renamed:       dbItem.LocalFilename != filename,
+		Overwrites:    statErr == nil,
+	}, nil
+}
+
+func estimateSize(item database.MediaItem, sizes database.FileSizes) int64 {
+	if item.IsPhoto() {
+		if sizes.Photo > 0 {
+			return sizes.Photo
+		}
+		return typicalPhotoSize
+	}
+	if sizes.Video > 0 {
+		return sizes.Video
+	}
+	return typicalVideoSize
+}
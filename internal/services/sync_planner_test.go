@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncPlannerPlansWhatSyncIndexes(t *testing.T) {
+	items := createMediaItems(t)
+	items.NextPageToken = ""
+	duplicateFilenameItem := createMediaItem(t)
+	duplicateFilenameItem.Id = "ALU181gS07lNXbEvg"
+	video := createMediaItem(t)
+	video.Id = "ALU181gVideo"
+	video.MimeType = "video/mp4"
+	video.Filename = "VID_20211227.mp4"
+	items.MediaItems = append(items.MediaItems, duplicateFilenameItem, video)
+	downloader := mockDownloader{
+		list: func(_ models.PagingOptions) (mediaItems models.MediaItems, err error) {
+			return items, nil
+		},
+	}
+
+	db := database.CreateTestDatabase(t)
+	planner := NewSyncPlanner(&downloader, db, layout.Default, t.TempDir(), false, db.Logger)
+	plan, err := planner.Plan(context.Background())
+	assert.NoError(t, err)
+
+	// nothing is written
+	dbItems, err := db.MediaItems.GetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, dbItems)
+	lastIndex, err := db.Settings.LastIndex()
+	assert.NoError(t, err)
+	assert.Zero(t, lastIndex)
+
+	assert.True(t, plan.FullListing)
+	assert.Equal(t, 3, plan.Listed)
+	assert.Equal(t, 3, plan.New)
+	assert.Equal(t, 1, plan.Renamed)
+	assert.Equal(t, int64(2*typicalPhotoSize+typicalVideoSize), plan.EstimatedSize)
+
+	queuer := mockQueuer{}
+	service := NewSyncService(&downloader, db, &queuer, layout.Default, nil, db.Logger)
+	assert.NoError(t, service.Sync(context.Background()))
+
+	dbItems, err = db.MediaItems.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, plan.Downloads, len(dbItems))
+	for i, dbItem := range dbItems {
+		assert.Equal(t, dbItem.RemoteId, plan.Downloads[i].RemoteId)
+		assert.Equal(t, filepath.Join(dbItem.LocalPath, dbItem.LocalFilename), plan.Downloads[i].Path())
+	}
+	assert.True(t, plan.Downloads[1].Renamed)
+}
+
+func TestSyncPlannerIncludesPendingDownloadsAndSearchesForNewMediaItems(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateLastIndex(time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)))
+	assert.NoError(t, db.Settings.UpdateFullSyncDays(0))
+
+	downloaded := database.CreateTestMediaItem(t)
+	downloaded.FileSize = 3000
+	partial := database.CreateTestMediaItem(t)
+	partial.Downloaded = false
+	partial.PartialBytes = 1000
+	shared := database.CreateTestMediaItem(t)
+	shared.Downloaded = false
+	shared.Source = database.SourceShared
+	deleted := database.CreateTestMediaItem(t)
+	deleted.Downloaded = false
+	deleted.DeletedAt = time.Now()
+	assert.NoError(t, db.MediaItems.Save(&downloaded, &partial, &shared, &deleted))
+
+	newItem := createMediaItem(t)
+	listedAgain := createMediaItem(t)
+	listedAgain.Id = partial.RemoteId
+	var searched models.SearchOptions
+	downloader := mockDownloader{
+		search: func(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+			searched = options
+			return models.MediaItems{MediaItems: []models.MediaItem{newItem, listedAgain}}, nil
+		},
+	}
+
+	// a file the library doesn't know about is where the new media item goes
+	rootDir := t.TempDir()
+	localPath, localFilename := layout.Default.Render(layoutFields(*convertToDatabaseMediaItem(layout.Default, newItem)[0], ""))
+	assert.NoError(t, os.MkdirAll(filepath.Join(rootDir, localPath), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rootDir, localPath, localFilename), []byte("stray"), 0644))
+
+	planner := NewSyncPlanner(&downloader, db, layout.Default, rootDir, false, db.Logger)
+	plan, err := planner.Plan(context.Background())
+	assert.NoError(t, err)
+
+	assert.False(t, plan.FullListing)
+	assert.Equal(t, models.SearchDate{Year: 2021, Month: 12, Day: 1}, searched.Filters.DateFilter.Ranges[0].StartDate)
+	assert.Equal(t, 2, plan.Listed)
+	assert.Equal(t, 1, plan.AlreadyIndexed)
+	assert.Equal(t, 1, plan.New)
+	assert.Equal(t, 1, plan.Pending)
+	assert.Equal(t, 1, plan.Overwrites)
+	assert.Equal(t, []PlannedDownload{
+		{RemoteId: partial.RemoteId, LocalPath: partial.LocalPath, LocalFilename: partial.LocalFilename, EstimatedSize: 2000},
+		{RemoteId: newItem.Id, LocalPath: localPath, LocalFilename: localFilename, New: true, Overwrites: true, EstimatedSize: 3000},
+	}, plan.Downloads)
+	assert.Equal(t, int64(5000), plan.EstimatedSize)
+
+	planner = NewSyncPlanner(&downloader, db, layout.Default, rootDir, true, db.Logger)
+	plan, err = planner.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, plan.Pending)
+}
+
+func TestSyncPlannerListsWholeLibraryWhenFullSyncIsDue(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	assert.NoError(t, db.Settings.UpdateLastIndex(time.Now()))
+	assert.NoError(t, db.Settings.UpdateFullSyncDays(7))
+	assert.NoError(t, db.Settings.UpdateLastFullSync(time.Now().AddDate(0, 0, -8)))
+	item := createMediaItem(t)
+	downloader := mockDownloader{
+		list: func(_ models.PagingOptions) (mediaItems models.MediaItems, err error) {
+			return models.MediaItems{MediaItems: []models.MediaItem{item}}, nil
+		},
+	}
+
+	planner := NewSyncPlanner(&downloader, db, layout.Default, t.TempDir(), false, db.Logger)
+	plan, err := planner.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, plan.FullListing)
+	assert.Equal(t, 1, plan.New)
+}
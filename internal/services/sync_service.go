@@ -1,16 +1,14 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"math"
-	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/mattn/go-sqlite3"
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
 	api "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
 	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
@@ -19,16 +17,17 @@ import (
 type SyncService struct {
 	api        googlephotos.Downloader
 	db         database.PhotoDatabase
-	download   DownloaderQueuer
+	indexer    mediaItemIndexer
 	logger     utils.Logger
 	pagingSize int
 }
 
-func NewSyncService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, logger utils.Logger) SyncService {
-	return SyncService{api: api, db: db, download: download, logger: logger, pagingSize: 100}
+func NewSyncService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, template layout.Template, progress *Progress, logger utils.Logger) SyncService {
+	indexer := mediaItemIndexer{db: db, download: download, layout: template, progress: progress, logger: logger}
+	return SyncService{api: api, db: db, indexer: indexer, logger: logger, pagingSize: 100}
 }
 
-func (s *SyncService) Sync() error {
+func (s *SyncService) Sync(ctx context.Context) error {
 	lastIndex, err := s.db.Settings.LastIndex()
 	if err != nil {
 		return err
@@ -37,12 +36,18 @@ func (s *SyncService) Sync() error {
 
 	now := time.Now()
 	if lastIndex == (time.Time{}) {
-		err = s.initialIndex()
+		err = listLibrary(ctx, s.api, s.pagingSize, s.processItems)
+		if err != nil {
+			return err
+		}
+
+		// the initial index lists the whole library, so a full sync isn't needed for a while
+		err = s.db.Settings.UpdateLastFullSync(now)
 		if err != nil {
 			return err
 		}
 	} else {
-		err := s.findNew(lastIndex)
+		err := searchCreatedSince(ctx, s.api, lastIndex, s.pagingSize, s.processItems)
 		if err != nil {
 			return err
 		}
@@ -56,7 +61,8 @@ func (s *SyncService) Sync() error {
 	return nil
 }
 
-func (s *SyncService) findNew(lastIndex time.Time) error {
+// searchCreatedSince passes every page of media items created since the day of lastIndex to process
+func searchCreatedSince(ctx context.Context, photosApi googlephotos.Downloader, lastIndex time.Time, pagingSize int, process func([]api.MediaItem) error) error {
 	endDate := api.SearchDate{Year: 2999, Month: 12, Day: 31}
 	options := api.SearchOptions{
 		Filters: api.SearchFilters{
@@ -69,15 +75,15 @@ func (s *SyncService) findNew(lastIndex time.Time) error {
 				},
 			},
 		},
-		Size: s.pagingSize,
+		Size: pagingSize,
 	}
 	for {
-		items, err := s.api.Search(options)
+		items, err := photosApi.Search(ctx, options)
 		if err != nil {
 			return err
 		}
 
-		err = s.processItems(items.MediaItems)
+		err = process(items.MediaItems)
 		if err != nil {
 			return err
 		}
@@ -90,15 +96,16 @@ func (s *SyncService) findNew(lastIndex time.Time) error {
 	return nil
 }
 
-func (s *SyncService) initialIndex() error {
-	options := api.PagingOptions{Size: s.pagingSize}
+// listLibrary passes every page of media items in the library to process
+func listLibrary(ctx context.Context, photosApi googlephotos.Downloader, pagingSize int, process func([]api.MediaItem) error) error {
+	options := api.PagingOptions{Size: pagingSize}
 	for {
-		items, err := s.api.List(options)
+		items, err := photosApi.List(ctx, options)
 		if err != nil {
 			return err
 		}
 
-		err = s.processItems(items.MediaItems)
+		err = process(items.MediaItems)
 		if err != nil {
 			return err
 		}
@@ -111,72 +118,46 @@ func (s *SyncService) initialIndex() error {
 	return nil
 }
 
-func (s *SyncService) processItems(items []api.MediaItem) (err error) {
-	for _, item := range items {
-		dbItem := convertToDatabaseMediaItem(item)[0]
-		filename := dbItem.Filename
-
-		for counter := 2; counter < math.MaxInt; counter++ {
-			err = s.db.MediaItems.Save(dbItem)
-			if err == nil {
-				break
-			}
-
-			sqliteError, ok := err.(sqlite3.Error)
-			if ok &&
-				sqliteError.Code == database.ConstraintPrimaryError &&
-				sqliteError.ExtendedCode == database.ConstraintUniqueExtendedError {
-				if strings.Contains(sqliteError.Error(), "media_items.local_path, media_items.local_filename") {
-					newFilename := generateNewFilename(counter, filename)
-					dbItem.LocalFilename = newFilename
-					s.logger.Debug.Printf("duplicate file '%s' detected in '%s', trying renaming to '%s'", dbItem.LocalFilename, dbItem.LocalPath, newFilename)
-					continue
-				}
-				if strings.Contains(sqliteError.Error(), "media_items.remote_id") {
-					s.logger.Info.Printf("remote id '%s' already exists in db, skipping...", item.Id)
-					// already exists, so ignore
-					err = nil
-					goto noqueue
-				}
-			}
-
-			// unrecognized error so exit all loops
-			goto finished
-		}
-
-		s.download.QueueDownload(dbItem.Uuid)
-	noqueue:
-	}
-finished:
-	return
+func (s *SyncService) processItems(items []api.MediaItem) error {
+	return s.indexer.index(items, database.SourceLibrary, true)
 }
 
-func convertToDatabaseMediaItem(mediaItems ...api.MediaItem) (dbItems []*database.MediaItem) {
+func convertToDatabaseMediaItem(template layout.Template, mediaItems ...api.MediaItem) (dbItems []*database.MediaItem) {
 	for _, item := range mediaItems {
-		createdAt := item.Metadata.CreationTime
-		year, month, day := createdAt.Date()
-		localPath := strings.Join([]string{
-			strconv.Itoa(year),
-			strconv.Itoa(int(month)),
-			strconv.Itoa(day),
-		}, string(os.PathSeparator))
-
 		dbItem := database.MediaItem{
-			RemoteId:      item.Id,
-			BaseUrl:       item.BaseUrl,
-			MimeType:      item.MimeType,
-			Filename:      item.Filename,
-			Description:   item.Description,
-			LocalPath:     localPath,
-			LocalFilename: item.Filename,
-			CreatedAt:     item.Metadata.CreationTime,
+			RemoteId:    item.Id,
+			BaseUrl:     item.BaseUrl,
+			MimeType:    item.MimeType,
+			Filename:    item.Filename,
+			Description: item.Description,
+			CreatedAt:   item.Metadata.CreationTime,
+			Contributor: item.ContributorInfo.DisplayName,
+			CameraMake:  item.Metadata.Photo.CameraMake,
+			CameraModel: item.Metadata.Photo.CameraModel,
+		}
+		// videos have their own metadata
+		if dbItem.CameraMake == "" && dbItem.CameraModel == "" {
+			dbItem.CameraMake = item.Metadata.Video.CameraMake
+			dbItem.CameraModel = item.Metadata.Video.CameraModel
 		}
+		dbItem.LocalPath, dbItem.LocalFilename = template.Render(layoutFields(dbItem, ""))
 
 		dbItems = append(dbItems, &dbItem)
 	}
 	return
 }
 
+func layoutFields(item database.MediaItem, album string) layout.Fields {
+	return layout.Fields{
+		CreatedAt:   item.CreatedAt,
+		Filename:    item.Filename,
+		MimeType:    item.MimeType,
+		CameraMake:  item.CameraMake,
+		CameraModel: item.CameraModel,
+		Album:       album,
+	}
+}
+
 func convertToApiSearchDate(dateTime time.Time) api.SearchDate {
 	year, month, day := dateTime.Date()
 	return api.SearchDate{Year: year, Month: int(month), Day: day}
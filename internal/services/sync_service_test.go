@@ -1,12 +1,14 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +17,7 @@ import (
 func TestConvertApiMediaItemToDatabaseMediaItem(t *testing.T) {
 	apiMediaItems := createMediaItems(t)
 
-	dbMediaItems := convertToDatabaseMediaItem(apiMediaItems.MediaItems...)
+	dbMediaItems := convertToDatabaseMediaItem(layout.Legacy, apiMediaItems.MediaItems...)
 
 	dbMediaItem := dbMediaItems[0]
 	assert.Empty(t, dbMediaItem.Uuid)
@@ -30,6 +32,8 @@ func TestConvertApiMediaItemToDatabaseMediaItem(t *testing.T) {
 	assert.Equal(t, localPath, dbMediaItem.LocalPath)
 	assert.Equal(t, "Screenshot_20211227-094449_Settings.jpg", dbMediaItem.LocalFilename)
 	assert.Equal(t, 0, dbMediaItem.FileSize)
+	assert.Equal(t, "Sony", dbMediaItem.CameraMake)
+	assert.Equal(t, "G8441", dbMediaItem.CameraModel)
 
 	creationTime, _ := time.Parse(time.RFC3339, "2021-12-27T09:44:49Z")
 	assert.Equal(t, creationTime, dbMediaItem.CreatedAt)
@@ -37,6 +41,28 @@ func TestConvertApiMediaItemToDatabaseMediaItem(t *testing.T) {
 	assert.Equal(t, time.Time{}, dbMediaItem.SyncedAt)
 }
 
+func TestConvertApiMediaItemUsesLayoutTemplate(t *testing.T) {
+	item := createMediaItem(t)
+	item.Metadata.CreationTime = time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)
+
+	dbMediaItem := convertToDatabaseMediaItem(layout.MustParse("{camera_model}/{year}-{month:02}-{day:02}/{filename}"), item)[0]
+	assert.Equal(t, filepath.Join("G8441", "2021-03-07"), dbMediaItem.LocalPath)
+	assert.Equal(t, "Screenshot_20211227-094449_Settings.jpg", dbMediaItem.LocalFilename)
+}
+
+func TestConvertApiMediaItemUsesCameraOfVideos(t *testing.T) {
+	item := createMediaItem(t)
+	item.MimeType = "video/mp4"
+	item.Filename = "VID_20211227.mp4"
+	item.Metadata.Photo = models.MediaItemPhoto{}
+	item.Metadata.Video = models.MediaItemVideo{CameraMake: "Sony", CameraModel: "G8441", Fps: 30, Status: "READY"}
+
+	dbMediaItem := convertToDatabaseMediaItem(layout.MustParse("{camera_make}/{camera_model}/{filename}"), item)[0]
+	assert.Equal(t, "Sony", dbMediaItem.CameraMake)
+	assert.Equal(t, "G8441", dbMediaItem.CameraModel)
+	assert.Equal(t, filepath.Join("Sony", "G8441"), dbMediaItem.LocalPath)
+}
+
 func createMediaItems(t *testing.T) models.MediaItems {
 	json := `{
 	 "mediaItems": [
@@ -77,7 +103,7 @@ func createMediaItem(t *testing.T) models.MediaItem {
 
 func createSyncService(t *testing.T, downloader googlephotos.Downloader, queuer DownloaderQueuer) SyncService {
 	db := database.CreateTestDatabase(t)
-	return NewSyncService(downloader, db, queuer, db.Logger)
+	return NewSyncService(downloader, db, queuer, layout.Default, NewProgress(), db.Logger)
 }
 
 func TestSyncServiceSyncOnInitialIndex(t *testing.T) {
@@ -92,13 +118,17 @@ func TestSyncServiceSyncOnInitialIndex(t *testing.T) {
 	queuer := mockQueuer{}
 	service := createSyncService(t, &downloader, &queuer)
 
-	err := service.Sync()
+	err := service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	lastIndex, err := service.db.Settings.LastIndex()
 	assert.NoError(t, err)
 	assert.InDelta(t, time.Now().UnixMilli(), lastIndex.UnixMilli(), 10000)
 
+	lastFullSync, err := service.db.Settings.LastFullSync()
+	assert.NoError(t, err)
+	assert.Equal(t, lastIndex, lastFullSync)
+
 	dbItems, err := service.db.MediaItems.GetAll()
 	assert.NoError(t, err)
 	assert.Len(t, dbItems, 1)
@@ -131,7 +161,7 @@ func TestSyncServiceSyncOnInitialIndexPaging(t *testing.T) {
 	queuer := mockQueuer{}
 	service := createSyncService(t, &downloader, &queuer)
 
-	err := service.Sync()
+	err := service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	lastIndex, err := service.db.Settings.LastIndex()
@@ -167,7 +197,7 @@ func TestSyncServiceSyncSearchForUpdates(t *testing.T) {
 	err := service.db.Settings.UpdateLastIndex(time.Now())
 	assert.NoError(t, err)
 
-	err = service.Sync()
+	err = service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	lastIndex, err := service.db.Settings.LastIndex()
@@ -208,7 +238,7 @@ func TestSyncServiceSyncSearchForUpdatesPaging(t *testing.T) {
 	err := service.db.Settings.UpdateLastIndex(time.Now())
 	assert.NoError(t, err)
 
-	err = service.Sync()
+	err = service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	lastIndex, err := service.db.Settings.LastIndex()
@@ -249,7 +279,7 @@ func TestHandlesLocalPathDuplicates(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, dbItems, 0)
 
-	err = service.Sync()
+	err = service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	dbItems, err = service.db.MediaItems.GetAll()
@@ -310,7 +340,7 @@ func TestHandlesFilesAlreadyIndexed(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, dbItems, 0)
 
-	err = service.Sync()
+	err = service.Sync(context.Background())
 	assert.NoError(t, err)
 
 	dbItems, err = service.db.MediaItems.GetAll()
@@ -320,4 +350,9 @@ func TestHandlesFilesAlreadyIndexed(t *testing.T) {
 	dbItemOne := dbItems[0]
 	assert.Equal(t, items.MediaItems[0].Id, dbItemOne.RemoteId)
 	assert.Equal(t, []string{dbItemOne.Uuid}, queuer.queuedIds)
+
+	counts := service.indexer.progress.Counts()
+	assert.Equal(t, 1, counts.Indexed)
+	assert.Equal(t, 1, counts.Queued)
+	assert.Equal(t, 1, counts.Duplicates)
 }
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+
 	"github.com/rjnienaber/gphotos_downloader/internal/database"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
@@ -8,19 +10,21 @@ import (
 )
 
 type UndownloadedService struct {
-	api          googlephotos.Downloader
-	db           database.PhotoDatabase
-	download     DownloaderQueuer
-	logger       utils.Logger
-	getBatchSize int
+	api           googlephotos.Downloader
+	db            database.PhotoDatabase
+	download      DownloaderQueuer
+	progress      *Progress
+	logger        utils.Logger
+	getBatchSize  int
+	includeShared bool
 }
 
-func NewUndownloadedService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, logger utils.Logger) UndownloadedService {
-	return UndownloadedService{api: api, db: db, download: download, logger: logger, getBatchSize: 50}
+func NewUndownloadedService(api googlephotos.Downloader, db database.PhotoDatabase, download DownloaderQueuer, includeShared bool, progress *Progress, logger utils.Logger) UndownloadedService {
+	return UndownloadedService{api: api, db: db, download: download, includeShared: includeShared, progress: progress, logger: logger, getBatchSize: 50}
 }
 
-func (u *UndownloadedService) Update() (err error) {
-	mediaItemIds, err := u.db.MediaItems.GetNonDownloadedIds()
+func (u *UndownloadedService) Update(ctx context.Context) (err error) {
+	mediaItemIds, err := u.db.MediaItems.GetNonDownloadedIds(u.includeShared)
 	if err != nil {
 		return
 	}
@@ -37,7 +41,7 @@ func (u *UndownloadedService) Update() (err error) {
 
 	for _, chunk := range chunks {
 		var mediaItems models.MediaItemsResult
-		mediaItems, err = u.api.BatchGet(chunk)
+		mediaItems, err = u.api.BatchGet(ctx, chunk)
 		if err != nil {
 			break
 		}
@@ -49,6 +53,8 @@ func (u *UndownloadedService) Update() (err error) {
 			}
 
 			u.download.QueueDownload(remoteIdMapper[item.Id])
+			u.progress.Refreshed(1)
+			u.progress.Queued(1)
 		}
 	}
 finished:
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -17,7 +18,7 @@ import (
 //	send id to downloader (for each item)
 func createUndownloadedService(t *testing.T, downloader googlephotos.Downloader, queuer DownloaderQueuer) UndownloadedService {
 	db := database.CreateTestDatabase(t)
-	return NewUndownloadedService(downloader, db, queuer, db.Logger)
+	return NewUndownloadedService(downloader, db, queuer, false, NewProgress(), db.Logger)
 }
 
 func TestUndownloadServiceDoesNothingWhenNoItemsFound(t *testing.T) {
@@ -26,7 +27,7 @@ func TestUndownloadServiceDoesNothingWhenNoItemsFound(t *testing.T) {
 	err := service.db.MediaItems.Save(&item)
 	assert.NoError(t, err)
 
-	err = service.Update()
+	err = service.Update(context.Background())
 	assert.NoError(t, err)
 }
 
@@ -49,7 +50,7 @@ func TestUndownloadServiceUpdatesBatchUrlForUndownloadedItems(t *testing.T) {
 	err := service.db.MediaItems.Save(&undownloadedItem, &downloadedItem)
 	assert.NoError(t, err)
 
-	err = service.Update()
+	err = service.Update(context.Background())
 	assert.NoError(t, err)
 
 	assert.Equal(t, 1, downloader.batchGetCallCount)
@@ -67,6 +68,10 @@ func TestUndownloadServiceUpdatesBatchUrlForUndownloadedItems(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, downloadedItem.BaseUrl, dbDownloadedItem.BaseUrl)
 	assert.Equal(t, []string{dbUndownloadedItem.Uuid}, queuer.queuedIds)
+
+	counts := service.progress.Counts()
+	assert.Equal(t, 1, counts.Refreshed)
+	assert.Equal(t, 1, counts.Queued)
 }
 
 func TestUndownloadedServiceMakesCallsInGroups(t *testing.T) {
@@ -99,7 +104,7 @@ func TestUndownloadedServiceMakesCallsInGroups(t *testing.T) {
 	err := service.db.MediaItems.Save(&undownloadedItemOne, &undownloadedItemTwo)
 	assert.NoError(t, err)
 
-	err = service.Update()
+	err = service.Update(context.Background())
 	assert.NoError(t, err)
 
 	assert.Equal(t, 2, downloader.batchGetCallCount)
@@ -137,3 +142,32 @@ func TestChunkingOfArray(t *testing.T) {
 		})
 	}
 }
+
+func TestUndownloadedServiceOnlyQueuesSharedItemsWhenIncluded(t *testing.T) {
+	_, items := models.CreateTestMediaItemsResult(t)
+	downloader := mockDownloader{
+		batchGet: func(ids []string) (mediaItems models.MediaItemsResult, err error) {
+			return items, nil
+		},
+	}
+
+	queuer := mockQueuer{}
+	service := createUndownloadedService(t, &downloader, &queuer)
+	sharedItem := database.CreateTestMediaItem(t)
+	sharedItem.RemoteId = items.MediaItems[0].Id
+	sharedItem.Downloaded = false
+	sharedItem.Source = database.SourceShared
+	err := service.db.MediaItems.Save(&sharedItem)
+	assert.NoError(t, err)
+
+	err = service.Update(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, downloader.batchGetCallCount)
+	assert.Empty(t, queuer.queuedIds)
+
+	service.includeShared = true
+	err = service.Update(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, downloader.batchGetCallCount)
+	assert.Equal(t, []string{sharedItem.Uuid}, queuer.queuedIds)
+}
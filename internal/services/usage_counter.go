@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// quotaZone is where the day of the api quotas starts, they are reset at midnight pacific time
+var quotaZone = loadQuotaZone()
+
+func loadQuotaZone() *time.Location {
+	zone, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.FixedZone("PST", -8*60*60)
+	}
+	return zone
+}
+
+// QuotaDay returns the day of the api quotas that now falls on
+func QuotaDay(now time.Time) string {
+	return now.In(quotaZone).Format("2006-01-02")
+}
+
+// UsageCounter counts the requests a rate limiter lets through in the database, so the usage of a
+// day's quota is known across runs
+type UsageCounter struct {
+	limiter googlephotos.RateLimiter
+	db      database.PhotoDatabase
+	logger  utils.Logger
+	now     func() time.Time
+}
+
+func NewUsageCounter(limiter googlephotos.RateLimiter, db database.PhotoDatabase, logger utils.Logger) *UsageCounter {
+	return &UsageCounter{limiter: limiter, db: db, logger: logger, now: time.Now}
+}
+
+func (u *UsageCounter) Wait(ctx context.Context, kind googlephotos.RequestKind) error {
+	err := u.limiter.Wait(ctx, kind)
+	if err != nil {
+		return err
+	}
+
+	var metadataRequests, mediaRequests int64
+	if kind == googlephotos.MediaRequest {
+		mediaRequests = 1
+	} else {
+		metadataRequests = 1
+	}
+
+	// a request that can't be counted is still worth making
+	err = u.db.ApiUsage.Increment(QuotaDay(u.now()), metadataRequests, mediaRequests)
+	if err != nil {
+		u.logger.Error.Printf("counting %s request failed: %s", kind, err)
+	}
+	return nil
+}
+
+// Today returns the usage of the current day's quota
+func (u *UsageCounter) Today() (database.ApiUsage, error) {
+	return u.db.ApiUsage.Get(QuotaDay(u.now()))
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageCounter_CountsRequestsPerQuotaDay(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	counter := NewUsageCounter(googlephotos.NewRateLimiter(0, 0), db, utils.NewLogger(utils.Silent))
+	// still the 2nd in california
+	counter.now = func() time.Time { return time.Date(2021, 12, 3, 7, 0, 0, 0, time.UTC) }
+
+	assert.NoError(t, counter.Wait(context.Background(), googlephotos.MetadataRequest))
+	assert.NoError(t, counter.Wait(context.Background(), googlephotos.MediaRequest))
+	assert.NoError(t, counter.Wait(context.Background(), googlephotos.MediaRequest))
+
+	usage, err := counter.Today()
+	assert.NoError(t, err)
+	assert.Equal(t, database.ApiUsage{Day: "2021-12-02", MetadataRequests: 1, MediaRequests: 2}, usage)
+}
+
+func TestUsageCounter_DoesNotCountCancelledRequests(t *testing.T) {
+	db := database.CreateTestDatabase(t)
+	counter := NewUsageCounter(googlephotos.NewRateLimiter(0, 0), db, utils.NewLogger(utils.Silent))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, counter.Wait(ctx, googlephotos.MetadataRequest), context.Canceled)
+
+	usage, err := counter.Today()
+	assert.NoError(t, err)
+	assert.Zero(t, usage.MetadataRequests)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+type VerifyProblem string
+
+const (
+	FileMissing      VerifyProblem = "missing"
+	FileSizeMismatch VerifyProblem = "size mismatch"
+	FileChanged      VerifyProblem = "changed"
+)
+
+type VerifyOptions struct {
+	// Checksums re-hashes every file, otherwise only presence and size are checked
+	Checksums bool
+	// MarkNotDownloaded queues files with problems to be downloaded again by the next run
+	MarkNotDownloaded bool
+}
+
+type VerifyResult struct {
+	Item    database.MediaItem
+	Path    string
+	Problem VerifyProblem
+}
+
+type VerifyReport struct {
+	Checked  int
+	Problems []VerifyResult
+	// Unhashed counts files downloaded before checksums were recorded, only their size is checked
+	Unhashed int
+	Marked   int
+}
+
+type VerifyService struct {
+	db      database.PhotoDatabase
+	logger  utils.Logger
+	rootDir string
+}
+
+func NewVerifyService(db database.PhotoDatabase, rootDir string, logger utils.Logger) VerifyService {
+	return VerifyService{db: db, rootDir: rootDir, logger: logger}
+}
+
+func (v *VerifyService) Verify(options VerifyOptions) (report VerifyReport, err error) {
+	items, err := v.db.MediaItems.GetDownloaded()
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		relativePath := filepath.Join(item.LocalPath, item.LocalFilename)
+		v.logger.Trace.Printf("verifying '%s'", relativePath)
+		report.Checked++
+		if options.Checksums && item.Sha256 == "" {
+			report.Unhashed++
+		}
+
+		problem, statErr := v.verifyItem(item, options.Checksums)
+		if statErr != nil {
+			return report, statErr
+		}
+
+		if problem == "" {
+			continue
+		}
+
+		v.logger.Debug.Printf("verifying '%s' failed: %s", relativePath, problem)
+		report.Problems = append(report.Problems, VerifyResult{Item: item, Path: relativePath, Problem: problem})
+
+		if options.MarkNotDownloaded {
+			err = v.db.MediaItems.MarkAsNotDownloaded(item.Uuid, "verify: file "+string(problem))
+			if err != nil {
+				return
+			}
+			report.Marked++
+		}
+	}
+	return
+}
+
+func (v *VerifyService) verifyItem(item database.MediaItem, checksums bool) (VerifyProblem, error) {
+	path := filepath.Join(v.rootDir, item.LocalPath, item.LocalFilename)
+	stat, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if stat.Size() != int64(item.FileSize) {
+		return FileSizeMismatch, nil
+	}
+
+	if !checksums || item.Sha256 == "" {
+		return "", nil
+	}
+
+	checksum, err := fileSha256(path)
+	if err != nil {
+		return "", err
+	}
+	if checksum != item.Sha256 {
+		return FileChanged, nil
+	}
+	return "", nil
+}
+
+func fileSha256(path string) (checksum string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer utils.CheckClose(file, &err)
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
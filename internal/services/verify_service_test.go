@@ -0,0 +1,88 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func createVerifyService(t *testing.T) VerifyService {
+	db := database.CreateTestDatabase(t)
+	return NewVerifyService(db, t.TempDir(), db.Logger)
+}
+
+func writeLibraryFile(t *testing.T, rootDir string, item database.MediaItem, content string) {
+	dir := filepath.Join(rootDir, item.LocalPath)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, item.LocalFilename), []byte(content), 0644))
+}
+
+func TestVerifyService_ReportsNoProblemsForIntactFiles(t *testing.T) {
+	service := createVerifyService(t)
+	item := database.CreateTestMediaItem(t)
+	item.FileSize = 4
+	assert.NoError(t, service.db.MediaItems.Save(&item))
+	writeLibraryFile(t, service.rootDir, item, "abcd")
+
+	report, err := service.Verify(VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Problems)
+}
+
+func TestVerifyService_ReportsMissingAndMismatchedFiles(t *testing.T) {
+	service := createVerifyService(t)
+	missing := database.CreateTestMediaItem(t)
+	truncated := database.CreateTestMediaItem(t)
+	truncated.FileSize = 4
+	notDownloaded := database.CreateTestMediaItem(t)
+	notDownloaded.Downloaded = false
+	assert.NoError(t, service.db.MediaItems.Save(&missing, &truncated, &notDownloaded))
+	writeLibraryFile(t, service.rootDir, truncated, "ab")
+
+	report, err := service.Verify(VerifyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Len(t, report.Problems, 2)
+
+	assert.Equal(t, missing.Uuid, report.Problems[0].Item.Uuid)
+	assert.Equal(t, FileMissing, report.Problems[0].Problem)
+	assert.Equal(t, truncated.Uuid, report.Problems[1].Item.Uuid)
+	assert.Equal(t, FileSizeMismatch, report.Problems[1].Problem)
+	assert.Equal(t, filepath.Join(truncated.LocalPath, truncated.LocalFilename), report.Problems[1].Path)
+}
+
+func TestVerifyService_ReportsChangedFilesAndMarksThemNotDownloaded(t *testing.T) {
+	service := createVerifyService(t)
+	intact := database.CreateTestMediaItem(t)
+	intact.FileSize = 4
+	intact.Sha256 = "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589"
+	changed := intact
+	changed.RemoteId = "changed"
+	changed.LocalFilename = "changed.png"
+	unhashed := intact
+	unhashed.RemoteId = "unhashed"
+	unhashed.LocalFilename = "unhashed.png"
+	unhashed.Sha256 = ""
+	assert.NoError(t, service.db.MediaItems.Save(&intact, &changed, &unhashed))
+	writeLibraryFile(t, service.rootDir, intact, "abcd")
+	writeLibraryFile(t, service.rootDir, changed, "abce")
+	writeLibraryFile(t, service.rootDir, unhashed, "abce")
+
+	report, err := service.Verify(VerifyOptions{Checksums: true, MarkNotDownloaded: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, 1, report.Unhashed)
+	assert.Equal(t, 1, report.Marked)
+	assert.Len(t, report.Problems, 1)
+	assert.Equal(t, changed.Uuid, report.Problems[0].Item.Uuid)
+	assert.Equal(t, FileChanged, report.Problems[0].Problem)
+
+	dbItem, err := service.db.MediaItems.Get(changed.Uuid)
+	assert.NoError(t, err)
+	assert.False(t, dbItem.Downloaded)
+	assert.Equal(t, "verify: file changed", dbItem.LastError)
+}
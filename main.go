@@ -1,82 +1,124 @@
 package main
 
 import (
-	"net"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 
-	"github.com/rjnienaber/gphotos_downloader/internal/database"
-	photoOauth "github.com/rjnienaber/gphotos_downloader/internal/oauth2"
-	"github.com/rjnienaber/gphotos_downloader/internal/services"
-	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/internal/lockfile"
+	"github.com/rjnienaber/gphotos_downloader/internal/options"
 	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
 )
 
-func wireUp(clientSecretPath string, dbPath string, db database.PhotoDatabase, logger utils.Logger) (services.DownloadService, services.UndownloadedService, services.SyncService) {
-	tokenService, err := photoOauth.NewTokenService(clientSecretPath, db, logger)
-	if err != nil {
-		logger.Error.Fatal(err)
+const programName = "gphotos_downloader"
+
+func usage(out io.Writer) {
+	_, _ = fmt.Fprintf(out, "usage: %s <command> [flags]\n\ncommands:\n", programName)
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.summary)
 	}
+	_, _ = fmt.Fprintf(out, "\nrun '%s <command> -h' for the flags of a command\n", programName)
+}
 
-	token, err := tokenService.LoadToken()
-	if err != nil {
-		logger.Error.Fatal(err)
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
 	}
 
-	photosApi := googlephotos.NewPhotosApi(googlephotos.Options{
-		Config: tokenService.Config,
-		Token:  token,
-		Logger: logger,
-	})
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(stdout)
+		return exitOk
+	}
 
-	retryFactory := services.NewExponentialRetryFactory(net.OpError{}, new(net.OpError), net.DNSError{}, new(net.DNSError))
-	downloader := services.NewDownloadService(&photosApi, db, dbPath,
-		services.WithLogger(logger),
-		services.WithRetryFactory(retryFactory),
-		services.WithMaxWorkers(5),
-	)
+	cmd, ok := findCommand(name)
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "unknown command '%s'\n\n", name)
+		usage(stderr)
+		return exitUsage
+	}
 
-	undownloadedService := services.NewUndownloadedService(&photosApi, db, &downloader, logger)
-	syncService := services.NewSyncService(&photosApi, db, &downloader, logger)
+	opts := options.Options{}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	runCommand := cmd.setup(flags, &opts)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "usage: %s %s [flags]\n\n%s\n\nflags:\n", programName, cmd.name, cmd.summary)
+		flags.PrintDefaults()
+	}
 
-	return downloader, undownloadedService, syncService
-}
+	err := flags.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOk
+	}
+	if err != nil {
+		return exitUsage
+	}
 
-func main() {
-	clientSecretPath := os.Args[1]
-	dbPath := os.Args[2]
-
-	logger := utils.NewLogger(utils.Debug)
-	db, err := database.NewDatabase(
-		database.WithFileConnection(dbPath, logger),
-		database.WithLogger(logger),
-	)
+	if flags.NArg() > 0 {
+		err = fmt.Errorf("unexpected arguments: %v", flags.Args())
+	} else {
+		err = opts.Validate()
+	}
 	if err != nil {
-		logger.Error.Fatal(err)
+		_, _ = fmt.Fprintf(stderr, "%s\n\n", err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	// on a terminal, log lines are written above the progress line instead of through it
+	errOut := stderr
+	if isTerminal(stderr) {
+		errOut = utils.NewLiveLine(stderr)
 	}
-	defer func() {
-		err := db.Close()
+	logger := utils.NewLoggerWithOptions(utils.WithLevel(opts.LogLevel), utils.WithFormat(opts.LogFormat), utils.WithOut(errOut))
+	ctx, cancel := cancelOnSignal(logger)
+	defer cancel()
+
+	var lock *lockfile.Lock
+	if !cmd.readOnly {
+		lock, err = lockfile.Acquire(opts.LibraryRoot, logger)
 		if err != nil {
 			logger.Error.Print(err)
+			var lockedErr lockfile.LockedError
+			if errors.As(err, &lockedErr) {
+				return exitLocked
+			}
+			return exitFailure
 		}
-	}()
-
-	downloader, undownloadedService, syncService := wireUp(clientSecretPath, dbPath, db, logger)
+		defer releaseLock(lock, logger)
+	}
 
-	err = undownloadedService.Update()
+	a, err := wireUp(ctx, opts, logger, stdout, errOut)
 	if err != nil {
-		downloader.Finish()
-		logger.Error.Fatal(err)
-		return
+		logger.Error.Print(err)
+		return exitCodeFor(err)
 	}
+	defer a.Close()
+	a.locked = lock != nil
 
-	err = syncService.Sync()
+	finishRun := func(error) {}
+	if cmd.recorded && !opts.DryRun {
+		finishRun = a.startRun(cmd.name)
+	}
+	err = runCommand(a)
+	finishRun(err)
 	if err != nil {
-		downloader.Finish()
-		logger.Error.Fatal(err)
-		return
+		logger.Error.Print(err)
 	}
+	return exitCodeFor(err)
+}
 
-	downloader.Finish()
+func releaseLock(lock *lockfile.Lock, logger utils.Logger) {
+	err := lock.Release()
+	if err != nil {
+		logger.Error.Printf("releasing the library lock failed: %s", err)
+	}
+}
 
-	logger.Info.Print("sync completed")
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
 }
@@ -2,6 +2,8 @@ package googlephotos
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
@@ -17,23 +20,48 @@ import (
 )
 
 type PhotosApi struct {
-	baseUrl string
-	client  *http.Client
-	logger  utils.Logger
+	baseUrl   string
+	client    *http.Client
+	limiter   RateLimiter
+	bandwidth ByteLimiter
+	observer  RequestObserver
+	logger    utils.Logger
 }
 
 type Options struct {
-	BaseUrl               string
-	Config                oauth2.Config
-	Token                 *oauth2.Token
+	BaseUrl string
+	Config  oauth2.Config
+	Token   *oauth2.Token
+	// TokenSource takes precedence over Config and Token when set
+	TokenSource           oauth2.TokenSource
 	Client                *http.Client
 	TimeoutInMilliseconds int
-	Logger                utils.Logger
+	// Limiter holds requests back to stay within the api quotas, requests aren't limited without one
+	Limiter RateLimiter
+	// Bandwidth limits how fast media items are downloaded, they aren't limited without one
+	Bandwidth ByteLimiter
+	// Observer is told about every request made, e.g. to count them
+	Observer RequestObserver
+	Logger   utils.Logger
+}
+
+// RequestObserver is told the endpoint of a request and the status code it was answered with, or 0
+// when it failed without an answer. Media downloads are reported as the download endpoint
+type RequestObserver interface {
+	ObserveRequest(endpoint string, status int)
+}
+
+type noObserver struct {
+}
+
+func (n noObserver) ObserveRequest(_ string, _ int) {
 }
 
 func NewPhotosApi(options Options) PhotosApi {
 	client := options.Client
-	if client == nil {
+	if client == nil && options.TokenSource != nil {
+		client = oauth2.NewClient(context.Background(), options.TokenSource)
+	} else if client == nil {
 		client = options.Config.Client(context.Background(), options.Token)
 	}
 
@@ -46,21 +74,34 @@ func NewPhotosApi(options Options) PhotosApi {
 		baseUrl = "https://photoslibrary.googleapis.com/v1"
 	}
 
+	limiter := options.Limiter
+	if limiter == nil {
+		limiter = unlimited{}
+	}
+
+	observer := options.Observer
+	if observer == nil {
+		observer = noObserver{}
+	}
+
 	return PhotosApi{
-		baseUrl: baseUrl,
-		client:  client,
-		logger:  options.Logger,
+		baseUrl:   baseUrl,
+		client:    client,
+		limiter:   limiter,
+		bandwidth: options.Bandwidth,
+		observer:  observer,
+		logger:    options.Logger,
 	}
 }
 
-func (api *PhotosApi) Get(mediaItemId string) (mediaItem models.MediaItem, err error) {
+func (api *PhotosApi) Get(ctx context.Context, mediaItemId string) (mediaItem models.MediaItem, err error) {
 	getUrl, err := api.buildUrl(fmt.Sprintf("/mediaItems/%s", mediaItemId), map[string][]string{})
 	if err != nil {
 		return
 	}
 
 	api.logger.Debug.Printf("getting media item from %s\n", getUrl.String())
-	response, err := api.client.Get(getUrl.String())
+	response, err := api.get(ctx, "mediaItems.get", getUrl.String())
 	if err != nil {
 		return
 	}
@@ -79,7 +120,7 @@ func (api *PhotosApi) Get(mediaItemId string) (mediaItem models.MediaItem, err e
 	return models.MediaItem{}, models.ParseErrorReponse(response, responseBody)
 }
 
-func (api *PhotosApi) BatchGet(mediaItemIds []string) (mediaItems models.MediaItemsResult, err error) {
+func (api *PhotosApi) BatchGet(ctx context.Context, mediaItemIds []string) (mediaItems models.MediaItemsResult, err error) {
 	queryString := map[string][]string{}
 	queryString["mediaItemIds"] = mediaItemIds
 
@@ -89,7 +130,7 @@ func (api *PhotosApi) BatchGet(mediaItemIds []string) (mediaItems models.MediaIt
 	}
 
 	api.logger.Debug.Printf("getting list of media items from %s\n", batchGetUrl.String())
-	response, err := api.client.Get(batchGetUrl.String())
+	response, err := api.get(ctx, "mediaItems.batchGet", batchGetUrl.String())
 	if err != nil {
 		return
 	}
@@ -108,7 +149,7 @@ func (api *PhotosApi) BatchGet(mediaItemIds []string) (mediaItems models.MediaIt
 	return models.MediaItemsResult{}, models.ParseErrorReponse(response, responseBody)
 }
 
-func (api *PhotosApi) List(options models.PagingOptions) (mediaItems models.MediaItems, err error) {
+func (api *PhotosApi) List(ctx context.Context, options models.PagingOptions) (mediaItems models.MediaItems, err error) {
 	queryString := map[string][]string{}
 	queryString["pageSize"] = []string{strconv.Itoa(options.Size)}
 	queryString["pageToken"] = []string{options.Token}
@@ -119,7 +160,7 @@ func (api *PhotosApi) List(options models.PagingOptions) (mediaItems models.Medi
 	}
 
 	api.logger.Debug.Printf("getting list of media items from %s\n", listUrl.String())
-	response, err := api.client.Get(listUrl.String())
+	response, err := api.get(ctx, "mediaItems.list", listUrl.String())
 	if err != nil {
 		return
 	}
@@ -138,7 +179,45 @@ func (api *PhotosApi) List(options models.PagingOptions) (mediaItems models.Medi
 	return models.MediaItems{}, models.ParseErrorReponse(response, responseBody)
 }
 
-func (api *PhotosApi) Search(options models.SearchOptions) (mediaItems models.MediaItems, err error) {
+func (api *PhotosApi) ListAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	return api.listAlbums(ctx, "/albums", options, models.DeserializeAlbumsJson)
+}
+
+func (api *PhotosApi) ListSharedAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error) {
+	return api.listAlbums(ctx, "/sharedAlbums", options, models.DeserializeSharedAlbumsJson)
+}
+
+func (api *PhotosApi) listAlbums(ctx context.Context, resourceUrl string, options models.PagingOptions, deserialize func([]byte) (models.Albums, error)) (albums models.Albums, err error) {
+	queryString := map[string][]string{}
+	queryString["pageSize"] = []string{strconv.Itoa(options.Size)}
+	queryString["pageToken"] = []string{options.Token}
+
+	listUrl, err := api.buildUrl(resourceUrl, queryString)
+	if err != nil {
+		return
+	}
+
+	api.logger.Debug.Printf("getting list of albums from %s\n", listUrl.String())
+	response, err := api.get(ctx, strings.TrimPrefix(resourceUrl, "/")+".list", listUrl.String())
+	if err != nil {
+		return
+	}
+	defer utils.CheckClose(response.Body, &err)
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	if isSuccessResponse(response) {
+		albums, err = deserialize(responseBody)
+		return
+	}
+
+	return models.Albums{}, models.ParseErrorReponse(response, responseBody)
+}
+
+func (api *PhotosApi) Search(ctx context.Context, options models.SearchOptions) (mediaItems models.MediaItems, err error) {
 	bodyReader, err := options.Serialize()
 	if err != nil {
 		return
@@ -146,7 +225,13 @@ func (api *PhotosApi) Search(options models.SearchOptions) (mediaItems models.Me
 
 	searchUrl := api.baseUrl + "/mediaItems:search"
 	api.logger.Debug.Printf("running search against %s\n", searchUrl)
-	response, err := api.client.Post(searchUrl, "application/json", bodyReader)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, searchUrl, bodyReader)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := api.do(request, "mediaItems.search", MetadataRequest)
 	if err != nil {
 		return
 	}
@@ -165,34 +250,39 @@ func (api *PhotosApi) Search(options models.SearchOptions) (mediaItems models.Me
 	return models.MediaItems{}, models.ParseErrorReponse(response, responseBody)
 }
 
-func (api *PhotosApi) Download(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error) {
-	file, err := ioutil.TempFile(tmpDir, "gphoto.*.tmp")
-	if err != nil {
-		api.logger.Error.Print(err)
-	}
-	defer func(err *error) {
-		if *err == nil {
-			// download was successful, so we want to retain the file and just close it
-			utils.CheckClose(file, err)
-		} else {
-			// download unsuccessful, so we want to clean up the temporary file
-			removeError := os.Remove(file.Name())
-			if removeError != nil {
-				// it's a temporary file so just log the error and return the original err
-				api.logger.Error.Printf("Error: %s\n", removeError.Error())
-				return
-			}
-		}
-	}(&err)
-
+// Download streams the content of a media item to the partial file, computing its checksum on the
+// way. Content already in the partial file is resumed from with a range request when the server
+// still has the same content, otherwise the download starts from scratch. The partial file is kept
+// when the download fails, so it can be resumed
+func (api *PhotosApi) Download(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error) {
 	if isPhoto {
 		baseUrl += "=d"
 	} else {
 		baseUrl += "=dv"
 	}
 
+	file, err := os.OpenFile(partial.Path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return
+	}
+	defer utils.CheckClose(file, &err)
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	download = models.DownloadedFile{Path: partial.Path, Size: offset, Validator: partial.Validator}
+	if partial.Validator == "" {
+		offset = 0
+	}
+
 	api.logger.Trace.Printf("retrieving media item from %s\n", baseUrl)
-	response, err := api.client.Get(baseUrl)
+	response, err := api.getRange(ctx, baseUrl, offset, partial.Validator)
+	if err == nil && response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		_ = response.Body.Close()
+		offset = 0
+		response, err = api.getRange(ctx, baseUrl, offset, "")
+	}
 	if err != nil {
 		return
 	}
@@ -201,20 +291,111 @@ func (api *PhotosApi) Download(tmpDir string, baseUrl string, isPhoto bool) (fil
 	if !isSuccessResponse(response) {
 		responseBody, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			return "", err
+			return download, err
 		}
-		return "", models.NewApiError(response, responseBody)
+		return download, models.NewApiError(response, responseBody)
 	}
 
-	_, err = file.ReadFrom(response.Body)
+	hash := sha256.New()
+	if response.StatusCode == http.StatusPartialContent && offset > 0 {
+		start, parseErr := contentRangeStart(response.Header.Get("Content-Range"))
+		if parseErr != nil || start != offset {
+			return models.DownloadedFile{Path: partial.Path}, fmt.Errorf("server resumed '%s' at the wrong position: %s", partial.Path, response.Header.Get("Content-Range"))
+		}
+
+		api.logger.Debug.Printf("resuming download of '%s' from byte %d\n", partial.Path, offset)
+		_, err = file.Seek(0, io.SeekStart)
+		if err == nil {
+			_, err = io.CopyN(hash, file, offset)
+		}
+		if err != nil {
+			return
+		}
+	} else {
+		offset = 0
+		download.Validator = resumeValidator(response)
+		err = file.Truncate(0)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	var body io.Reader = response.Body
+	if api.bandwidth != nil {
+		body = &limitedReader{ctx: ctx, reader: response.Body, limiter: api.bandwidth}
+	}
+	written, err := io.Copy(io.MultiWriter(file, hash), body)
+	download.Size = offset + written
 	if err != nil {
 		return
 	}
 
-	filePath = file.Name()
+	download.Sha256 = hex.EncodeToString(hash.Sum(nil))
 	return
 }
 
+// getRange requests content from offset onwards, as long as it still matches the validator
+func (api *PhotosApi) getRange(ctx context.Context, getUrl string, offset int64, validator string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, getUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && validator != "" {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		request.Header.Set("If-Range", validator)
+	}
+	return api.do(request, "download", MediaRequest)
+}
+
+// resumeValidator returns what identifies the content of a response when resuming it. Only strong
+// etags can be used with If-Range
+func resumeValidator(response *http.Response) string {
+	if response.Header.Get("Accept-Ranges") != "bytes" {
+		return ""
+	}
+	if etag := response.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return response.Header.Get("Last-Modified")
+}
+
+// contentRangeStart parses the first byte position of a Content-Range header like 'bytes 100-199/200'
+func contentRangeStart(contentRange string) (int64, error) {
+	byteRange := strings.TrimPrefix(contentRange, "bytes ")
+	dash := strings.Index(byteRange, "-")
+	if byteRange == contentRange || dash < 0 {
+		return 0, fmt.Errorf("invalid content range '%s'", contentRange)
+	}
+	return strconv.ParseInt(byteRange[:dash], 10, 64)
+}
+
+func (api *PhotosApi) get(ctx context.Context, endpoint string, getUrl string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, getUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return api.do(request, endpoint, MetadataRequest)
+}
+
+// do sends the request once the rate limiter allows it
+func (api *PhotosApi) do(request *http.Request, endpoint string, kind RequestKind) (*http.Response, error) {
+	err := api.limiter.Wait(request.Context(), kind)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		api.observer.ObserveRequest(endpoint, 0)
+		return nil, err
+	}
+	api.observer.ObserveRequest(endpoint, response.StatusCode)
+	return response, nil
+}
+
 func (api *PhotosApi) buildUrl(resourceUrl string, queryString map[string][]string) (fullUrl *url.URL, err error) {
 	fullUrl, err = utils.BuildUrl(api.baseUrl+resourceUrl, queryString)
 	return
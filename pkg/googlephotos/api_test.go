@@ -0,0 +1,199 @@
+package googlephotos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const content = "abcdefghij"
+
+// rangeServer serves content with an etag, honouring range requests that match it
+func rangeServer(t *testing.T, etag string, requests *[]*http.Request) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+
+		byteRange := r.Header.Get("Range")
+		if byteRange == "" || r.Header.Get("If-Range") != etag {
+			_, _ = w.Write([]byte(content))
+			return
+		}
+
+		start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(byteRange, "bytes="), "-"))
+		assert.NoError(t, err)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte(content[start:]))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func createApi(server *httptest.Server) PhotosApi {
+	return NewPhotosApi(Options{BaseUrl: server.URL, Client: server.Client(), Logger: utils.NewLogger(utils.Silent)})
+}
+
+func writePartial(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "item.part")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPhotosApi_DownloadResumesPartialFile(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v1"`, &requests)
+	api := createApi(server)
+	path := writePartial(t, content[:4])
+
+	download, err := api.Download(context.Background(), models.PartialDownload{Path: path, Validator: `"v1"`}, server.URL+"/item", true)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DownloadedFile{Path: path, Size: 10, Sha256: checksum(content), Validator: `"v1"`}, download)
+	assert.Equal(t, "bytes=4-", requests[0].Header.Get("Range"))
+	assert.Equal(t, "/item=d", requests[0].URL.Path)
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(written))
+}
+
+func TestPhotosApi_DownloadRestartsWhenContentChanged(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v2"`, &requests)
+	api := createApi(server)
+	path := writePartial(t, "XXXX")
+
+	download, err := api.Download(context.Background(), models.PartialDownload{Path: path, Validator: `"v1"`}, server.URL+"/item", false)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DownloadedFile{Path: path, Size: 10, Sha256: checksum(content), Validator: `"v2"`}, download)
+	assert.Equal(t, "/item=dv", requests[0].URL.Path)
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(written))
+}
+
+func TestPhotosApi_DownloadWithoutValidatorStartsFromScratch(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v1"`, &requests)
+	api := createApi(server)
+	path := writePartial(t, "XXXXXXXXXXXXXXXX")
+
+	download, err := api.Download(context.Background(), models.PartialDownload{Path: path}, server.URL+"/item", true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), download.Size)
+	assert.Empty(t, requests[0].Header.Get("Range"))
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(written))
+}
+
+func TestPhotosApi_DownloadKeepsPartialFileOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	api := createApi(server)
+	path := writePartial(t, content[:4])
+
+	download, err := api.Download(context.Background(), models.PartialDownload{Path: path, Validator: `"v1"`}, server.URL+"/item", true)
+	assert.Error(t, err)
+	assert.Equal(t, models.DownloadedFile{Path: path, Size: 4, Validator: `"v1"`}, download)
+
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestContentRangeStart(t *testing.T) {
+	start, err := contentRangeStart("bytes 100-199/200")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), start)
+
+	_, err = contentRangeStart("items 1-2/3")
+	assert.Error(t, err)
+}
+
+type recordingLimiter struct {
+	kinds []RequestKind
+}
+
+func (r *recordingLimiter) Wait(_ context.Context, kind RequestKind) error {
+	r.kinds = append(r.kinds, kind)
+	return nil
+}
+
+func TestPhotosApi_RequestsWaitForRateLimiter(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v1"`, &requests)
+	limiter := &recordingLimiter{}
+	api := NewPhotosApi(Options{BaseUrl: server.URL, Client: server.Client(), Limiter: limiter, Logger: utils.NewLogger(utils.Silent)})
+
+	_, _ = api.List(context.Background(), models.PagingOptions{Size: 10})
+	_, err := api.Download(context.Background(), models.PartialDownload{Path: writePartial(t, "")}, server.URL+"/item", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []RequestKind{MetadataRequest, MediaRequest}, limiter.kinds)
+}
+
+type countingByteLimiter struct {
+	bytes int
+}
+
+func (c *countingByteLimiter) WaitN(_ context.Context, bytes int) error {
+	c.bytes += bytes
+	return nil
+}
+
+func TestPhotosApi_DownloadWaitsForBandwidth(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v1"`, &requests)
+	bandwidth := &countingByteLimiter{}
+	api := NewPhotosApi(Options{BaseUrl: server.URL, Client: server.Client(), Bandwidth: bandwidth, Logger: utils.NewLogger(utils.Silent)})
+
+	download, err := api.Download(context.Background(), models.PartialDownload{Path: writePartial(t, "")}, server.URL+"/item", true)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum(content), download.Sha256)
+	assert.Equal(t, len(content), bandwidth.bytes)
+}
+
+type recordingObserver struct {
+	requests []string
+}
+
+func (r *recordingObserver) ObserveRequest(endpoint string, status int) {
+	r.requests = append(r.requests, fmt.Sprintf("%s %d", endpoint, status))
+}
+
+func TestPhotosApi_RequestsAreObserved(t *testing.T) {
+	var requests []*http.Request
+	server := rangeServer(t, `"v1"`, &requests)
+	observer := &recordingObserver{}
+	api := NewPhotosApi(Options{BaseUrl: server.URL, Client: server.Client(), Observer: observer, Logger: utils.NewLogger(utils.Silent)})
+
+	_, _ = api.ListSharedAlbums(context.Background(), models.PagingOptions{Size: 10})
+	_, err := api.Download(context.Background(), models.PartialDownload{Path: writePartial(t, "")}, server.URL+"/item", true)
+	assert.NoError(t, err)
+	server.Close()
+	_, err = api.Get(context.Background(), "id")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"sharedAlbums.list 200", "download 200", "mediaItems.get 0"}, observer.requests)
+}
@@ -0,0 +1,498 @@
+// Package fake serves an in-memory google photos library over http, for tests that exercise the
+// real api client. It implements the parts of the Library API the downloader uses: listing,
+// searching and getting media items, listing albums and downloading content from base urls,
+// which expire like the real ones do. Faults and latency can be injected
+package fake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+)
+
+// endpoints, named like the methods of the Library API
+const (
+	MediaItemsList     = "mediaItems.list"
+	MediaItemsGet      = "mediaItems.get"
+	MediaItemsBatchGet = "mediaItems.batchGet"
+	MediaItemsSearch   = "mediaItems.search"
+	AlbumsList         = "albums.list"
+	SharedAlbumsList   = "sharedAlbums.list"
+	Download           = "download"
+)
+
+// MediaItem is a media item in the library, Content is what downloading it returns. The base url
+// is set by the server
+type MediaItem struct {
+	models.MediaItem
+	Content []byte
+}
+
+// NewMediaItem creates a media item, a video when the filename ends in .mp4 and a photo otherwise
+func NewMediaItem(id string, filename string, created time.Time, content string) MediaItem {
+	item := models.MediaItem{
+		Id:         id,
+		ProductUrl: "https://photos.google.com/lr/photo/" + id,
+		Filename:   filename,
+		Metadata:   models.MediaMetadata{CreationTime: created, Width: "4032", Height: "3024"},
+	}
+	if strings.EqualFold(path.Ext(filename), ".mp4") {
+		item.MimeType = "video/mp4"
+		item.Metadata.Video = models.MediaItemVideo{Fps: 30, Status: "READY"}
+	} else {
+		item.MimeType = "image/jpeg"
+		item.Metadata.Photo = models.MediaItemPhoto{CameraMake: "Google", CameraModel: "Pixel 6"}
+	}
+	return MediaItem{MediaItem: item, Content: []byte(content)}
+}
+
+// Album is an album in the library. Shared albums are listed by sharedAlbums.list instead of
+// albums.list
+type Album struct {
+	models.Album
+	MediaItemIds []string
+	Shared       bool
+}
+
+// Fault makes the next Times requests to Endpoint fail with Status, at least one fails
+type Fault struct {
+	Endpoint   string
+	Status     int
+	Times      int
+	RetryAfter string
+}
+
+type entry struct {
+	item    MediaItem
+	etag    string
+	version int
+}
+
+type Server struct {
+	server     *httptest.Server
+	mutex      sync.Mutex
+	order      []string
+	items      map[string]*entry
+	albums     []Album
+	faults     []Fault
+	latency    time.Duration
+	requests   map[string]int
+	generation int
+}
+
+// NewServer starts a server with an empty library, it has to be closed once done with
+func NewServer() *Server {
+	s := &Server{items: map[string]*entry{}, requests: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/mediaItems", s.handle(MediaItemsList, s.listMediaItems))
+	mux.HandleFunc("GET /v1/mediaItems/{id}", s.handle(MediaItemsGet, s.getMediaItem))
+	mux.HandleFunc("GET /v1/mediaItems:batchGet", s.handle(MediaItemsBatchGet, s.batchGetMediaItems))
+	mux.HandleFunc("POST /v1/mediaItems:search", s.handle(MediaItemsSearch, s.searchMediaItems))
+	mux.HandleFunc("GET /v1/albums", s.handle(AlbumsList, s.listAlbums(false)))
+	mux.HandleFunc("GET /v1/sharedAlbums", s.handle(SharedAlbumsList, s.listAlbums(true)))
+	mux.HandleFunc("GET /media/{id}/{version}", s.handle(Download, s.download))
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// BaseUrl is the address of the api, to use in place of https://photoslibrary.googleapis.com/v1
+func (s *Server) BaseUrl() string {
+	return s.server.URL + "/v1"
+}
+
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// AddMediaItems adds media items to the library, listed after the ones already in it
+func (s *Server) AddMediaItems(items ...MediaItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, item := range items {
+		if _, ok := s.items[item.Id]; !ok {
+			s.order = append(s.order, item.Id)
+		}
+		sum := sha256.Sum256(item.Content)
+		s.items[item.Id] = &entry{item: item, etag: `"` + hex.EncodeToString(sum[:8]) + `"`, version: s.generation}
+	}
+}
+
+// RemoveMediaItem deletes a media item from the library, as if it was deleted in google photos
+func (s *Server) RemoveMediaItem(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.items, id)
+	for i, existing := range s.order {
+		if existing == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Server) AddAlbum(album Album) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	album.MediaItemsCount = strconv.Itoa(len(album.MediaItemIds))
+	if album.ProductUrl == "" {
+		album.ProductUrl = "https://photos.google.com/lr/album/" + album.Id
+	}
+	s.albums = append(s.albums, album)
+}
+
+// ExpireBaseUrls makes every base url handed out so far answer with 403 Forbidden, like base urls
+// do after an hour. Media items fetched afterwards have a new one
+func (s *Server) ExpireBaseUrls() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generation++
+	for _, entry := range s.items {
+		entry.version = s.generation
+	}
+}
+
+// Inject queues a fault, faults for the same endpoint are used in the order they were injected
+func (s *Server) Inject(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, fault)
+}
+
+// SetLatency delays every answer by latency
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latency = latency
+}
+
+// Requests returns how many requests endpoint has received, including failed ones
+func (s *Server) Requests(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[endpoint]
+}
+
+// handle counts requests, waits for the latency and fails requests with an injected fault
+func (s *Server) handle(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests[endpoint]++
+		latency := s.latency
+		fault, faulted := s.takeFault(endpoint)
+		s.mutex.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if faulted {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			writeError(w, fault.Status, "injected fault")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) takeFault(endpoint string) (Fault, bool) {
+	for i, fault := range s.faults {
+		if fault.Endpoint != endpoint {
+			continue
+		}
+
+		s.faults[i].Times--
+		if s.faults[i].Times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return fault, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) listMediaItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.mutex.Lock()
+	items := s.mediaItems(s.order)
+	s.mutex.Unlock()
+
+	s.writeMediaItemsPage(w, items, query.Get("pageSize"), query.Get("pageToken"))
+}
+
+func (s *Server) getMediaItem(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	items := s.mediaItems([]string{r.PathValue("id")})
+	s.mutex.Unlock()
+
+	if len(items) == 0 {
+		writeError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	writeJson(w, items[0])
+}
+
+func (s *Server) batchGetMediaItems(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["mediaItemIds"]
+	if len(ids) == 0 || len(ids) > 50 {
+		writeError(w, http.StatusBadRequest, "Request must have between 1 and 50 media item ids.")
+		return
+	}
+
+	type status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	type result struct {
+		MediaItem *models.MediaItem `json:"mediaItem,omitempty"`
+		Status    *status           `json:"status,omitempty"`
+	}
+
+	s.mutex.Lock()
+	results := make([]result, len(ids))
+	for i, id := range ids {
+		items := s.mediaItems([]string{id})
+		if len(items) == 0 {
+			results[i].Status = &status{Code: 3, Message: "Invalid media item ID."}
+			continue
+		}
+		results[i].MediaItem = &items[0]
+	}
+	s.mutex.Unlock()
+
+	writeJson(w, map[string]interface{}{"mediaItemResults": results})
+}
+
+func (s *Server) searchMediaItems(w http.ResponseWriter, r *http.Request) {
+	var options models.SearchOptions
+	err := json.NewDecoder(r.Body).Decode(&options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload received.")
+		return
+	}
+
+	dateFilter := options.Filters.DateFilter
+	filtered := len(dateFilter.Dates) > 0 || len(dateFilter.Ranges) > 0
+	if options.AlbumId != "" && filtered {
+		writeError(w, http.StatusBadRequest, "An album id and filters can't be set in the same request.")
+		return
+	}
+
+	s.mutex.Lock()
+	var ids []string
+	if options.AlbumId != "" {
+		album, ok := s.album(options.AlbumId)
+		if !ok {
+			s.mutex.Unlock()
+			writeError(w, http.StatusBadRequest, "Invalid album ID.")
+			return
+		}
+		ids = album.MediaItemIds
+	} else {
+		for _, id := range s.order {
+			if !filtered || matchesDates(s.items[id].item.Metadata.CreationTime, dateFilter) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	items := s.mediaItems(ids)
+	s.mutex.Unlock()
+
+	s.writeMediaItemsPage(w, items, strconv.Itoa(options.Size), options.Token)
+}
+
+func (s *Server) listAlbums(shared bool) http.HandlerFunc {
+	field := "albums"
+	if shared {
+		field = "sharedAlbums"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		s.mutex.Lock()
+		var albums []models.Album
+		for _, album := range s.albums {
+			if album.Shared == shared {
+				albums = append(albums, album.Album)
+			}
+		}
+		s.mutex.Unlock()
+
+		page, next, err := paginate(albums, query.Get("pageSize"), query.Get("pageToken"), 20, 50)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJson(w, map[string]interface{}{field: page, "nextPageToken": next})
+	}
+}
+
+// download serves the content of a media item at its base url, followed by =d for photos and =dv
+// for videos. Range requests are honoured, so downloads can be resumed
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	version, suffix, _ := strings.Cut(r.PathValue("version"), "=")
+	s.mutex.Lock()
+	entry, ok := s.items[r.PathValue("id")]
+	var current bool
+	var item MediaItem
+	var etag string
+	if ok {
+		current = version == "v"+strconv.Itoa(entry.version)
+		item = entry.item
+		etag = entry.etag
+	}
+	s.mutex.Unlock()
+
+	switch {
+	case !ok || !current:
+		writeError(w, http.StatusForbidden, "The base url has expired.")
+		return
+	case item.MimeType == "video/mp4" && suffix != "dv", item.MimeType != "video/mp4" && suffix != "d":
+		writeError(w, http.StatusBadRequest, "Invalid download parameter.")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", item.MimeType)
+	http.ServeContent(w, r, item.Filename, time.Time{}, bytes.NewReader(item.Content))
+}
+
+// mediaItems returns the media items with ids that are still in the library, with their current
+// base url
+func (s *Server) mediaItems(ids []string) []models.MediaItem {
+	items := make([]models.MediaItem, 0, len(ids))
+	for _, id := range ids {
+		entry, ok := s.items[id]
+		if !ok {
+			continue
+		}
+		item := entry.item.MediaItem
+		item.BaseUrl = fmt.Sprintf("%s/media/%s/v%d", s.server.URL, id, entry.version)
+		items = append(items, item)
+	}
+	return items
+}
+
+func (s *Server) album(id string) (Album, bool) {
+	for _, album := range s.albums {
+		if album.Id == id {
+			return album, true
+		}
+	}
+	return Album{}, false
+}
+
+func (s *Server) writeMediaItemsPage(w http.ResponseWriter, items []models.MediaItem, pageSize string, pageToken string) {
+	page, next, err := paginate(items, pageSize, pageToken, 25, 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJson(w, map[string]interface{}{"mediaItems": page, "nextPageToken": next})
+}
+
+// paginate returns the page of values starting at the offset in the page token. Like the api, a
+// missing or zero page size uses the default and larger ones are capped
+func paginate[T any](values []T, pageSize string, pageToken string, defaultSize int, maxSize int) (page []T, next string, err error) {
+	size, _ := strconv.Atoi(pageSize)
+	if size <= 0 {
+		size = defaultSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+
+	start := 0
+	if pageToken != "" {
+		decoded, decodeErr := base64.RawURLEncoding.DecodeString(pageToken)
+		start, err = strconv.Atoi(strings.TrimPrefix(string(decoded), "offset:"))
+		if decodeErr != nil || err != nil || start < 0 || start > len(values) {
+			return nil, "", fmt.Errorf("invalid page token '%s'", pageToken)
+		}
+	}
+
+	end := start + size
+	if end >= len(values) {
+		return values[start:], "", nil
+	}
+	return values[start:end], base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(end))), nil
+}
+
+// matchesDates reports whether created is on one of the dates or in one of the ranges of the filter
+func matchesDates(created time.Time, filter models.SearchDateFilter) bool {
+	year, month, day := created.Date()
+	date := year*10000 + int(month)*100 + day
+	for _, d := range filter.Dates {
+		if date == d.Year*10000+d.Month*100+d.Day {
+			return true
+		}
+	}
+	for _, r := range filter.Ranges {
+		start := r.StartDate.Year*10000 + r.StartDate.Month*100 + r.StartDate.Day
+		end := r.EndDate.Year*10000 + r.EndDate.Month*100 + r.EndDate.Day
+		if date >= start && date <= end {
+			return true
+		}
+	}
+	return false
+}
+
+var statuses = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+}
+
+// writeError answers like the api does, with the error in a json object
+func writeError(w http.ResponseWriter, code int, message string) {
+	status, ok := statuses[code]
+	if !ok {
+		status = "UNKNOWN"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message, "status": status},
+	})
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(body)
+}
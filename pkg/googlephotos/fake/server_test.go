@@ -0,0 +1,161 @@
+package fake_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/fake"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var created = time.Date(2021, 12, 3, 12, 0, 0, 0, time.UTC)
+
+func createServer(t *testing.T, items int) (*fake.Server, googlephotos.PhotosApi) {
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+	for i := 0; i < items; i++ {
+		server.AddMediaItems(fake.NewMediaItem(fmt.Sprintf("id%d", i), fmt.Sprintf("IMG_%d.jpg", i), created.AddDate(0, 0, i), fmt.Sprintf("content %d", i)))
+	}
+
+	api := googlephotos.NewPhotosApi(googlephotos.Options{BaseUrl: server.BaseUrl(), Client: server.Client(), Logger: utils.NewLogger(utils.Silent)})
+	return server, api
+}
+
+func download(t *testing.T, api googlephotos.PhotosApi, item models.MediaItem) (models.DownloadedFile, error) {
+	partial := models.PartialDownload{Path: filepath.Join(t.TempDir(), "item.part")}
+	return api.Download(context.Background(), partial, item.BaseUrl, item.MimeType != "video/mp4")
+}
+
+func TestServer_ListPagesThroughLibrary(t *testing.T) {
+	_, api := createServer(t, 5)
+
+	var ids []string
+	options := models.PagingOptions{Size: 2}
+	for pages := 1; ; pages++ {
+		items, err := api.List(context.Background(), options)
+		assert.NoError(t, err)
+		for _, item := range items.MediaItems {
+			ids = append(ids, item.Id)
+		}
+		if items.NextPageToken == "" {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		options.Token = items.NextPageToken
+	}
+	assert.Equal(t, []string{"id0", "id1", "id2", "id3", "id4"}, ids)
+
+	_, err := api.List(context.Background(), models.PagingOptions{Token: "garbage"})
+	assert.ErrorContains(t, err, "status code: 400")
+}
+
+func TestServer_BatchGetReportsMissingItems(t *testing.T) {
+	server, api := createServer(t, 3)
+	server.RemoveMediaItem("id1")
+
+	result, err := api.BatchGet(context.Background(), []string{"id0", "id1", "id2"})
+	assert.NoError(t, err)
+	assert.Len(t, result.MediaItems, 2)
+	assert.Equal(t, "id0", result.MediaItems[0].Id)
+	assert.Equal(t, "id2", result.MediaItems[1].Id)
+	assert.Equal(t, []models.ErrorResult{{Id: "id1", Status: models.ErrorStatus{Code: 3, Message: "Invalid media item ID."}}}, result.Errors)
+}
+
+func TestServer_SearchByDateAndAlbum(t *testing.T) {
+	server, api := createServer(t, 5)
+	server.AddAlbum(fake.Album{Album: models.Album{Id: "album", Title: "Holiday"}, MediaItemIds: []string{"id4", "id0"}})
+	server.AddAlbum(fake.Album{Album: models.Album{Id: "shared", Title: "Family"}, Shared: true})
+
+	byDate, err := api.Search(context.Background(), models.SearchOptions{Filters: models.SearchFilters{DateFilter: models.SearchDateFilter{
+		Ranges: []models.SearchDateRange{{StartDate: models.SearchDate{Year: 2021, Month: 12, Day: 5}, EndDate: models.SearchDate{Year: 2999, Month: 12, Day: 31}}},
+	}}})
+	assert.NoError(t, err)
+	assert.Len(t, byDate.MediaItems, 3)
+	assert.Equal(t, "id2", byDate.MediaItems[0].Id)
+
+	byAlbum, err := api.Search(context.Background(), models.SearchOptions{AlbumId: "album"})
+	assert.NoError(t, err)
+	assert.Len(t, byAlbum.MediaItems, 2)
+	assert.Equal(t, "id4", byAlbum.MediaItems[0].Id)
+
+	albums, err := api.ListAlbums(context.Background(), models.PagingOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, albums.Albums, 1)
+	assert.Equal(t, "2", albums.Albums[0].MediaItemsCount)
+
+	shared, err := api.ListSharedAlbums(context.Background(), models.PagingOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, shared.Albums, 1)
+	assert.Equal(t, "Family", shared.Albums[0].Title)
+}
+
+func TestServer_DownloadsContentUntilBaseUrlExpires(t *testing.T) {
+	server, api := createServer(t, 0)
+	server.AddMediaItems(fake.NewMediaItem("video", "clip.mp4", created, "moving pictures"))
+
+	item, err := api.Get(context.Background(), "video")
+	assert.NoError(t, err)
+	downloaded, err := download(t, api, item)
+	assert.NoError(t, err)
+	content, _ := os.ReadFile(downloaded.Path)
+	assert.Equal(t, "moving pictures", string(content))
+	assert.NotEmpty(t, downloaded.Validator)
+
+	server.ExpireBaseUrls()
+	_, err = download(t, api, item)
+	var apiError models.ApiError
+	assert.ErrorAs(t, err, &apiError)
+	assert.Equal(t, 403, apiError.StatusCode)
+
+	refreshed, err := api.Get(context.Background(), "video")
+	assert.NoError(t, err)
+	assert.NotEqual(t, item.BaseUrl, refreshed.BaseUrl)
+	_, err = download(t, api, refreshed)
+	assert.NoError(t, err)
+}
+
+func TestServer_DownloadResumesFromOffset(t *testing.T) {
+	server, api := createServer(t, 0)
+	server.AddMediaItems(fake.NewMediaItem("photo", "photo.jpg", created, "abcdefghij"))
+	item, err := api.Get(context.Background(), "photo")
+	assert.NoError(t, err)
+
+	first, err := download(t, api, item)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(first.Path, 4))
+
+	resumed, err := api.Download(context.Background(), models.PartialDownload{Path: first.Path, Validator: first.Validator}, item.BaseUrl, true)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Sha256, resumed.Sha256)
+	assert.Equal(t, int64(10), resumed.Size)
+}
+
+func TestServer_InjectedFaultsAndLatency(t *testing.T) {
+	server, api := createServer(t, 1)
+	server.Inject(fake.Fault{Endpoint: fake.MediaItemsGet, Status: 429, Times: 2, RetryAfter: "7"})
+
+	for i := 0; i < 2; i++ {
+		_, err := api.Get(context.Background(), "id0")
+		var apiError models.ApiError
+		assert.ErrorAs(t, err, &apiError)
+		assert.True(t, apiError.IsQuotaExhausted())
+		wait, ok := apiError.RetryAfter(time.Now())
+		assert.True(t, ok)
+		assert.Equal(t, 7*time.Second, wait)
+		assert.Equal(t, "RESOURCE_EXHAUSTED", apiError.Response.Status)
+	}
+
+	server.SetLatency(50 * time.Millisecond)
+	started := time.Now()
+	_, err := api.Get(context.Background(), "id0")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+	assert.Equal(t, 3, server.Requests(fake.MediaItemsGet))
+}
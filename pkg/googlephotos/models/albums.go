@@ -0,0 +1,60 @@
+package models
+
+import (
+	json2 "encoding/json"
+)
+
+type Albums struct {
+	Albums        []Album `json:"albums"`
+	NextPageToken string  `json:"nextPageToken,omitempty"`
+	Raw           string
+}
+
+type sharedAlbums struct {
+	SharedAlbums  []Album `json:"sharedAlbums"`
+	NextPageToken string  `json:"nextPageToken,omitempty"`
+}
+
+type Album struct {
+	Id                    string     `json:"id"`
+	Title                 string     `json:"title"`
+	ProductUrl            string     `json:"productUrl"`
+	IsWriteable           bool       `json:"isWriteable,omitempty"`
+	MediaItemsCount       string     `json:"mediaItemsCount,omitempty"`
+	CoverPhotoBaseUrl     string     `json:"coverPhotoBaseUrl,omitempty"`
+	CoverPhotoMediaItemId string     `json:"coverPhotoMediaItemId,omitempty"`
+	ShareInfo             *ShareInfo `json:"shareInfo,omitempty"`
+}
+
+type ShareInfo struct {
+	ShareableUrl string `json:"shareableUrl,omitempty"`
+	ShareToken   string `json:"shareToken,omitempty"`
+	IsJoined     bool   `json:"isJoined,omitempty"`
+	IsOwned      bool   `json:"isOwned,omitempty"`
+	IsJoinable   bool   `json:"isJoinable,omitempty"`
+}
+
+func (a Album) IsShared() bool {
+	return a.ShareInfo != nil
+}
+
+func DeserializeAlbumsJson(body []byte) (albums Albums, err error) {
+	albums.Raw = string(body)
+	err = json2.Unmarshal(body, &albums)
+	return
+}
+
+// DeserializeSharedAlbumsJson reads the response of sharedAlbums.list, which only differs from
+// albums.list in the name of the albums field
+func DeserializeSharedAlbumsJson(body []byte) (albums Albums, err error) {
+	var data sharedAlbums
+	err = json2.Unmarshal(body, &data)
+	if err != nil {
+		return
+	}
+
+	albums.Albums = data.SharedAlbums
+	albums.NextPageToken = data.NextPageToken
+	albums.Raw = string(body)
+	return
+}
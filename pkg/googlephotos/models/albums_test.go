@@ -0,0 +1,73 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeserializeAlbumsJson(t *testing.T) {
+	json := `{
+  "albums": [
+    {
+      "id": "AF1QipNdgo4b2TR3RfSfD7",
+      "title": "Summer Holiday 2021",
+      "productUrl": "https://photos.google.com/lr/album/AF1QipNdgo4b2TR3RfSfD7",
+      "isWriteable": true,
+      "mediaItemsCount": "132",
+      "coverPhotoBaseUrl": "https://lh3.googleusercontent.com/lr/AFBm1_cover",
+      "coverPhotoMediaItemId": "ALU181g0Vr1nSvTUkldVxUpM7pdR6U"
+    }
+  ],
+  "nextPageToken": "CkgKQnR5cG"
+}`
+	albums, err := DeserializeAlbumsJson([]byte(json))
+	assert.NoError(t, err)
+	assert.Equal(t, "CkgKQnR5cG", albums.NextPageToken)
+	assert.Equal(t, json, albums.Raw)
+	assert.Len(t, albums.Albums, 1)
+
+	album := albums.Albums[0]
+	assert.Equal(t, "AF1QipNdgo4b2TR3RfSfD7", album.Id)
+	assert.Equal(t, "Summer Holiday 2021", album.Title)
+	assert.Equal(t, "https://photos.google.com/lr/album/AF1QipNdgo4b2TR3RfSfD7", album.ProductUrl)
+	assert.True(t, album.IsWriteable)
+	assert.Equal(t, "132", album.MediaItemsCount)
+	assert.Equal(t, "https://lh3.googleusercontent.com/lr/AFBm1_cover", album.CoverPhotoBaseUrl)
+	assert.Equal(t, "ALU181g0Vr1nSvTUkldVxUpM7pdR6U", album.CoverPhotoMediaItemId)
+	assert.False(t, album.IsShared())
+}
+
+func TestDeserializeSharedAlbumsJson(t *testing.T) {
+	json := `{
+  "sharedAlbums": [
+    {
+      "id": "AF1QipMk3DGkd2qtA8aF",
+      "title": "Family",
+      "productUrl": "https://photos.google.com/lr/album/AF1QipMk3DGkd2qtA8aF",
+      "mediaItemsCount": "12",
+      "shareInfo": {
+        "shareableUrl": "https://photos.app.goo.gl/abc",
+        "shareToken": "AOVP2sRkr",
+        "isJoined": true,
+        "isOwned": false,
+        "isJoinable": true
+      }
+    }
+  ],
+  "nextPageToken": "CkgKQnR5cG"
+}`
+	albums, err := DeserializeSharedAlbumsJson([]byte(json))
+	assert.NoError(t, err)
+	assert.Equal(t, "CkgKQnR5cG", albums.NextPageToken)
+	assert.Equal(t, json, albums.Raw)
+	assert.Len(t, albums.Albums, 1)
+
+	album := albums.Albums[0]
+	assert.Equal(t, "AF1QipMk3DGkd2qtA8aF", album.Id)
+	assert.Equal(t, "Family", album.Title)
+	assert.True(t, album.IsShared())
+	assert.Equal(t, "https://photos.app.goo.gl/abc", album.ShareInfo.ShareableUrl)
+	assert.True(t, album.ShareInfo.IsJoined)
+	assert.False(t, album.ShareInfo.IsOwned)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type ApiError struct {
@@ -33,6 +35,32 @@ func (a ApiError) Error() string {
 	return fmt.Sprintf("Error (url: '%s', status code: %d) %s", a.Url, a.StatusCode, a.Raw)
 }
 
+// IsQuotaExhausted reports whether the api asked to slow down, rather than the request being wrong
+func (a ApiError) IsQuotaExhausted() bool {
+	return a.StatusCode == http.StatusTooManyRequests || a.StatusCode == http.StatusServiceUnavailable
+}
+
+// RetryAfter returns how long the Retry-After header asks to wait before trying again. The header
+// is either a number of seconds or a date
+func (a ApiError) RetryAfter(now time.Time) (time.Duration, bool) {
+	value := a.Headers.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
 type apiError struct {
 	Error apiErrorResponse `json:"error"`
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -40,3 +41,23 @@ func TestParseApiError(t *testing.T) {
 	expectedMessage := fmt.Sprintf("Error (url: 'http://google.com', status code: 400) %s", json)
 	assert.Equal(t, expectedMessage, err.Error())
 }
+
+func TestApiErrorRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	err := ApiError{StatusCode: 429, Headers: http.Header{"Retry-After": {"120"}}}
+	wait, ok := err.RetryAfter(now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, wait)
+	assert.True(t, err.IsQuotaExhausted())
+
+	err = ApiError{StatusCode: 503, Headers: http.Header{"Retry-After": {"Sun, 02 Jan 2022 03:04:35 GMT"}}}
+	wait, ok = err.RetryAfter(now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+
+	err = ApiError{StatusCode: 400, Headers: http.Header{}}
+	_, ok = err.RetryAfter(now)
+	assert.False(t, ok)
+	assert.False(t, err.IsQuotaExhausted())
+}
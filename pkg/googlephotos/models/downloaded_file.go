@@ -0,0 +1,20 @@
+package models
+
+// DownloadedFile is the file the content of a media item was streamed to. When a download fails
+// part way, Size is how much was written and Validator says whether the rest can be resumed
+type DownloadedFile struct {
+	Path string
+	Size int64
+	// Sha256 is the hex encoded checksum of the content
+	Sha256 string
+	// Validator is the strong etag or last modified date of the content, empty when the server
+	// doesn't support resuming
+	Validator string
+}
+
+// PartialDownload is the file a download is written to. Content already in it is only kept when
+// the server still has the content identified by Validator
+type PartialDownload struct {
+	Path      string
+	Validator string
+}
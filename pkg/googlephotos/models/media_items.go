@@ -17,13 +17,20 @@ type MediaItems struct {
 }
 
 type MediaItem struct {
-	Id          string        `json:"id"`
-	Description string        `json:"description,omitempty"`
-	ProductUrl  string        `json:"productUrl"`
-	BaseUrl     string        `json:"baseUrl"`
-	MimeType    string        `json:"mimeType"`
-	Metadata    MediaMetadata `json:"mediaMetadata"`
-	Filename    string        `json:"filename"`
+	Id              string          `json:"id"`
+	Description     string          `json:"description,omitempty"`
+	ProductUrl      string          `json:"productUrl"`
+	BaseUrl         string          `json:"baseUrl"`
+	MimeType        string          `json:"mimeType"`
+	Metadata        MediaMetadata   `json:"mediaMetadata"`
+	ContributorInfo ContributorInfo `json:"contributorInfo,omitzero"`
+	Filename        string          `json:"filename"`
+}
+
+// ContributorInfo is only returned for media items in shared albums
+type ContributorInfo struct {
+	ProfilePictureBaseUrl string `json:"profilePictureBaseUrl,omitempty"`
+	DisplayName           string `json:"displayName,omitempty"`
 }
 
 type MediaMetadata struct {
@@ -44,8 +51,10 @@ type MediaItemPhoto struct {
 }
 
 type MediaItemVideo struct {
-	Fps    float64 `json:"fps,omitempty"`
-	Status string  `json:"status,omitempty"`
+	CameraMake  string  `json:"cameraMake,omitempty"`
+	CameraModel string  `json:"cameraModel,omitempty"`
+	Fps         float64 `json:"fps,omitempty"`
+	Status      string  `json:"status,omitempty"`
 }
 
 func DeserializeMediaItemsJson(body []byte) (mediaItems MediaItems, err error) {
@@ -75,10 +75,16 @@ func TestDeserializeVideoMediaItemsJson(t *testing.T) {
         "width": "1920",
         "height": "1080",
         "video": {
+          "cameraMake": "Sony",
+          "cameraModel": "G8441",
           "fps": 60,
           "status": "READY"
         }
       },
+      "contributorInfo": {
+        "profilePictureBaseUrl": "https://lh3.googleusercontent.com/a/profile",
+        "displayName": "Jane"
+      },
       "filename": "20211227_175723.mp4"
     }
   ],
@@ -97,12 +103,16 @@ func TestDeserializeVideoMediaItemsJson(t *testing.T) {
 	assert.Equal(t, "video/mp4", mediaItem.MimeType)
 	assert.Equal(t, "20211227_175723.mp4", mediaItem.Filename)
 	assert.Equal(t, "cute video", mediaItem.Description)
+	assert.Equal(t, "Jane", mediaItem.ContributorInfo.DisplayName)
+	assert.Equal(t, "https://lh3.googleusercontent.com/a/profile", mediaItem.ContributorInfo.ProfilePictureBaseUrl)
 
 	creationTime, _ := time.Parse(time.RFC3339, "2021-12-27T17:59:09Z")
 	assert.Equal(t, creationTime, mediaItem.Metadata.CreationTime)
 	assert.Equal(t, "1920", mediaItem.Metadata.Width)
 	assert.Equal(t, "1080", mediaItem.Metadata.Height)
 	assert.Empty(t, mediaItem.Metadata.Photo)
+	assert.Equal(t, "Sony", mediaItem.Metadata.Video.CameraMake)
+	assert.Equal(t, "G8441", mediaItem.Metadata.Video.CameraModel)
 	assert.Equal(t, 60.0, mediaItem.Metadata.Video.Fps)
 	assert.Equal(t, "READY", mediaItem.Metadata.Video.Status)
 }
@@ -10,7 +10,7 @@ type SearchOptions struct {
 	AlbumId string        `json:"albumId,omitempty"`
 	Size    int           `json:"pageSize,omitempty"`
 	Token   string        `json:"pageToken,omitempty"`
-	Filters SearchFilters `json:"filters,omitempty"`
+	Filters SearchFilters `json:"filters,omitzero"`
 	OrderBy string        `json:"orderBy,omitempty"`
 }
 
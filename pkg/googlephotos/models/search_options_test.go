@@ -57,3 +57,17 @@ func TestSearchOptionsSerialization(t *testing.T) {
 }`
 	assert.Equal(t, expected, string(json))
 }
+
+func TestSearchOptionsSerializationForAlbum(t *testing.T) {
+	searchOptions := SearchOptions{AlbumId: "ALU181gS07lNXbEvg", Size: 100}
+
+	jsonBytes, err := searchOptions.Serialize()
+	assert.NoError(t, err)
+	json, err := io.ReadAll(jsonBytes)
+	assert.NoError(t, err)
+	expected := `{
+  "albumId": "ALU181gS07lNXbEvg",
+  "pageSize": 100
+}`
+	assert.Equal(t, expected, string(json))
+}
@@ -1,11 +1,17 @@
 package googlephotos
 
-import "github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+import (
+	"context"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos/models"
+)
 
 type Downloader interface {
-	Get(mediaItemId string) (mediaItem models.MediaItem, err error)
-	BatchGet(mediaItemIds []string) (mediaItems models.MediaItemsResult, err error)
-	List(options models.PagingOptions) (mediaItems models.MediaItems, err error)
-	Search(options models.SearchOptions) (mediaItems models.MediaItems, err error)
-	Download(tmpDir string, baseUrl string, isPhoto bool) (filePath string, err error)
+	Get(ctx context.Context, mediaItemId string) (mediaItem models.MediaItem, err error)
+	BatchGet(ctx context.Context, mediaItemIds []string) (mediaItems models.MediaItemsResult, err error)
+	List(ctx context.Context, options models.PagingOptions) (mediaItems models.MediaItems, err error)
+	Search(ctx context.Context, options models.SearchOptions) (mediaItems models.MediaItems, err error)
+	ListAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error)
+	ListSharedAlbums(ctx context.Context, options models.PagingOptions) (albums models.Albums, err error)
+	Download(ctx context.Context, partial models.PartialDownload, baseUrl string, isPhoto bool) (download models.DownloadedFile, err error)
 }
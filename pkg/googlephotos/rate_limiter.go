@@ -0,0 +1,156 @@
+package googlephotos
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// RequestKind is the quota a request counts against. Google limits api calls and requests for
+// media bytes separately
+type RequestKind int
+
+const (
+	MetadataRequest RequestKind = iota
+	MediaRequest
+)
+
+func (k RequestKind) String() string {
+	if k == MediaRequest {
+		return "media"
+	}
+	return "metadata"
+}
+
+// RateLimiter holds a request back until the budget of its kind allows it
+type RateLimiter interface {
+	Wait(ctx context.Context, kind RequestKind) error
+}
+
+type unlimited struct {
+}
+
+func (u unlimited) Wait(ctx context.Context, _ RequestKind) error {
+	return ctx.Err()
+}
+
+// TokenBucket lets through bursts of up to a tenth of the requests allowed per minute, and
+// otherwise spreads requests evenly over the minute
+type TokenBucket struct {
+	mutex    sync.Mutex
+	capacity float64
+	tokens   float64
+	// rate is the number of tokens added per second
+	rate float64
+	last time.Time
+	now  func() time.Time
+}
+
+func NewTokenBucket(perMinute int) *TokenBucket {
+	capacity := math.Max(1, float64(perMinute)/10)
+	return &TokenBucket{
+		capacity: capacity,
+		tokens:   capacity,
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Wait takes a token, blocking until one is available or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	wait := b.reserve()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.release()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, possibly one that hasn't been added yet, and returns how long until it is
+func (b *TokenBucket) reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// release gives back a reserved token that wasn't used
+func (b *TokenBucket) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = math.Min(b.capacity, b.tokens+1)
+}
+
+// BucketLimiter gives each kind of request its own token bucket
+type BucketLimiter struct {
+	buckets map[RequestKind]*TokenBucket
+}
+
+// NewRateLimiter limits metadata and media requests to the given number per minute. Zero doesn't
+// limit that kind of request
+func NewRateLimiter(metadataPerMinute, mediaPerMinute int) RateLimiter {
+	limiter := BucketLimiter{buckets: map[RequestKind]*TokenBucket{}}
+	if metadataPerMinute > 0 {
+		limiter.buckets[MetadataRequest] = NewTokenBucket(metadataPerMinute)
+	}
+	if mediaPerMinute > 0 {
+		limiter.buckets[MediaRequest] = NewTokenBucket(mediaPerMinute)
+	}
+	return limiter
+}
+
+func (l BucketLimiter) Wait(ctx context.Context, kind RequestKind) error {
+	bucket, ok := l.buckets[kind]
+	if !ok {
+		return ctx.Err()
+	}
+	return bucket.Wait(ctx)
+}
+
+// ByteLimiter holds back reading downloaded content, so downloads share the available bandwidth
+type ByteLimiter interface {
+	WaitN(ctx context.Context, bytes int) error
+}
+
+// limitedReader waits for the byte limiter after every read. Reads are kept small so the waits are
+// short and the transfer rate is even
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter ByteLimiter
+}
+
+const maxLimitedRead = 16 * 1024
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+
+	n, err := l.reader.Read(p)
+	if n > 0 {
+		waitErr := l.limiter.WaitN(l.ctx, n)
+		if waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+package googlephotos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_AllowsBurstThenSpreadsRequests(t *testing.T) {
+	now := time.Date(2021, 12, 3, 0, 0, 0, 0, time.UTC)
+	bucket := NewTokenBucket(120)
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	for i := 0; i < 12; i++ {
+		assert.Equal(t, time.Duration(0), bucket.reserve())
+	}
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve())
+	assert.Equal(t, time.Second, bucket.reserve())
+
+	// tokens that weren't used refill the bucket, up to its capacity
+	now = now.Add(time.Hour)
+	for i := 0; i < 12; i++ {
+		assert.Equal(t, time.Duration(0), bucket.reserve())
+	}
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve())
+}
+
+func TestTokenBucket_WaitGivesBackTokenWhenCancelled(t *testing.T) {
+	now := time.Date(2021, 12, 3, 0, 0, 0, 0, time.UTC)
+	bucket := NewTokenBucket(1)
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	assert.NoError(t, bucket.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, bucket.Wait(ctx), context.DeadlineExceeded)
+	assert.Equal(t, time.Minute, bucket.reserve())
+}
+
+func TestRateLimiter_ZeroDoesNotLimit(t *testing.T) {
+	limiter := NewRateLimiter(0, 1)
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, limiter.Wait(context.Background(), MetadataRequest))
+	}
+}
@@ -0,0 +1,246 @@
+// Package metrics keeps counters, histograms and gauges and writes them in the prometheus text
+// exposition format, without depending on the prometheus client
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric interface {
+	write(out *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were created, and serves them over http
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the text exposition format
+func (r *Registry) WriteText(out io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	writer := bufio.NewWriter(out)
+	for _, m := range metrics {
+		m.write(writer)
+	}
+	return writer.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = r.WriteText(w)
+}
+
+// series holds the label values of metrics with labels, keyed by the joined values so they are
+// written in a stable order
+type series[T any] struct {
+	labels []string
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func newSeries[T any](labels []string) series[T] {
+	return series[T]{labels: labels, values: map[string]*T{}, keys: map[string][]string{}}
+}
+
+func (s *series[T]) get(labelValues []string, create func() *T) *T {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(s.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := s.values[key]
+	if !ok {
+		value = create()
+		s.values[key] = value
+		s.keys[key] = append([]string(nil), labelValues...)
+	}
+	return value
+}
+
+func (s *series[T]) each(fn func(labels string, value *T)) {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fn(formatLabels(s.labels, s.keys[key]), s.values[key])
+	}
+}
+
+// Counter only goes up, e.g. requests made or bytes downloaded
+type Counter struct {
+	name   string
+	help   string
+	mutex  sync.Mutex
+	series series[float64]
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	counter := &Counter{name: name, help: help, series: newSeries[float64](labels)}
+	r.register(counter)
+	return counter
+}
+
+// Add adds value to the series with labelValues, given in the order of the labels of the counter
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	*c.series.get(labelValues, func() *float64 { return new(float64) }) += value
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(out *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeHeader(out, c.name, c.help, "counter")
+	c.series.each(func(labels string, value *float64) {
+		_, _ = fmt.Fprintf(out, "%s%s %s\n", c.name, labels, formatValue(*value))
+	})
+}
+
+// Histogram counts observations, e.g. durations, in buckets of their upper bounds
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mutex   sync.Mutex
+	series  series[histogramValue]
+}
+
+type histogramValue struct {
+	// counts are per bucket, the last one counts observations above every bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// DefaultBuckets suit durations in seconds from milliseconds to minutes
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	histogram := &Histogram{name: name, help: help, buckets: sorted, series: newSeries[histogramValue](labels)}
+	r.register(histogram)
+	return histogram
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	observed := h.series.get(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets)+1)}
+	})
+	observed.counts[sort.SearchFloat64s(h.buckets, value)]++
+	observed.sum += value
+	observed.count++
+}
+
+func (h *Histogram) write(out *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	writeHeader(out, h.name, h.help, "histogram")
+	h.series.each(func(labels string, value *histogramValue) {
+		var cumulative uint64
+		for i, count := range value.counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			_, _ = fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", formatValue(bound)), cumulative)
+		}
+		_, _ = fmt.Fprintf(out, "%s_sum%s %s\n", h.name, labels, formatValue(value.sum))
+		_, _ = fmt.Fprintf(out, "%s_count%s %d\n", h.name, labels, value.count)
+	})
+}
+
+// GaugeFunc is a value that can go up and down, read every time the metrics are written
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{name: name, help: help, value: value}
+	r.register(gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) write(out *bufio.Writer) {
+	writeHeader(out, g.name, g.help, "gauge")
+	_, _ = fmt.Fprintf(out, "%s %s\n", g.name, formatValue(g.value()))
+}
+
+func writeHeader(out *bufio.Writer, name string, help string, kind string) {
+	_, _ = fmt.Fprintf(out, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	_, _ = fmt.Fprintf(out, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to labels formatted by formatLabels
+func withLabel(labels string, name string, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value))
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header.Get("Content-Type"))
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestCounterIsServedWithSortedLabels(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Requests made.", "endpoint", "status")
+	requests.Inc("mediaItems.search", "200")
+	requests.Inc("mediaItems.get", "429")
+	requests.Add(2, "mediaItems.search", "200")
+
+	expected := `# HELP requests_total Requests made.
+# TYPE requests_total counter
+requests_total{endpoint="mediaItems.get",status="429"} 1
+requests_total{endpoint="mediaItems.search",status="200"} 3
+`
+	assert.Equal(t, expected, scrape(t, registry))
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	registry := NewRegistry()
+	bytes := registry.NewCounter("bytes_total", "Bytes downloaded.")
+	bytes.Add(1536)
+
+	assert.Contains(t, scrape(t, registry), "\nbytes_total 1536\n")
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	registry := NewRegistry()
+	durations := registry.NewHistogram("job_duration_seconds", "Duration of jobs.", []float64{5, 1}, "result")
+	durations.Observe(0.5, "downloaded")
+	durations.Observe(1, "downloaded")
+	durations.Observe(3, "downloaded")
+	durations.Observe(10, "downloaded")
+
+	expected := `# HELP job_duration_seconds Duration of jobs.
+# TYPE job_duration_seconds histogram
+job_duration_seconds_bucket{result="downloaded",le="1"} 2
+job_duration_seconds_bucket{result="downloaded",le="5"} 3
+job_duration_seconds_bucket{result="downloaded",le="+Inf"} 4
+job_duration_seconds_sum{result="downloaded"} 14.5
+job_duration_seconds_count{result="downloaded"} 4
+`
+	assert.Equal(t, expected, scrape(t, registry))
+}
+
+func TestGaugeFuncIsReadOnEveryScrape(t *testing.T) {
+	registry := NewRegistry()
+	depth := 3
+	registry.NewGaugeFunc("queue_depth", "Jobs waiting.", func() float64 { return float64(depth) })
+
+	assert.Contains(t, scrape(t, registry), "\nqueue_depth 3\n")
+	depth = 0
+	assert.Contains(t, scrape(t, registry), "\nqueue_depth 0\n")
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("errors_total", "Errors.", "error")
+	counter.Inc("a \"quoted\" \\ value\n")
+
+	body := strings.Split(scrape(t, registry), "\n")
+	assert.Equal(t, `errors_total{error="a \"quoted\" \\ value\n"} 1`, body[2])
+}
+
+func TestMetricsAreWrittenInTheOrderTheyWereCreated(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGaugeFunc("b_gauge", "Second.", func() float64 { return 1 })
+	registry.NewCounter("a_total", "First.").Inc()
+
+	body := scrape(t, registry)
+	assert.Less(t, strings.Index(body, "b_gauge"), strings.Index(body, "a_total"))
+}
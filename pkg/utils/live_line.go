@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"io"
+	"sync"
+)
+
+const clearLine = "\r\033[K"
+
+// LiveLine keeps a line, e.g. progress, at the bottom of a terminal. Everything else written to
+// it, such as log lines, is written above the line, which is then drawn again
+type LiveLine struct {
+	mutex sync.Mutex
+	out   io.Writer
+	line  string
+}
+
+func NewLiveLine(out io.Writer) *LiveLine {
+	return &LiveLine{out: out}
+}
+
+// Write clears the line, writes p and draws the line again
+func (l *LiveLine) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.line == "" {
+		return l.out.Write(p)
+	}
+
+	_, err := io.WriteString(l.out, clearLine)
+	if err != nil {
+		return 0, err
+	}
+	n, err := l.out.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(l.out, l.line)
+	return n, err
+}
+
+// Show replaces the line
+func (l *LiveLine) Show(line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.line = line
+	_, _ = io.WriteString(l.out, clearLine+line)
+}
+
+// Clear removes the line, so the terminal is left as it was
+func (l *LiveLine) Clear() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.line == "" {
+		return
+	}
+	l.line = ""
+	_, _ = io.WriteString(l.out, clearLine)
+}
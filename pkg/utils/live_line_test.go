@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveLineWritesLogLinesAboveTheLine(t *testing.T) {
+	builder := strings.Builder{}
+	live := NewLiveLine(&builder)
+	logger := NewLoggerWithOptions(WithLevel(Info), WithOut(live), WithFlags(0))
+
+	logger.Info.Print("before")
+	live.Show("queued 2")
+	logger.Info.Print("downloaded")
+	live.Show("queued 1")
+	live.Clear()
+	live.Clear()
+	logger.Info.Print("after")
+
+	assert.Equal(t, "[INFO] before\n"+
+		"\r\033[Kqueued 2"+
+		"\r\033[K[INFO] downloaded\nqueued 2"+
+		"\r\033[Kqueued 1"+
+		"\r\033[K"+
+		"[INFO] after\n", builder.String())
+}
+
+func TestLiveLineIsSafeForConcurrentLoggers(t *testing.T) {
+	builder := strings.Builder{}
+	live := NewLiveLine(&builder)
+	live.Show("progress")
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			logger := log.New(live, "", 0)
+			for j := 0; j < 50; j++ {
+				logger.Print("line")
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	assert.Equal(t, "\r\033[Kprogress"+strings.Repeat("\r\033[Kline\nprogress", 200), builder.String())
+}
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type LogLevel int64
@@ -20,6 +24,8 @@ type Logger struct {
 	out     io.Writer
 	level   LogLevel
 	flags   int
+	format  LogFormat
+	fields  []Field
 	Trace   *log.Logger
 	Debug   *log.Logger
 	Info    *log.Logger
@@ -29,42 +35,81 @@ type Logger struct {
 
 type Option func(svc *Logger)
 
+// noOpLogger is shared by the levels that aren't logged
+var noOpLogger = log.New(io.Discard, "", 0)
+
 func NewLogger(level LogLevel) Logger {
 	return NewLoggerWithOptions(WithLevel(level))
 }
 
 func NewLoggerWithOptions(opts ...Option) Logger {
 	defaultFlags := log.Ldate | log.Ltime | log.Lshortfile
-	logger := Logger{out: os.Stdout, level: Silent, flags: defaultFlags}
+	// stdout is kept for the output of commands, so it can be piped while the logs are collected
+	logger := Logger{out: os.Stderr, level: Silent, flags: defaultFlags}
 	for _, opt := range opts {
 		opt(&logger)
 	}
 
-	noOpLogger := log.New(io.Discard, "", 0)
+	logger.build()
+	return logger
+}
+
+func (l *Logger) build() {
+	l.Trace = noOpLogger
+	l.Debug = noOpLogger
+	l.Info = noOpLogger
+	l.Error = noOpLogger
+	// Default is for prompts, which are shown whatever the level
+	l.Default = l.levelLogger(Info, "")
 
-	logger.Trace = noOpLogger
-	logger.Debug = noOpLogger
-	logger.Info = noOpLogger
-	logger.Error = noOpLogger
-	logger.Default = log.Default()
+	if Error <= l.level {
+		l.Error = l.levelLogger(Error, "[ERROR] ")
+	}
 
-	if Error <= logger.level {
-		logger.Error = log.New(logger.out, "[ERROR] ", logger.flags)
+	if Info <= l.level {
+		l.Info = l.levelLogger(Info, "[INFO] ")
 	}
 
-	if Info <= logger.level {
-		logger.Info = log.New(logger.out, "[INFO] ", logger.flags)
+	if Debug <= l.level {
+		l.Debug = l.levelLogger(Debug, "[DEBUG] ")
 	}
 
-	if Debug <= logger.level {
-		logger.Debug = log.New(logger.out, "[DEBUG] ", logger.flags)
+	if Trace <= l.level {
+		l.Trace = l.levelLogger(Trace, "[TRACE] ")
 	}
+}
 
-	if Trace <= logger.level {
-		logger.Trace = log.New(logger.out, "[TRACE] ", logger.flags)
+func (l *Logger) levelLogger(level LogLevel, prefix string) *log.Logger {
+	if l.format == JsonFormat {
+		return log.New(&jsonWriter{out: l.out, level: level, fields: l.fields, now: time.Now}, "", 0)
 	}
 
-	return logger
+	for _, field := range l.fields {
+		if field.Key == jobIdKey {
+			prefix += fmt.Sprintf("(id: %v) ", field.Value)
+		}
+	}
+	return log.New(l.out, prefix, l.flags)
+}
+
+// With returns a logger that adds fields to every line it logs in json. Text lines only show the
+// job id, ahead of the message, their messages already mention the rest. The loggers of the levels
+// are reused unless the lines they write change
+func (l Logger) With(fields ...Field) Logger {
+	l.fields = append(append([]Field{}, l.fields...), fields...)
+	if l.format == JsonFormat || hasField(fields, jobIdKey) {
+		l.build()
+	}
+	return l
+}
+
+func hasField(fields []Field, key string) bool {
+	for _, field := range fields {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
 }
 
 func WithOut(out io.Writer) Option {
@@ -84,3 +129,43 @@ func WithFlags(flags int) Option {
 		logger.flags = flags
 	}
 }
+
+func WithFormat(format LogFormat) Option {
+	return func(logger *Logger) {
+		logger.format = format
+	}
+}
+
+var logLevelNames = map[LogLevel]string{
+	Silent: "silent",
+	Error:  "error",
+	Info:   "info",
+	Debug:  "debug",
+	Trace:  "trace",
+}
+
+func ParseLogLevel(level string) (LogLevel, error) {
+	for logLevel, name := range logLevelNames {
+		if strings.EqualFold(name, level) {
+			return logLevel, nil
+		}
+	}
+	return Silent, fmt.Errorf("unknown log level '%s'", level)
+}
+
+func (l LogLevel) String() string {
+	name, ok := logLevelNames[l]
+	if !ok {
+		return strconv.FormatInt(int64(l), 10)
+	}
+	return name
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) (err error) {
+	*l, err = ParseLogLevel(string(text))
+	return
+}
@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"errors"
+	"log"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -55,3 +59,112 @@ func TestLogLevelTrace(t *testing.T) {
 	lines := "[ERROR] error line\n[INFO] info line\n[DEBUG] debug line\n[TRACE] trace line\n"
 	assert.Equal(t, lines, builder.String())
 }
+
+func TestParseLogLevel(t *testing.T) {
+	type testCase struct {
+		level    string
+		expected LogLevel
+	}
+	testCases := []testCase{
+		{level: "silent", expected: Silent},
+		{level: "error", expected: Error},
+		{level: "INFO", expected: Info},
+		{level: "Debug", expected: Debug},
+		{level: "trace", expected: Trace},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.level, func(t *testing.T) {
+			level, err := ParseLogLevel(tc.level)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, level)
+		})
+	}
+
+	_, err := ParseLogLevel("verbose")
+	assert.EqualError(t, err, "unknown log level 'verbose'")
+}
+
+func TestLogLevelTextRoundTrip(t *testing.T) {
+	text, err := Debug.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", string(text))
+
+	var level LogLevel
+	err = level.UnmarshalText(text)
+	assert.NoError(t, err)
+	assert.Equal(t, Debug, level)
+}
+
+func TestJsonFormat(t *testing.T) {
+	builder := strings.Builder{}
+	logger := NewLoggerWithOptions(WithLevel(Debug), WithOut(&builder), WithFormat(JsonFormat))
+	now := time.Date(2021, 12, 3, 19, 54, 5, 0, time.UTC)
+	for _, writer := range []*log.Logger{logger.Error, logger.Info, logger.Debug, logger.Default} {
+		writer.Writer().(*jsonWriter).now = func() time.Time { return now }
+	}
+
+	logger.Info.Printf("downloaded '%s'\n", "2021/photo.jpg")
+	logger.Debug.Print("(id: not parsed) plain message")
+	logger.Trace.Print("not logged")
+	logger.Default.Print("please authorize")
+
+	lines := `{"time":"2021-12-03T19:54:05Z","level":"info","msg":"downloaded '2021/photo.jpg'"}
+{"time":"2021-12-03T19:54:05Z","level":"debug","msg":"(id: not parsed) plain message"}
+{"time":"2021-12-03T19:54:05Z","level":"info","msg":"please authorize"}
+`
+	assert.Equal(t, lines, builder.String())
+}
+
+func TestJsonFormatWithFields(t *testing.T) {
+	builder := strings.Builder{}
+	logger := NewLoggerWithOptions(WithLevel(Info), WithOut(&builder), WithFormat(JsonFormat)).
+		With(JobId("a) b")).
+		With(RemoteId("remote"), Path("2021/photo.jpg"), Err(errors.New("failed")), Duration(1500*time.Millisecond))
+	logger.Error.Writer().(*jsonWriter).now = func() time.Time { return time.Date(2021, 12, 3, 19, 54, 5, 0, time.UTC) }
+
+	logger.Error.Print("download failed")
+
+	line := `{"time":"2021-12-03T19:54:05Z","level":"error","msg":"download failed","job_id":"a) b","remote_id":"remote","path":"2021/photo.jpg","error":"failed","duration":1.5}` + "\n"
+	assert.Equal(t, line, builder.String())
+}
+
+func TestWithLeavesTextLinesUnchanged(t *testing.T) {
+	builder := strings.Builder{}
+	logger := NewLoggerWithOptions(WithLevel(Info), WithOut(&builder), WithFlags(0)).With(Path("photo.jpg"))
+
+	logger.Info.Print("info line")
+
+	assert.Equal(t, "[INFO] info line\n", builder.String())
+}
+
+func TestWithReusesTextLoggersUnlessTheJobIdIsAdded(t *testing.T) {
+	builder := strings.Builder{}
+	logger := NewLoggerWithOptions(WithLevel(Info), WithOut(&builder), WithFlags(0))
+
+	assert.Same(t, logger.Info, logger.With(Path("photo.jpg")).Info)
+
+	job := logger.With(JobId("abc"))
+	job.Info.Print("downloaded")
+	job.With(Path("photo.jpg")).Error.Print("failed")
+
+	assert.Equal(t, "[INFO] (id: abc) downloaded\n[ERROR] (id: abc) failed\n", builder.String())
+}
+
+func TestLogsGoToStderr(t *testing.T) {
+	logger := NewLogger(Info)
+	assert.Equal(t, os.Stderr, logger.out)
+	assert.Equal(t, os.Stderr, logger.Default.Writer())
+}
+
+func TestParseLogFormat(t *testing.T) {
+	format, err := ParseLogFormat("JSON")
+	assert.NoError(t, err)
+	assert.Equal(t, JsonFormat, format)
+
+	var text LogFormat
+	assert.NoError(t, text.UnmarshalText([]byte("text")))
+	assert.Equal(t, TextFormat, text)
+
+	_, err = ParseLogFormat("xml")
+	assert.EqualError(t, err, "unknown log format 'xml'")
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogFormat is how log lines are written, text for people or json for log collectors
+type LogFormat int
+
+const (
+	TextFormat LogFormat = iota
+	JsonFormat
+)
+
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch strings.ToLower(format) {
+	case "text":
+		return TextFormat, nil
+	case "json":
+		return JsonFormat, nil
+	}
+	return TextFormat, fmt.Errorf("unknown log format '%s'", format)
+}
+
+func (f LogFormat) String() string {
+	if f == JsonFormat {
+		return "json"
+	}
+	return "text"
+}
+
+func (f LogFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *LogFormat) UnmarshalText(text []byte) (err error) {
+	*f, err = ParseLogFormat(string(text))
+	return
+}
+
+// Field is a key and value added to log lines
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+const jobIdKey = "job_id"
+
+// JobId is shown in text lines too, so the lines of concurrent download jobs can be told apart
+func JobId(id string) Field {
+	return Field{Key: jobIdKey, Value: id}
+}
+
+func RemoteId(id string) Field {
+	return Field{Key: "remote_id", Value: id}
+}
+
+func Path(path string) Field {
+	return Field{Key: "path", Value: path}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Duration is logged in seconds in json, so it can be summed and graphed
+func Duration(duration time.Duration) Field {
+	return Field{Key: "duration", Value: duration}
+}
+
+// jsonValue converts values that don't marshal to something useful
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.Seconds()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// jsonWriter turns every line written by a log.Logger into a json object
+type jsonWriter struct {
+	out    io.Writer
+	level  LogLevel
+	fields []Field
+	now    func() time.Time
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	fields := []Field{
+		{Key: "time", Value: w.now().UTC().Format(time.RFC3339Nano)},
+		{Key: "level", Value: w.level.String()},
+		{Key: "msg", Value: message},
+	}
+
+	seen := map[string]bool{}
+	for _, field := range fields {
+		seen[field.Key] = true
+	}
+	for _, field := range w.fields {
+		if !seen[field.Key] {
+			fields = append(fields, field)
+		}
+	}
+
+	line := bytes.Buffer{}
+	line.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.Key)
+		value, err := json.Marshal(jsonValue(field.Value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(field.Value))
+		}
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+
+	_, err := w.out.Write(line.Bytes())
+	return len(p), err
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"fmt"
 	"io"
 	"net/url"
+	"strconv"
+	"strings"
 )
 
 func CheckClose(c io.Closer, err *error) {
@@ -29,3 +32,49 @@ func BuildUrl(baseUrl string, queryString map[string][]string) (*url.URL, error)
 	fullUrl.RawQuery = q.Encode()
 	return fullUrl, nil
 }
+
+// FormatBytes formats a number of bytes with a binary unit, e.g. 1.5 MiB
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	divisor, exponent := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(divisor), "KMGTPE"[exponent])
+}
+
+// byteUnits are the suffixes ParseBytes understands, longest first so KiB isn't read as B
+var byteUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseBytes parses a number of bytes with an optional decimal or binary unit, e.g. 2MB or 1.5MiB.
+// Units are case insensitive
+func ParseBytes(text string) (int64, error) {
+	value := strings.TrimSpace(text)
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToLower(value), strings.ToLower(unit.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid number of bytes '%s'", text)
+	}
+	return int64(number * float64(multiplier)), nil
+}
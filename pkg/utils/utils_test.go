@@ -30,3 +30,35 @@ func TestBuildUrlWithArrayQueryString(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "http://www.google.com?ids=23&ids=abc", newUrl.String())
 }
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", FormatBytes(0))
+	assert.Equal(t, "1023 B", FormatBytes(1023))
+	assert.Equal(t, "1.0 KiB", FormatBytes(1024))
+	assert.Equal(t, "1.5 MiB", FormatBytes(1536*1024))
+	assert.Equal(t, "2.0 GiB", FormatBytes(2*1024*1024*1024))
+}
+
+func TestParseBytes(t *testing.T) {
+	testCases := map[string]int64{
+		"0":      0,
+		"512":    512,
+		"512B":   512,
+		"2MB":    2000000,
+		"2 mb":   2000000,
+		"1.5MiB": 1536 * 1024,
+		"1KiB":   1024,
+		"1k":     1000,
+		"3G":     3000000000,
+	}
+	for text, expected := range testCases {
+		bytes, err := ParseBytes(text)
+		assert.NoError(t, err, text)
+		assert.Equal(t, expected, bytes, text)
+	}
+
+	for _, text := range []string{"", "MB", "-1MB", "2TB"} {
+		_, err := ParseBytes(text)
+		assert.Error(t, err, text)
+	}
+}
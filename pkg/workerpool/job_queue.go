@@ -3,11 +3,16 @@
 
 package workerpool
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Job - interface for job processing
 type Job interface {
-	Process()
+	Process(ctx context.Context)
 }
 
 // JobQueue - a queue for enqueueing jobs to be processed
@@ -18,15 +23,37 @@ type JobQueue struct {
 	dispatcherStopped *sync.WaitGroup
 	workersStopped    *sync.WaitGroup
 	quit              chan bool
+	ctx               context.Context
+	cancelJobs        context.CancelFunc
+	// depth counts jobs submitted and not yet finished, pending lets Drain wait for them
+	depth   *atomic.Int64
+	pending *sync.WaitGroup
 }
 
-// NewJobQueue - creates a new job queue
-func NewJobQueue(maxWorkers int) *JobQueue {
+// countedJob takes a job off the depth of the queue once it has been processed
+type countedJob struct {
+	job   Job
+	queue *JobQueue
+}
+
+func (c countedJob) Process(ctx context.Context) {
+	defer c.queue.done()
+	c.job.Process(ctx)
+}
+
+// NewJobQueue - creates a new job queue. Once ctx is done no more jobs are dispatched, jobs in
+// progress get gracePeriod to finish before the context they were given is cancelled too
+func NewJobQueue(ctx context.Context, maxWorkers int, gracePeriod time.Duration) *JobQueue {
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(gracePeriod, cancelJobs)
+	})
+
 	workersStopped := sync.WaitGroup{}
 	readyPool := make(chan chan Job, maxWorkers)
 	workers := make([]*Worker, maxWorkers)
 	for i := 0; i < maxWorkers; i++ {
-		workers[i] = NewWorker(readyPool, &workersStopped)
+		workers[i] = NewWorker(jobCtx, readyPool, &workersStopped)
 	}
 	return &JobQueue{
 		internalQueue:     make(chan Job),
@@ -35,6 +62,10 @@ func NewJobQueue(maxWorkers int) *JobQueue {
 		dispatcherStopped: &sync.WaitGroup{},
 		workersStopped:    &workersStopped,
 		quit:              make(chan bool),
+		ctx:               ctx,
+		cancelJobs:        cancelJobs,
+		depth:             &atomic.Int64{},
+		pending:           &sync.WaitGroup{},
 	}
 }
 
@@ -43,22 +74,27 @@ func (q *JobQueue) Start() {
 	for i := 0; i < len(q.workers); i++ {
 		q.workers[i].Start()
 	}
+	q.dispatcherStopped.Add(1)
 	go q.dispatch()
 }
 
-// Stop - stops the workers and dispatcher routine
+// Stop - stops the workers and dispatcher routine, waiting for jobs in progress
 func (q *JobQueue) Stop() {
 	q.quit <- true
 	q.dispatcherStopped.Wait()
+	q.cancelJobs()
 }
 
 func (q *JobQueue) dispatch() {
-	q.dispatcherStopped.Add(1)
 	for {
 		select {
 		case job := <-q.internalQueue: // We got something in on our queue
-			workerChannel := <-q.readyPool // Check out an available worker
-			workerChannel <- job           // Send the request to the channel
+			select {
+			case workerChannel := <-q.readyPool: // Check out an available worker
+				workerChannel <- job // Send the request to the channel
+			case <-q.ctx.Done(): // cancelled while waiting for a worker, the job is dropped
+				q.done()
+			}
 		case <-q.quit:
 			for i := 0; i < len(q.workers); i++ {
 				q.workers[i].Stop()
@@ -70,7 +106,35 @@ func (q *JobQueue) dispatch() {
 	}
 }
 
-// Submit - adds a new job to be processed
-func (q *JobQueue) Submit(job Job) {
-	q.internalQueue <- job
+// Submit - adds a new job to be processed, fails once the context of the queue is done
+func (q *JobQueue) Submit(job Job) error {
+	if err := q.ctx.Err(); err != nil {
+		return err
+	}
+
+	q.depth.Add(1)
+	q.pending.Add(1)
+	select {
+	case q.internalQueue <- countedJob{job: job, queue: q}:
+		return nil
+	case <-q.ctx.Done():
+		q.done()
+		return q.ctx.Err()
+	}
+}
+
+func (q *JobQueue) done() {
+	q.depth.Add(-1)
+	q.pending.Done()
+}
+
+// Drain - waits for the jobs submitted so far to finish, leaving the workers running for more. No
+// jobs may be submitted while draining
+func (q *JobQueue) Drain() {
+	q.pending.Wait()
+}
+
+// Depth - the number of jobs submitted that haven't finished yet, including the ones in progress
+func (q *JobQueue) Depth() int {
+	return int(q.depth.Load())
 }
@@ -0,0 +1,154 @@
+package workerpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type funcJob func(ctx context.Context)
+
+func (f funcJob) Process(ctx context.Context) {
+	f(ctx)
+}
+
+// blockingJob starts and waits for release before it finishes
+func blockingJob(started chan<- struct{}, release <-chan struct{}) funcJob {
+	return func(ctx context.Context) {
+		started <- struct{}{}
+		<-release
+	}
+}
+
+func startJobQueue(t *testing.T, ctx context.Context, maxWorkers int, gracePeriod time.Duration) *JobQueue {
+	queue := NewJobQueue(ctx, maxWorkers, gracePeriod)
+	queue.Start()
+	t.Cleanup(queue.Stop)
+	return queue
+}
+
+func TestJobQueueDrainWaitsForJobsInProgress(t *testing.T) {
+	queue := startJobQueue(t, context.Background(), 2, time.Second)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.NoError(t, queue.Submit(blockingJob(started, release)))
+	assert.NoError(t, queue.Submit(blockingJob(started, release)))
+	<-started
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		queue.Drain()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		t.Fatal("drained while jobs were in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained once the jobs finished")
+	}
+}
+
+func TestJobQueueDepthReturnsToZero(t *testing.T) {
+	queue := startJobQueue(t, context.Background(), 2, time.Second)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.Equal(t, 0, queue.Depth())
+
+	assert.NoError(t, queue.Submit(blockingJob(started, release)))
+	assert.NoError(t, queue.Submit(blockingJob(started, release)))
+	<-started
+	<-started
+	assert.Equal(t, 2, queue.Depth())
+
+	close(release)
+	queue.Drain()
+	assert.Equal(t, 0, queue.Depth())
+
+	processed := atomic.Int64{}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, queue.Submit(funcJob(func(ctx context.Context) { processed.Add(1) })))
+	}
+	queue.Drain()
+	assert.Equal(t, int64(10), processed.Load())
+	assert.Equal(t, 0, queue.Depth())
+}
+
+func TestJobQueueCancellationStopsNewJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := startJobQueue(t, ctx, 1, time.Second)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.NoError(t, queue.Submit(blockingJob(started, release)))
+	<-started
+
+	// the only worker is busy, so this job waits in the dispatcher
+	waiting := atomic.Bool{}
+	assert.NoError(t, queue.Submit(funcJob(func(ctx context.Context) { waiting.Store(true) })))
+	cancel()
+
+	submitted := atomic.Bool{}
+	err := queue.Submit(funcJob(func(ctx context.Context) { submitted.Store(true) }))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the waiting job is dropped before the worker is free again
+	assert.Eventually(t, func() bool { return queue.Depth() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	queue.Drain()
+	assert.False(t, waiting.Load())
+	assert.False(t, submitted.Load())
+	assert.Equal(t, 0, queue.Depth())
+}
+
+func TestJobQueueCancelsJobsPastTheGracePeriod(t *testing.T) {
+	gracePeriod := 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := startJobQueue(t, ctx, 2, gracePeriod)
+
+	started := make(chan struct{})
+	finished := make(chan time.Time, 2)
+	stubborn := funcJob(func(ctx context.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		finished <- time.Now()
+	})
+	quick := make(chan error, 1)
+	release := make(chan struct{})
+	polite := funcJob(func(ctx context.Context) {
+		started <- struct{}{}
+		<-release
+		quick <- ctx.Err()
+	})
+	assert.NoError(t, queue.Submit(stubborn))
+	assert.NoError(t, queue.Submit(polite))
+	<-started
+	<-started
+
+	cancelled := time.Now()
+	cancel()
+
+	// a job finishing within the grace period still has a live context
+	close(release)
+	assert.NoError(t, <-quick)
+
+	select {
+	case at := <-finished:
+		assert.GreaterOrEqual(t, at.Sub(cancelled), gracePeriod)
+	case <-time.After(time.Second):
+		t.Fatal("job context not cancelled after the grace period")
+	}
+	queue.Drain()
+	assert.Equal(t, 0, queue.Depth())
+}
@@ -3,10 +3,14 @@
 
 package workerpool
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Worker - the worker threads that actually process the jobs
 type Worker struct {
+	ctx              context.Context
 	done             *sync.WaitGroup
 	readyPool        chan chan Job
 	assignedJobQueue chan Job
@@ -15,8 +19,9 @@ type Worker struct {
 }
 
 // NewWorker - creates a new worker
-func NewWorker(readyPool chan chan Job, done *sync.WaitGroup) *Worker {
+func NewWorker(ctx context.Context, readyPool chan chan Job, done *sync.WaitGroup) *Worker {
 	return &Worker{
+		ctx:              ctx,
 		done:             done,
 		readyPool:        readyPool,
 		assignedJobQueue: make(chan Job),
@@ -32,7 +37,7 @@ func (w *Worker) Start() {
 			w.readyPool <- w.assignedJobQueue // check the job queue in
 			select {
 			case job := <-w.assignedJobQueue: // see if anything has been assigned to the queue
-				job.Process()
+				job.Process(w.ctx)
 			case <-w.quit:
 				w.done.Done()
 				return
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// cancelOnSignal returns a context that is cancelled by SIGINT or SIGTERM. Only the first signal is
+// caught, so a second one stops the process straight away
+func cancelOnSignal(logger utils.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Info.Printf("received %s, stopping after downloads in progress, send it again to stop straight away", sig)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rjnienaber/gphotos_downloader/internal/database"
+	"github.com/rjnienaber/gphotos_downloader/internal/layout"
+	photoOauth "github.com/rjnienaber/gphotos_downloader/internal/oauth2"
+	"github.com/rjnienaber/gphotos_downloader/internal/options"
+	"github.com/rjnienaber/gphotos_downloader/internal/services"
+	"github.com/rjnienaber/gphotos_downloader/pkg/googlephotos"
+	"github.com/rjnienaber/gphotos_downloader/pkg/utils"
+)
+
+// staleTempFileAge is how old a temporary file has to be before it is considered left behind.
+// Younger partial downloads are kept to be resumed, and younger temporary files of older versions,
+// which didn't lock the library, may belong to a run that is still going
+const staleTempFileAge = 24 * time.Hour
+
+// throughputInterval is how often the throughput of downloads, and the progress when not on a
+// terminal, is logged
+const throughputInterval = 30 * time.Second
+
+// liveProgressInterval is how often the progress line is redrawn on a terminal
+const liveProgressInterval = time.Second
+
+// app is the composition root shared by all commands. The database is opened
+// eagerly, everything that needs the api is only created when a command asks for it
+type app struct {
+	// ctx is cancelled when the process is asked to stop
+	ctx      context.Context
+	opts     options.Options
+	logger   utils.Logger
+	db       database.PhotoDatabase
+	out      io.Writer
+	errOut   io.Writer
+	progress *services.Progress
+	// metrics is nil unless they are served
+	metrics       *services.Metrics
+	metricsServer *http.Server
+	api           googlephotos.Downloader
+	usage         *services.UsageCounter
+	bandwidth     *services.BandwidthLimiter
+	download      *services.DownloadService
+	// stopReporting stops logging the throughput of downloads
+	stopReporting context.CancelFunc
+	layout        *layout.Template
+	// locked is set when the run holds the library lock, so no other run is using the staging directory
+	locked bool
+}
+
+func wireUp(ctx context.Context, opts options.Options, logger utils.Logger, out io.Writer, errOut io.Writer) (*app, error) {
+	db, err := database.NewDatabase(
+		database.WithFileConnection(opts.LibraryRoot, logger),
+		database.WithLogger(logger),
+	)
+	if err != nil {
+		return nil, withExitCode(exitDatabase, err)
+	}
+
+	a := &app{ctx: ctx, opts: opts, logger: logger, db: db, out: out, errOut: errOut, progress: services.NewProgress()}
+	if opts.MetricsAddress != "" {
+		err = a.serveMetrics()
+		if err != nil {
+			_ = db.Close()
+			return nil, withExitCode(exitConfig, err)
+		}
+	}
+	return a, nil
+}
+
+// serveMetrics serves the metrics at /metrics of the metrics address until the app is closed
+func (a *app) serveMetrics() error {
+	listener, err := net.Listen("tcp", a.opts.MetricsAddress)
+	if err != nil {
+		return err
+	}
+
+	a.metrics = services.NewMetrics(a.db, a.opts.IncludeShared)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.metrics.Registry)
+	a.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := a.metricsServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error.Printf("serving metrics failed: %s", err)
+		}
+	}()
+	a.logger.Info.Printf("serving metrics on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+func (a *app) tokenService() (photoOauth.TokenService, error) {
+	tokenService, err := photoOauth.NewTokenService(a.opts.ClientSecretPath, a.opts.Headless, a.db, a.logger)
+	if err != nil {
+		return photoOauth.TokenService{}, withExitCode(exitConfig, err)
+	}
+	return tokenService, nil
+}
+
+// photosApi returns the rate limited api, retrying calls that fail with network errors or because
+// the api asked to slow down
+func (a *app) photosApi() (googlephotos.Downloader, error) {
+	if a.api != nil {
+		return a.api, nil
+	}
+
+	tokenService, err := a.tokenService()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenSource, err := tokenService.TokenSource(a.ctx)
+	if err != nil {
+		return nil, withExitCode(exitAuth, err)
+	}
+
+	a.bandwidth = services.NewBandwidthLimiter(a.opts.Bandwidth, a.logger)
+	photosApi := googlephotos.NewPhotosApi(googlephotos.Options{
+		TokenSource: tokenSource,
+		Limiter:     a.rateLimiter(),
+		Bandwidth:   a.bandwidth,
+		Observer:    a.metrics,
+		Logger:      a.logger,
+	})
+	a.api = services.NewRetryingDownloader(&photosApi, a.metrics.CountRetries(newRetryFactory(), "api"), services.NewBackoff(), a.logger)
+	return a.api, nil
+}
+
+// rateLimiter counts the requests towards the api usage stored in the library, except for dry runs
+// which leave the library as it is
+func (a *app) rateLimiter() googlephotos.RateLimiter {
+	limiter := googlephotos.NewRateLimiter(a.opts.RequestsPerMinute, a.opts.MediaRequestsPerMinute)
+	if a.opts.DryRun {
+		return limiter
+	}
+
+	a.usage = services.NewUsageCounter(limiter, a.db, a.logger)
+	return a.usage
+}
+
+func newRetryFactory() services.RetryFactory {
+	return services.NewExponentialRetryFactory(services.DefaultErrorClassifier{})
+}
+
+func (a *app) downloadService() (*services.DownloadService, error) {
+	if a.download != nil {
+		a.startReporting()
+		return a.download, nil
+	}
+
+	photosApi, err := a.photosApi()
+	if err != nil {
+		return nil, err
+	}
+
+	a.sweepStaging()
+	downloader := services.NewDownloadService(a.ctx, photosApi, a.db, a.opts.LibraryRoot,
+		services.WithLogger(a.logger),
+		services.WithRetryFactory(a.metrics.CountRetries(newRetryFactory(), "download")),
+		services.WithMaxWorkers(a.opts.Workers),
+		services.WithProgress(a.progress),
+		services.WithMetrics(a.metrics),
+	)
+	a.download = &downloader
+	a.startReporting()
+	return a.download, nil
+}
+
+// startReporting logs the throughput and shows the progress of downloads until they are finished
+func (a *app) startReporting() {
+	if a.stopReporting != nil {
+		return
+	}
+
+	var reportCtx context.Context
+	reportCtx, a.stopReporting = context.WithCancel(a.ctx)
+	go a.bandwidth.ReportThroughput(reportCtx, throughputInterval)
+	if live, ok := a.errOut.(*utils.LiveLine); ok {
+		go a.progress.Report(reportCtx, live, liveProgressInterval, a.logger)
+	} else {
+		go a.progress.Report(reportCtx, nil, throughputInterval, a.logger)
+	}
+}
+
+// sweepStaging removes temporary files left behind by runs that were killed. It is only worth
+// reporting, a failure doesn't stop downloads
+func (a *app) sweepStaging() {
+	if !a.locked {
+		a.logger.Debug.Print("not removing stale temporary files, the library isn't locked by this run")
+		return
+	}
+
+	report, err := services.SweepStaging(a.opts.LibraryRoot, staleTempFileAge, time.Now(), a.logger)
+	if err != nil {
+		a.logger.Error.Printf("removing stale temporary files failed: %s", err)
+	}
+	if report.Removed > 0 {
+		a.logger.Info.Printf("removed %d stale temporary files, reclaiming %s", report.Removed, utils.FormatBytes(report.Bytes))
+	}
+}
+
+// layoutTemplate resolves the layout template of the library, refusing a changed one
+func (a *app) layoutTemplate() (layout.Template, error) {
+	if a.layout != nil {
+		return *a.layout, nil
+	}
+
+	var template layout.Template
+	var err error
+	if a.opts.DryRun {
+		template, err = services.PreviewLayout(a.db, a.opts.Layout)
+	} else {
+		template, err = services.ResolveLayout(a.db, a.opts.Layout, a.logger)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrLayoutChanged) {
+			return layout.Template{}, withExitCode(exitConfig, err)
+		}
+		return layout.Template{}, withExitCode(exitDatabase, err)
+	}
+	a.layout = &template
+	return template, nil
+}
+
+func (a *app) undownloadedService() (services.UndownloadedService, error) {
+	downloader, err := a.downloadService()
+	if err != nil {
+		return services.UndownloadedService{}, err
+	}
+	return services.NewUndownloadedService(a.api, a.db, downloader, a.opts.IncludeShared, a.progress, a.logger), nil
+}
+
+func (a *app) syncService() (services.SyncService, error) {
+	template, err := a.layoutTemplate()
+	if err != nil {
+		return services.SyncService{}, err
+	}
+
+	downloader, err := a.downloadService()
+	if err != nil {
+		return services.SyncService{}, err
+	}
+	return services.NewSyncService(a.api, a.db, downloader, template, a.progress, a.logger), nil
+}
+
+// syncPlanner doesn't start the download workers, so nothing in the library is touched
+func (a *app) syncPlanner() (services.SyncPlanner, error) {
+	template, err := a.layoutTemplate()
+	if err != nil {
+		return services.SyncPlanner{}, err
+	}
+
+	photosApi, err := a.photosApi()
+	if err != nil {
+		return services.SyncPlanner{}, err
+	}
+	return services.NewSyncPlanner(photosApi, a.db, template, a.opts.LibraryRoot, a.opts.IncludeShared, a.logger), nil
+}
+
+func (a *app) albumService() (services.AlbumService, error) {
+	template, err := a.layoutTemplate()
+	if err != nil {
+		return services.AlbumService{}, err
+	}
+
+	downloader, err := a.downloadService()
+	if err != nil {
+		return services.AlbumService{}, err
+	}
+	return services.NewAlbumService(a.api, a.db, downloader, a.logger, services.AlbumOptions{
+		RootDir:       a.opts.LibraryRoot,
+		LinkMode:      a.opts.AlbumLinks,
+		Layout:        template,
+		IncludeShared: a.opts.IncludeShared,
+	}), nil
+}
+
+func (a *app) verifyService() services.VerifyService {
+	return services.NewVerifyService(a.db, a.opts.LibraryRoot, a.logger)
+}
+
+func (a *app) reconcileService() (services.ReconcileService, error) {
+	template, err := a.layoutTemplate()
+	if err != nil {
+		return services.ReconcileService{}, err
+	}
+
+	downloader, err := a.downloadService()
+	if err != nil {
+		return services.ReconcileService{}, err
+	}
+	return services.NewReconcileService(a.api, a.db, downloader, template, a.opts.LibraryRoot, a.progress, a.logger), nil
+}
+
+func (a *app) relayoutService() services.RelayoutService {
+	return services.NewRelayoutService(a.db, a.opts.LibraryRoot, a.logger)
+}
+
+// finishDownloads waits for queued downloads to complete. The workers are kept until the app is
+// closed, so the daemon reuses them for every pass
+func (a *app) finishDownloads() {
+	if a.download == nil {
+		return
+	}
+
+	a.download.Wait()
+	if a.stopReporting != nil {
+		a.stopReporting()
+		a.stopReporting = nil
+	}
+}
+
+// logUsage reports how much of today's api quotas has been used, including by earlier runs
+func (a *app) logUsage() {
+	if a.usage == nil {
+		return
+	}
+
+	usage, err := a.usage.Today()
+	if err != nil {
+		a.logger.Error.Printf("reading api usage failed: %s", err)
+		return
+	}
+	a.logger.Info.Printf("api usage today: %d metadata requests, %d media requests", usage.MetadataRequests, usage.MediaRequests)
+}
+
+// startRun records a run of mode as started, the returned function records how it ended. Failing
+// to record a run is only logged, it doesn't stop the run
+func (a *app) startRun(mode string) func(err error) {
+	run := database.Run{Mode: mode, StartedAt: time.Now()}
+	err := a.db.Runs.Start(&run)
+	if err != nil {
+		a.logger.Error.Printf("recording the start of the run failed: %s", err)
+		return func(error) {}
+	}
+
+	return func(err error) {
+		// downloads still going after a failure are counted too
+		a.finishDownloads()
+		counts := a.progress.Counts()
+		run.FinishedAt = time.Now()
+		run.Indexed = counts.Indexed
+		run.Downloaded = counts.Downloaded
+		run.Failed = counts.Failed
+		run.Bytes = counts.Bytes
+		if err != nil {
+			run.Error = err.Error()
+		}
+
+		err = a.db.Runs.Finish(run)
+		if err != nil {
+			a.logger.Error.Printf("recording the end of the run failed: %s", err)
+		}
+	}
+}
+
+// isTerminal reports whether out is a terminal, where a progress line can be redrawn
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (a *app) Close() {
+	a.finishDownloads()
+	if a.download != nil {
+		a.download.Finish()
+	}
+	a.logUsage()
+	if a.metricsServer != nil {
+		_ = a.metricsServer.Close()
+	}
+	err := a.db.Close()
+	if err != nil {
+		a.logger.Error.Print(err)
+	}
+}